@@ -0,0 +1,280 @@
+/**
+# Copyright 2025 Advanced Micro Devices, Inc. All rights reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the \"License\");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an \"AS IS\" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+**/
+
+// Simulates the allocation of AMD GPUs by the device plugin on a KFD
+// topology, such as the ones under testdata or the snapshots captured with
+// k8s-device-plugin snapshot
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/allocator"
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/config"
+)
+
+// simulation is the outcome of an allocation, printed as text or JSON
+type simulation struct {
+	Policy      string                    `json:"policy"`
+	Devices     []device                  `json:"devices"`
+	Available   []string                  `json:"available"`
+	Required    []string                  `json:"required,omitempty"`
+	Size        int                       `json:"size"`
+	Selected    []string                  `json:"selected"`
+	TotalWeight int                       `json:"totalWeight"`
+	Candidates  []candidate               `json:"candidates"`
+	PairWeights map[string]map[string]int `json:"pairWeights"`
+}
+
+type device struct {
+	Id           string `json:"id"`
+	NodeId       int    `json:"nodeId"`
+	DevId        string `json:"devId"`
+	NumaNode     int    `json:"numaNode"`
+	MemoryDomain int    `json:"memoryDomain,omitempty"`
+}
+
+type candidate struct {
+	Ids         []string `json:"ids"`
+	TotalWeight int      `json:"totalWeight"`
+}
+
+func splitIds(s string) []string {
+	var ids []string
+	for _, id := range strings.Split(s, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// pairWeight looks up the weight of a pair, keyed by the lowest node ID
+func pairWeight(weights map[int]map[int]int, a, b int) int {
+	if a > b {
+		a, b = b, a
+	}
+	return weights[a][b]
+}
+
+func totalWeight(weights map[int]map[int]int, nodeIds map[string]int, ids []string) int {
+	total := 0
+	for i := range ids {
+		for j := i + 1; j < len(ids); j++ {
+			total += pairWeight(weights, nodeIds[ids[i]], nodeIds[ids[j]])
+		}
+	}
+	return total
+}
+
+func sameIds(a, b []string) bool {
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(a, b)
+}
+
+func simulate(topology, policyName string, weights config.WeightsConfig, available, busy, required []string, size, count int) (*simulation, error) {
+	root, dir, cleanup, err := openTopology(topology)
+	defer cleanup()
+	if err != nil {
+		return nil, err
+	}
+	devices, err := loadDevices(root, dir)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := allocator.NewPolicy(policyName, weights)
+	if err != nil {
+		return nil, err
+	}
+	if policy == nil {
+		return nil, fmt.Errorf("policy %s leaves the allocation to the kubelet", policyName)
+	}
+	if err := policy.Init(devices, dir); err != nil {
+		return nil, err
+	}
+	p2pWeights, err := allocator.PairWeights(devices, dir, weights)
+	if err != nil {
+		return nil, err
+	}
+
+	sim := &simulation{
+		Policy:      policyName,
+		Required:    required,
+		Size:        size,
+		Candidates:  []candidate{},
+		PairWeights: make(map[string]map[string]int),
+	}
+	nodeIds := make(map[string]int)
+	for _, d := range devices {
+		nodeIds[d.Id] = d.NodeId
+		sim.Devices = append(sim.Devices, device{Id: d.Id, NodeId: d.NodeId, DevId: d.DevId, NumaNode: d.NumaNode, MemoryDomain: d.MemoryDomain})
+	}
+	if len(available) == 0 {
+		for _, d := range devices {
+			available = append(available, d.Id)
+		}
+	}
+	for _, id := range available {
+		if _, ok := nodeIds[id]; !ok {
+			return nil, fmt.Errorf("unknown device %s", id)
+		}
+		if !slices.Contains(busy, id) {
+			sim.Available = append(sim.Available, id)
+		}
+	}
+
+	sim.Selected, err = policy.Allocate(sim.Available, required, size)
+	if err != nil {
+		return nil, err
+	}
+	sim.TotalWeight = totalWeight(p2pWeights, nodeIds, sim.Selected)
+
+	if lister, ok := policy.(allocator.CandidateLister); ok && count > 0 {
+		candidates, err := lister.Candidates(sim.Available, required, size, count+1)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range candidates {
+			if len(sim.Candidates) == count {
+				break
+			}
+			if sameIds(c.Ids, sim.Selected) {
+				continue
+			}
+			sim.Candidates = append(sim.Candidates, candidate{Ids: c.Ids, TotalWeight: c.TotalWeight})
+		}
+	}
+
+	for _, a := range sim.Available {
+		sim.PairWeights[a] = make(map[string]int)
+		for _, b := range sim.Available {
+			if a != b {
+				sim.PairWeights[a][b] = pairWeight(p2pWeights, nodeIds[a], nodeIds[b])
+			}
+		}
+	}
+	return sim, nil
+}
+
+func printText(w io.Writer, sim *simulation) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DEVICE\tNODE\tGPU\tNUMA\tMEMORY")
+	for _, d := range sim.Devices {
+		memory := "-"
+		if d.MemoryDomain > 0 {
+			memory = strconv.Itoa(d.MemoryDomain)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%d\t%s\n", d.Id, d.NodeId, d.DevId, d.NumaNode, memory)
+	}
+	tw.Flush()
+
+	fmt.Fprintf(w, "\npolicy: %s\n", sim.Policy)
+	fmt.Fprintf(w, "selected: %s\n", strings.Join(sim.Selected, ","))
+	fmt.Fprintf(w, "total weight: %d\n", sim.TotalWeight)
+	if len(sim.Candidates) > 0 {
+		fmt.Fprintln(w, "runner-up candidates:")
+		for _, c := range sim.Candidates {
+			fmt.Fprintf(w, "  %s (total weight %d)\n", strings.Join(c.Ids, ","), c.TotalWeight)
+		}
+	}
+
+	// the matrix is labelled with the node IDs to keep it narrow
+	nodeIds := make(map[string]int)
+	for _, d := range sim.Devices {
+		nodeIds[d.Id] = d.NodeId
+	}
+	fmt.Fprintln(w, "\npair weights of the available devices by node:")
+	header := []string{""}
+	for _, id := range sim.Available {
+		header = append(header, strconv.Itoa(nodeIds[id]))
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, a := range sim.Available {
+		row := []string{strconv.Itoa(nodeIds[a])}
+		for _, b := range sim.Available {
+			if a == b {
+				row = append(row, "-")
+				continue
+			}
+			row = append(row, strconv.Itoa(sim.PairWeights[a][b]))
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	tw.Flush()
+}
+
+func main() {
+	var (
+		topoDir    = flag.String("topology", "", "KFD topology directory, ex: /sys/class/kfd/kfd or a copy of its topology/nodes, or a snapshot tarball")
+		configFile = flag.String("config", "", "device plugin configuration file providing the allocator policy and weights")
+		resource   = flag.String("resource", "gpu", "resource whose allocator policy is taken from the configuration")
+		policyName = flag.String("policy", "", "allocator policy, overriding the one of the configuration: "+strings.Join(config.Policies, ", "))
+		size       = flag.Int("size", 1, "number of devices requested")
+		available  = flag.String("available", "", "comma separated available devices, all of them if empty")
+		busy       = flag.String("busy", "", "comma separated devices already allocated")
+		required   = flag.String("required", "", "comma separated devices that must be allocated")
+		count      = flag.Int("candidates", 3, "number of runner-up candidates listed, if the policy scores them")
+		output     = flag.String("output", "text", "output format: text or json")
+	)
+	flag.Parse()
+
+	if *topoDir == "" {
+		fmt.Fprintln(os.Stderr, "-topology is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *output != "text" && *output != "json" {
+		fmt.Fprintf(os.Stderr, "invalid output format %s\n", *output)
+		os.Exit(2)
+	}
+	cfg := config.Default()
+	if *configFile != "" {
+		var err error
+		if cfg, err = config.Load(*configFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if *policyName == "" {
+		*policyName = cfg.AllocatorPolicy(*resource)
+	}
+
+	sim, err := simulate(*topoDir, *policyName, cfg.Allocator.Weights, splitIds(*available), splitIds(*busy), splitIds(*required), *size, *count)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(sim); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	printText(os.Stdout, sim)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/amdgpu"
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/config"
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/snapshot"
+)
+
+func TestLoadDevices(t *testing.T) {
+	devices, err := loadKFDDevices(nodesDir("../../testdata/topo-mi300-cpx"))
+	if err != nil {
+		t.Fatalf("expected the topology to load. But failed with error %v", err)
+	}
+	// the snapshot has the partitions of 8 GPUs on nodes 2 to 64
+	if len(devices) != 63 {
+		t.Fatalf("expected 63 devices but got %d", len(devices))
+	}
+	first, second := devices[0], devices[1]
+	if first.Id != "0000:05:00.0" || first.NodeId != 2 || first.RenderD != 128 {
+		t.Errorf("unexpected first device %+v", first)
+	}
+	if second.Id != "amdgpu_xcp_1" || second.DevId != first.DevId {
+		t.Errorf("expected the second device to be a partition of the first one, got %+v", second)
+	}
+	numaNodes := make(map[int]int)
+	for _, d := range devices {
+		numaNodes[d.NumaNode]++
+	}
+	if numaNodes[0] != 32 || numaNodes[1] != 31 {
+		t.Errorf("expected the devices to be split over 2 NUMA nodes, got %v", numaNodes)
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	topo := "../../testdata/topo-mi210-xgmi-pcie/nodes"
+	busy := []string{"0000:63:00.0"}
+	sim, err := simulate(topo, config.PolicyBestEffort, config.DefaultWeights(), nil, busy, nil, 3, 2)
+	if err != nil {
+		t.Fatalf("expected the simulation to pass. But failed with error %v", err)
+	}
+	if len(sim.Available) != 7 || slices.Contains(sim.Available, busy[0]) {
+		t.Errorf("expected all the devices but the busy one to be available, got %v", sim.Available)
+	}
+	if len(sim.Selected) != 3 || slices.Contains(sim.Selected, busy[0]) {
+		t.Errorf("unexpected selection %v", sim.Selected)
+	}
+	if sim.TotalWeight != sumPairs(sim, sim.Selected) {
+		t.Errorf("total weight %d is not the sum of the pair weights of %v", sim.TotalWeight, sim.Selected)
+	}
+	if len(sim.Candidates) != 2 {
+		t.Fatalf("expected 2 runner-up candidates but got %+v", sim.Candidates)
+	}
+	for _, c := range sim.Candidates {
+		if c.TotalWeight < sim.TotalWeight || sameIds(c.Ids, sim.Selected) {
+			t.Errorf("unexpected runner-up candidate %+v", c)
+		}
+	}
+
+	// policies without scores have no runner-up
+	sim, err = simulate(topo, config.PolicySpread, config.DefaultWeights(), nil, nil, nil, 2, 2)
+	if err != nil {
+		t.Fatalf("expected the simulation to pass. But failed with error %v", err)
+	}
+	if len(sim.Selected) != 2 || len(sim.Candidates) != 0 {
+		t.Errorf("unexpected simulation %+v", sim)
+	}
+
+	if _, err := simulate(topo, config.PolicyNone, config.DefaultWeights(), nil, nil, nil, 2, 2); err == nil {
+		t.Errorf("expected the none policy to fail")
+	}
+	if _, err := simulate(topo, config.PolicyBestEffort, config.DefaultWeights(), []string{"unknown"}, nil, nil, 1, 0); err == nil {
+		t.Errorf("expected an unknown device to fail")
+	}
+}
+
+// newSysfsRoot lays out a sysfs root around a KFD topology, binding its GPUs
+// to the amdgpu driver, all but the first render node of a GPU being
+// amdgpu_xcp_* partitions, with the given memory partition
+func newSysfsRoot(t *testing.T, topoDir, memoryPartition string) string {
+	t.Helper()
+	topoAbs, err := filepath.Abs(topoDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "class/kfd/kfd"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(topoAbs, "topology"), filepath.Join(root, snapshot.TopologyDir)); err != nil {
+		t.Fatal(err)
+	}
+	writeFile := func(path, content string) {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	renderDevIds := amdgpu.GetDevIdsFromTopology(topoAbs)
+	renders := make([]int, 0, len(renderDevIds))
+	for renderD := range renderDevIds {
+		renders = append(renders, renderD)
+	}
+	slices.Sort(renders)
+	seen := make(map[string]bool)
+	for i, renderD := range renders {
+		devId := renderDevIds[renderD]
+		path := filepath.Join(root, snapshot.PlatformDir, fmt.Sprintf("amdgpu_xcp_%d", i))
+		if !seen[devId] {
+			seen[devId] = true
+			path = filepath.Join(root, snapshot.PciDriverDir, devId[:len(devId)-2]+".0")
+			writeFile(filepath.Join(path, "current_compute_partition"), "CPX")
+			writeFile(filepath.Join(path, "current_memory_partition"), memoryPartition)
+			writeFile(filepath.Join(path, "numa_node"), "0")
+		}
+		writeFile(filepath.Join(path, "drm", fmt.Sprintf("card%d", i), "dev"), "")
+		writeFile(filepath.Join(path, "drm", fmt.Sprintf("renderD%d", renderD), "dev"), "")
+	}
+	return root
+}
+
+func TestSimulateSnapshot(t *testing.T) {
+	root := newSysfsRoot(t, "../../testdata/topology-parsing-mi308", "NPS2")
+	tarball := filepath.Join(t.TempDir(), "node.tar.gz")
+	f, err := os.Create(tarball)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := snapshot.Capture(f, root); err != nil {
+		t.Fatalf("expected Capture to pass. But failed with error %v", err)
+	}
+	f.Close()
+
+	for _, topology := range []string{tarball, root} {
+		// the partitions of the first GPU, 2 in each memory partition
+		sim, err := simulate(topology, config.PolicyBestEffort, config.DefaultWeights(), []string{"0000:0a:00.0", "amdgpu_xcp_1", "amdgpu_xcp_2", "amdgpu_xcp_3"}, nil, nil, 2, 1)
+		if err != nil {
+			t.Fatalf("expected the simulation to pass. But failed with error %v", err)
+		}
+		// the devices are discovered as the plugin does
+		if len(sim.Devices) != 32 || sim.Devices[0].MemoryDomain != 1 || sim.Devices[3].MemoryDomain != 2 {
+			t.Errorf("%s: unexpected devices %+v", topology, sim.Devices)
+		}
+		slices.Sort(sim.Selected)
+		if !slices.Equal(sim.Selected, []string{"0000:0a:00.0", "amdgpu_xcp_1"}) && !slices.Equal(sim.Selected, []string{"amdgpu_xcp_2", "amdgpu_xcp_3"}) {
+			t.Errorf("%s: expected partitions of the same memory partition, got %v", topology, sim.Selected)
+		}
+	}
+}
+
+func sumPairs(sim *simulation, ids []string) int {
+	total := 0
+	for i := range ids {
+		for j := i + 1; j < len(ids); j++ {
+			total += sim.PairWeights[ids[i]][ids[j]]
+		}
+	}
+	return total
+}
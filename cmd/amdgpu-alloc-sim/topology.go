@@ -0,0 +1,233 @@
+/**
+# Copyright 2025 Advanced Micro Devices, Inc. All rights reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the \"License\");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an \"AS IS\" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+**/
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/allocator"
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/amdgpu"
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/config"
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/plugin"
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/snapshot"
+)
+
+// topologyNode is a KFD topology node and the properties of its links
+type topologyNode struct {
+	id         int
+	properties map[string]int64
+	links      []map[string]int64
+}
+
+// nodesDir returns the directory holding the KFD topology nodes, accepting
+// the nodes directory itself, a directory with a topology/nodes
+// subdirectory such as /sys/class/kfd/kfd, or a sysfs root such as an
+// extracted snapshot
+func nodesDir(dir string) string {
+	for _, sub := range []string{filepath.Join(snapshot.TopologyDir, "nodes"), filepath.Join("topology", "nodes")} {
+		nodes := filepath.Join(dir, sub)
+		if info, err := os.Stat(nodes); err == nil && info.IsDir() {
+			return nodes
+		}
+	}
+	return dir
+}
+
+// sysfsRoot returns dir if it is a sysfs root, such as /sys or an extracted
+// snapshot, or an empty string
+func sysfsRoot(dir string) string {
+	if info, err := os.Stat(filepath.Join(dir, snapshot.TopologyDir, "nodes")); err == nil && info.IsDir() {
+		return dir
+	}
+	return ""
+}
+
+// openTopology returns the sysfs root of a topology, empty if it is only a
+// KFD topology, and the directory of its KFD nodes, extracting it first if
+// it's a snapshot tarball. cleanup removes what was extracted.
+func openTopology(topology string) (root, nodes string, cleanup func(), err error) {
+	cleanup = func() {}
+	info, err := os.Stat(topology)
+	if err != nil {
+		return "", "", cleanup, err
+	}
+	if info.IsDir() {
+		return sysfsRoot(topology), nodesDir(topology), cleanup, nil
+	}
+	tmp, err := os.MkdirTemp("", "amdgpu-snapshot")
+	if err != nil {
+		return "", "", cleanup, err
+	}
+	cleanup = func() { os.RemoveAll(tmp) }
+	if err := snapshot.ExtractFile(topology, tmp); err != nil {
+		return "", "", cleanup, err
+	}
+	return sysfsRoot(tmp), nodesDir(tmp), cleanup, nil
+}
+
+// readProperties parses a KFD properties file, one <name> <value> entry
+// per line
+func readProperties(path string) (map[string]int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	properties := make(map[string]int64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		// unique_id and hive_id don't fit in an int64, they are only
+		// compared so their bits are kept as is
+		v, err := strconv.ParseUint(fields[1], 0, 64)
+		if err != nil {
+			continue
+		}
+		properties[fields[0]] = int64(v)
+	}
+	return properties, scanner.Err()
+}
+
+func readNodes(dir string) ([]*topologyNode, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*", "properties"))
+	if err != nil {
+		return nil, err
+	}
+	var nodes []*topologyNode
+	for _, path := range paths {
+		nodeDir := filepath.Dir(path)
+		id, err := strconv.Atoi(filepath.Base(nodeDir))
+		if err != nil {
+			continue
+		}
+		properties, err := readProperties(path)
+		if err != nil {
+			return nil, err
+		}
+		node := &topologyNode{id: id, properties: properties}
+		links, _ := filepath.Glob(filepath.Join(nodeDir, "io_links", "*", "properties"))
+		for _, link := range links {
+			properties, err := readProperties(link)
+			if err != nil {
+				return nil, err
+			}
+			node.links = append(node.links, properties)
+		}
+		nodes = append(nodes, node)
+	}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no KFD topology node found in %s", dir)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].id < nodes[j].id
+	})
+	return nodes, nil
+}
+
+// loadDevices builds the devices the plugin would advertise. They are
+// discovered as the plugin does from a sysfs root, and only read from the
+// KFD nodes if there is none or no GPU is bound to the amdgpu driver in it,
+// as for the topologies under testdata.
+func loadDevices(root, nodes string) ([]*allocator.Device, error) {
+	if root != "" {
+		if devices := discoverDevices(root); len(devices) > 0 {
+			return devices, nil
+		}
+		fmt.Fprintf(os.Stderr, "No GPU bound to amdgpu found in %s, reading the devices from the KFD topology\n", root)
+	}
+	return loadKFDDevices(nodes)
+}
+
+// discoverDevices returns the devices the plugin advertises on a sysfs root,
+// sorted by KFD node
+func discoverDevices(root string) []*allocator.Device {
+	defer func(root string, useHwloc bool) {
+		amdgpu.SysfsRoot, amdgpu.UseHwloc = root, useHwloc
+	}(amdgpu.SysfsRoot, amdgpu.UseHwloc)
+	// hwloc would report the NUMA nodes of the machine running the simulator
+	amdgpu.SysfsRoot, amdgpu.UseHwloc = root, false
+	devices := plugin.AllocatorDevices(amdgpu.GetGPUs())
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].NodeId < devices[j].NodeId
+	})
+	return devices
+}
+
+// loadKFDDevices builds the devices from the nodes of a KFD topology alone.
+// The first node of a GPU is named after its PCI address and the other ones,
+// its compute partitions, amdgpu_xcp_<render minor - 128>. The NUMA node of a
+// GPU is the CPU node it has a PCIe link to, and the memory partitions of the
+// partitions are unknown.
+func loadKFDDevices(dir string) ([]*allocator.Device, error) {
+	nodes, err := readNodes(dir)
+	if err != nil {
+		return nil, err
+	}
+	cpus := make(map[int]bool)
+	for _, node := range nodes {
+		if node.properties["drm_render_minor"] <= 0 {
+			cpus[node.id] = true
+		}
+	}
+	var devices []*allocator.Device
+	seen := make(map[string]bool)
+	for _, node := range nodes {
+		minor := int(node.properties["drm_render_minor"])
+		if minor <= 0 {
+			continue
+		}
+		location := node.properties["location_id"]
+		domain := node.properties["domain"]
+		bus := (location >> 8) & 0xff
+		dev := (location >> 3) & 0x1f
+		// same format as the plugin, shared by a GPU and its partitions
+		devId := fmt.Sprintf("%04x:%02x:%02x:0", domain, bus, dev)
+		id := fmt.Sprintf("%04x:%02x:%02x.%x", domain, bus, dev, location&0x7)
+		if seen[devId] {
+			id = fmt.Sprintf("amdgpu_xcp_%d", minor-128)
+		}
+		seen[devId] = true
+		numaNode := -1
+		for _, link := range node.links {
+			to := int(link["node_to"])
+			if link["type"] == config.LinkTypePCIe && cpus[to] {
+				numaNode = to
+				break
+			}
+		}
+		devices = append(devices, &allocator.Device{
+			Id:       id,
+			NodeId:   node.id,
+			NumaNode: numaNode,
+			DevId:    devId,
+			RenderD:  minor,
+		})
+	}
+	if len(devices) == 0 {
+		return nil, fmt.Errorf("no GPU found in the KFD topology %s", dir)
+	}
+	return devices, nil
+}
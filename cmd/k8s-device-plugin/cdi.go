@@ -0,0 +1,55 @@
+/**
+ * Copyright 2025 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/amdgpu"
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/cdi"
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/config"
+)
+
+// newCDISpec returns the CDI spec of the GPUs currently on the node
+func newCDISpec(cfg *config.Config) *cdi.Spec {
+	return cdi.NewSpec(cfg.ResourceNamespace, amdgpu.GetGPUs(), cfg.Allocate.InjectKFD)
+}
+
+// generateCDI implements the generate-cdi command, writing the CDI spec of
+// the node into a directory or to stdout and exiting
+func generateCDI(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("generate-cdi", flag.ExitOnError)
+	outputDir := fs.String("output_dir", cfg.CDI.SpecDir, "Directory the CDI spec is written to, - for stdout")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] generate-cdi [-output_dir dir]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	spec := newCDISpec(cfg)
+	if *outputDir == "-" {
+		return spec.Encode(os.Stdout)
+	}
+	path, err := spec.Write(*outputDir)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Wrote CDI spec for %d devices to %s\n", len(spec.Devices), path)
+	return nil
+}
@@ -0,0 +1,84 @@
+/**
+ * Copyright 2025 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/config"
+	"github.com/golang/glog"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const apiTimeout = 30 * time.Second
+
+// newClientset returns a client for the API server the pod is running in
+func newClientset() (*kubernetes.Clientset, error) {
+	restConfig, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get in-cluster config: %v", err)
+	}
+	return kubernetes.NewForConfig(restConfig)
+}
+
+// getNodeLabels fetches the labels of a node from the API server
+func getNodeLabels(nodeName string) (map[string]string, error) {
+	if nodeName == "" {
+		return nil, fmt.Errorf("node name is required to select nodeOverrides by label, set DS_NODE_NAME or -node_name")
+	}
+	clientset, err := newClientset()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+	defer cancel()
+	node, err := clientset.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get node %s: %v", nodeName, err)
+	}
+	return node.Labels, nil
+}
+
+// loadConfig reads the configuration file, if any, and resolves the
+// overrides applying to this node. The labels of the node are only fetched
+// from the API server if lookupLabels is set, otherwise the overrides
+// selecting nodes by label are skipped.
+func loadConfig(path, nodeName string, lookupLabels bool) (*config.Config, error) {
+	if path == "" {
+		return config.Default(), nil
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	var nodeLabels map[string]string
+	if cfg.NeedsNodeLabels() && lookupLabels {
+		if nodeLabels, err = getNodeLabels(nodeName); err != nil {
+			return nil, err
+		}
+	}
+	cfg, err = cfg.ForNode(nodeName, nodeLabels)
+	if err != nil {
+		return nil, err
+	}
+	glog.Infof("Loaded configuration from %s: %+v", path, *cfg)
+	return cfg, nil
+}
@@ -21,10 +21,15 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"slices"
+	"sort"
 	"time"
 
 	"github.com/ROCm/k8s-device-plugin/internal/pkg/amdgpu"
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/exporter"
 	"github.com/ROCm/k8s-device-plugin/internal/pkg/hwloc"
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/metrics"
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/nodehealth"
 	"github.com/ROCm/k8s-device-plugin/internal/pkg/plugin"
 	"github.com/golang/glog"
 	"github.com/kubevirt/device-plugin-manager/pkg/dpm"
@@ -55,8 +60,9 @@ func getResourceList(resourceNamingStrategy ResourceNamingStrategy) ([]string, e
 
 	// Check if the node is homogeneous
 	isHomogeneous := amdgpu.IsHomogeneous()
-	partitionCountMap := amdgpu.UniquePartitionConfigCount(amdgpu.GetAMDGPUs())
-	if len(amdgpu.GetAMDGPUs()) == 0 {
+	gpus := amdgpu.GetGPUs()
+	partitionCountMap := amdgpu.UniquePartitionConfigCount(gpus)
+	if len(gpus) == 0 {
 		return resources, nil
 	}
 	if isHomogeneous {
@@ -102,20 +108,87 @@ func main() {
 			fmt.Fprintf(os.Stderr, "%s\n", v)
 		}
 		fmt.Fprintln(os.Stderr, "Usage:")
+		fmt.Fprintf(os.Stderr, "  %s [flags]                 run the device plugin\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags] generate-cdi    write the CDI spec of the node and exit\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags] snapshot        write a tarball of the GPU topology of the node and exit\n", os.Args[0])
 		flag.PrintDefaults()
 	}
 	var pulse int
 	var resourceNamingStrategy string
+	var watchInterval int
+	var metricsAddress string
+	var exporterSocket string
+	var configFile string
+	var nodeName string
+	flag.StringVar(&configFile, "config", os.Getenv("CONFIG_FILE_PATH"), "Path of the YAML or JSON configuration file. Flags set on the command line take precedence over it.")
+	flag.StringVar(&nodeName, "node_name", os.Getenv("DS_NODE_NAME"), "Name of the node, used to select the per-node overrides of the configuration")
 	flag.IntVar(&pulse, "pulse", 0, "time between health check polling in seconds.  Set to 0 to disable.")
 	flag.StringVar(&resourceNamingStrategy, "resource_naming_strategy", "single", "Resource strategy to be used: single or mixed")
+	flag.IntVar(&watchInterval, "watch_interval", 30, "time between polls of sysfs for GPU hot-plug and repartitioning in seconds.  Set to 0 to disable.")
+	flag.StringVar(&exporterSocket, "exporter_socket", "", "Unix socket of the amd-metrics-exporter health service")
+	flag.StringVar(&metricsAddress, "metrics_address", "", "Address the Prometheus /metrics endpoint listens on, ex: :9110.  Disabled if empty.")
+	flag.StringVar(&amdgpu.SysfsRoot, "sysfs_root", amdgpu.SysfsRoot, "Root of the sysfs tree used for GPU discovery")
+	flag.StringVar(&amdgpu.DevfsRoot, "devfs_root", amdgpu.DevfsRoot, "Root of the device nodes used for GPU discovery")
+	flag.BoolVar(&amdgpu.UseHwloc, "hwloc", amdgpu.UseHwloc, "Consult hwloc for the NUMA nodes of the GPUs sysfs doesn't resolve. Disable it when -sysfs_root is not the sysfs of the node, ex: a snapshot")
 	// this is also needed to enable glog usage in dpm
 	flag.Parse()
-	strategy, err := ParseStrategy(resourceNamingStrategy)
+
+	// the snapshot only reads sysfs, it doesn't need the configuration
+	if flag.Arg(0) == "snapshot" {
+		if err := captureSnapshot(flag.Args()[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// the commands run outside of the cluster too, without credentials for
+	// the API server
+	cfg, err := loadConfig(configFile, nodeName, flag.NArg() == 0)
+	if err != nil {
+		glog.Errorf("%v", err)
+		os.Exit(1)
+	}
+	// command line flags take precedence over the configuration file
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "pulse":
+			cfg.Pulse = pulse
+		case "resource_naming_strategy":
+			cfg.ResourceNamingStrategy = resourceNamingStrategy
+		case "watch_interval":
+			cfg.WatchInterval = watchInterval
+		case "metrics_address":
+			cfg.Metrics.Address = metricsAddress
+		case "exporter_socket":
+			cfg.Exporter.SocketPath = exporterSocket
+		}
+	})
+	if err := cfg.Validate(); err != nil {
+		glog.Errorf("invalid configuration: %v", err)
+		os.Exit(1)
+	}
+	strategy, err := ParseStrategy(cfg.ResourceNamingStrategy)
 	if err != nil {
 		glog.Errorf("%v", err)
 		os.Exit(1)
 	}
 
+	if flag.NArg() > 0 {
+		switch flag.Arg(0) {
+		case "generate-cdi":
+			if err := generateCDI(cfg, flag.Args()[1:]); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+		default:
+			fmt.Fprintf(os.Stderr, "unknown command %s\n", flag.Arg(0))
+			flag.Usage()
+			os.Exit(2)
+		}
+		return
+	}
+
 	for _, v := range versions {
 		glog.Infof("%s", v)
 	}
@@ -123,31 +196,107 @@ func main() {
 	l := plugin.AMDGPULister{
 		ResUpdateChan: make(chan dpm.PluginNameList),
 		Heartbeat:     make(chan bool),
+		Config:        cfg,
+	}
+	if cfg.WatchInterval > 0 {
+		l.Watcher = amdgpu.NewWatcher(time.Second * time.Duration(cfg.WatchInterval))
+		go l.Watcher.Run(make(chan struct{}))
+	}
+	// the exporter is queried every pulse unless it is polled on its own
+	if cfg.Exporter.PollInterval > 0 || cfg.Pulse > 0 {
+		l.Exporter, err = exporter.NewClient(cfg.Exporter.SocketPath,
+			time.Second*time.Duration(cfg.Exporter.PollInterval),
+			time.Second*time.Duration(cfg.Exporter.StaleAfter))
+		if err != nil {
+			glog.Errorf("Unable to create amd-metrics-exporter client: %v", err)
+			os.Exit(1)
+		}
+		if cfg.Exporter.PollInterval > 0 {
+			go l.Exporter.Run(make(chan struct{}))
+		}
+	}
+	if cfg.Events.Enabled || cfg.Events.NodeCondition {
+		if nodeName == "" {
+			glog.Errorf("node name is required to report device health on the node, set DS_NODE_NAME or -node_name")
+			os.Exit(1)
+		}
+		clientset, err := newClientset()
+		if err != nil {
+			glog.Errorf("Unable to report device health on the node: %v", err)
+			os.Exit(1)
+		}
+		l.Reporter = nodehealth.NewReporter(clientset, nodeName, cfg.Events)
 	}
 	manager := dpm.NewManager(&l)
 
-	if pulse > 0 {
+	if cfg.Metrics.Address != "" {
 		go func() {
-			glog.Infof("Heart beating every %d seconds", pulse)
+			if err := metrics.Serve(cfg.Metrics.Address); err != nil {
+				glog.Errorf("Unable to serve metrics: %v", err)
+			}
+		}()
+	}
+
+	if cfg.Pulse > 0 {
+		go func() {
+			glog.Infof("Heart beating every %d seconds", cfg.Pulse)
 			for {
-				time.Sleep(time.Second * time.Duration(pulse))
+				time.Sleep(time.Second * time.Duration(cfg.Pulse))
 				l.Heartbeat <- true
 			}
 		}()
 	}
 
 	go func() {
-		// /sys/class/kfd only exists if ROCm kernel/driver is installed
-		var path = "/sys/class/kfd"
-		if _, err := os.Stat(path); err == nil {
-			resources, err := getResourceList(strategy)
-			if err != nil {
-				glog.Errorf("Error occured: %v", err)
-				os.Exit(1)
+		var updates <-chan struct{}
+		if l.Watcher != nil {
+			updates = l.Watcher.Subscribe()
+		}
+		var advertised []string
+		var retry <-chan time.Time
+		started := false
+		for {
+			// /sys/class/kfd only exists if ROCm kernel/driver is installed
+			var path = amdgpu.SysfsPath("class/kfd")
+			if _, err := os.Stat(path); err == nil {
+				// the spec is refreshed before the devices are advertised
+				// so that they can be resolved as soon as they are allocated
+				if cfg.CDI.Enabled {
+					if specPath, err := newCDISpec(cfg).Write(cfg.CDI.SpecDir); err != nil {
+						glog.Errorf("Unable to write CDI spec: %v", err)
+					} else {
+						glog.Infof("Wrote CDI spec %s", specPath)
+					}
+				}
+				resources, err := getResourceList(strategy)
+				if err != nil && !started {
+					glog.Errorf("Error occured: %v", err)
+					os.Exit(1)
+				}
+				started = true
+				sort.Strings(resources)
+				// only notify the manager when the resources change, plugins
+				// of unchanged resources pick up device changes on their own.
+				// The node is briefly heterogeneous while its GPUs are
+				// repartitioned, the resources advertised are kept until the
+				// next update.
+				if err != nil {
+					glog.Errorf("Unable to update the resources, keeping %v: %v", advertised, err)
+					retry = time.After(time.Second * time.Duration(cfg.WatchInterval))
+				} else if !slices.Equal(resources, advertised) {
+					glog.Infof("Advertising resources: %v", resources)
+					l.ResUpdateChan <- resources
+					advertised = resources
+				}
+			}
+			if updates == nil {
+				return
 			}
-			if len(resources) > 0 {
-				l.ResUpdateChan <- resources
+			select {
+			case <-updates:
+			case <-retry:
 			}
+			retry = nil
 		}
 	}()
 	manager.Run()
@@ -0,0 +1,55 @@
+/**
+ * Copyright 2025 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/amdgpu"
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/snapshot"
+)
+
+// captureSnapshot writes a tarball of the sysfs files GPU discovery reads,
+// to be attached to bug reports and loaded back with -sysfs_root
+func captureSnapshot(args []string) error {
+	fs := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	output := fs.String("output", "-", "File the snapshot is written to, - for stdout")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] snapshot [-output file]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *output == "-" {
+		return snapshot.Capture(os.Stdout, amdgpu.SysfsRoot)
+	}
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := snapshot.Capture(f, amdgpu.SysfsRoot); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Wrote snapshot of %s to %s\n", amdgpu.SysfsRoot, *output)
+	return nil
+}
@@ -11,6 +11,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/allocator"
 	"github.com/ROCm/k8s-device-plugin/internal/pkg/amdgpu"
 	corev1 "k8s.io/api/core/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
@@ -112,15 +113,15 @@ var reSimdCount = regexp.MustCompile(`simd_count\s(\d+)`)
 var reSimdPerCu = regexp.MustCompile(`simd_per_cu\s(\d+)`)
 var reDrmRenderMinor = regexp.MustCompile(`drm_render_minor\s(\d+)`)
 
-var labelGenerators = map[string]func(map[string]map[string]interface{}) map[string]string{
-	"firmware": func(gpus map[string]map[string]interface{}) map[string]string {
+var labelGenerators = map[string]func(map[string]*amdgpu.GPU) map[string]string{
+	"firmware": func(gpus map[string]*amdgpu.GPU) map[string]string {
 		counts := map[string]int{}
 
 		for _, v := range gpus {
 			var featVersions map[string]uint32
 			var fwVersions map[string]uint32
 
-			featVersions, fwVersions, err := amdgpu.GetFirmwareVersions(fmt.Sprintf("card%d", v["card"]))
+			featVersions, fwVersions, err := amdgpu.GetFirmwareVersions(fmt.Sprintf("card%d", v.Card))
 			if err != nil {
 				log.Error(err, "Fail to get firmware versions")
 				continue
@@ -141,11 +142,11 @@ var labelGenerators = map[string]func(map[string]map[string]interface{}) map[str
 		}
 		return results
 	},
-	"family": func(gpus map[string]map[string]interface{}) map[string]string {
+	"family": func(gpus map[string]*amdgpu.GPU) map[string]string {
 		counts := map[string]int{}
 
 		for _, v := range gpus {
-			fid, err := amdgpu.GetCardFamilyName(fmt.Sprintf("card%d", v["card"]))
+			fid, err := amdgpu.GetCardFamilyName(fmt.Sprintf("card%d", v.Card))
 			if err != nil {
 				log.Error(err, "Fail to get card family name.")
 				continue
@@ -155,10 +156,10 @@ var labelGenerators = map[string]func(map[string]map[string]interface{}) map[str
 
 		return createLabels("family", counts)
 	},
-	"driver-version": func(gpus map[string]map[string]interface{}) map[string]string {
+	"driver-version": func(gpus map[string]*amdgpu.GPU) map[string]string {
 		version := ""
 		for _, v := range gpus {
-			versionPath := fmt.Sprintf("/sys/class/drm/card%d/device/driver/module/version", v["card"])
+			versionPath := amdgpu.SysfsPath(fmt.Sprintf("class/drm/card%d/device/driver/module/version", v.Card))
 			b, err := ioutil.ReadFile(versionPath)
 			if err != nil {
 				log.Error(err, versionPath)
@@ -171,10 +172,10 @@ var labelGenerators = map[string]func(map[string]map[string]interface{}) map[str
 		pfx := createLabelPrefix("driver-version", false)
 		return map[string]string{pfx: version}
 	},
-	"driver-src-version": func(gpus map[string]map[string]interface{}) map[string]string {
+	"driver-src-version": func(gpus map[string]*amdgpu.GPU) map[string]string {
 		version := ""
 		for _, v := range gpus {
-			versionPath := fmt.Sprintf("/sys/class/drm/card%d/device/driver/module/srcversion", v["card"])
+			versionPath := amdgpu.SysfsPath(fmt.Sprintf("class/drm/card%d/device/driver/module/srcversion", v.Card))
 			b, err := ioutil.ReadFile(versionPath)
 			if err != nil {
 				log.Error(err, versionPath)
@@ -187,11 +188,11 @@ var labelGenerators = map[string]func(map[string]map[string]interface{}) map[str
 		pfx := createLabelPrefix("driver-src-version", false)
 		return map[string]string{pfx: version}
 	},
-	"device-id": func(gpus map[string]map[string]interface{}) map[string]string {
+	"device-id": func(gpus map[string]*amdgpu.GPU) map[string]string {
 		counts := map[string]int{}
 
 		for _, v := range gpus {
-			devidPath := fmt.Sprintf("/sys/class/drm/card%d/device/device", v["card"])
+			devidPath := amdgpu.SysfsPath(fmt.Sprintf("class/drm/card%d/device/device", v.Card))
 			b, err := ioutil.ReadFile(devidPath)
 			if err != nil {
 				log.Error(err, devidPath)
@@ -206,12 +207,12 @@ var labelGenerators = map[string]func(map[string]map[string]interface{}) map[str
 
 		return createLabels("device-id", counts)
 	},
-	"product-name": func(gpus map[string]map[string]interface{}) map[string]string {
+	"product-name": func(gpus map[string]*amdgpu.GPU) map[string]string {
 		counts := map[string]int{}
 		replacer := strings.NewReplacer(" ", "_", "(", "", ")", "")
 
 		for _, v := range gpus {
-			prodnamePath := fmt.Sprintf("/sys/class/drm/card%d/device/product_name", v["card"])
+			prodnamePath := amdgpu.SysfsPath(fmt.Sprintf("class/drm/card%d/device/product_name", v.Card))
 			b, err := ioutil.ReadFile(prodnamePath)
 			if err != nil {
 				log.Error(err, prodnamePath)
@@ -219,7 +220,7 @@ var labelGenerators = map[string]func(map[string]map[string]interface{}) map[str
 			prodName := replacer.Replace(strings.TrimSpace(string(b)))
 			// if we are not able to get the product name from sysfs, try to read the value using libdrm
 			if prodName == "" {
-				prodName, err = amdgpu.GetCardProductName(fmt.Sprintf("card%d", v["card"]))
+				prodName, err = amdgpu.GetCardProductName(fmt.Sprintf("card%d", v.Card))
 				if err != nil {
 					log.Error(err, prodnamePath)
 				} else {
@@ -234,11 +235,11 @@ var labelGenerators = map[string]func(map[string]map[string]interface{}) map[str
 
 		return createLabels("product-name", counts)
 	},
-	"vram": func(gpus map[string]map[string]interface{}) map[string]string {
+	"vram": func(gpus map[string]*amdgpu.GPU) map[string]string {
 		const bytePerMB = int64(1024 * 1024)
 		counts := map[string]int{}
 
-		propertiesPath := "/sys/class/kfd/kfd/topology/nodes/*/properties"
+		propertiesPath := amdgpu.SysfsPath("class/kfd/kfd/topology/nodes") + "/*/properties"
 		var files []string
 		var err error
 
@@ -253,13 +254,13 @@ var labelGenerators = map[string]func(map[string]map[string]interface{}) map[str
 			for _, file := range files {
 				render_minor, _ := amdgpu.ParseTopologyProperties(file, reDrmRenderMinor)
 
-				if int(render_minor) != gpu["renderD"] {
+				if int(render_minor) != gpu.RenderD {
 					continue
 				}
 				parts := strings.Split(file, "/")
 				nodeNumber := parts[len(parts)-2]
 
-				vramTotalPath := fmt.Sprintf("/sys/class/kfd/kfd/topology/nodes/%s/mem_banks/0/properties", nodeNumber)
+				vramTotalPath := amdgpu.SysfsPath("class/kfd/kfd/topology/nodes", nodeNumber, "mem_banks/0/properties")
 
 				vSize, err := amdgpu.ParseTopologyProperties(vramTotalPath, reSizeInBytes)
 				if err != nil {
@@ -276,10 +277,10 @@ var labelGenerators = map[string]func(map[string]map[string]interface{}) map[str
 
 		return createLabels("vram", counts)
 	},
-	"simd-count": func(gpus map[string]map[string]interface{}) map[string]string {
+	"simd-count": func(gpus map[string]*amdgpu.GPU) map[string]string {
 		counts := map[string]int{}
 
-		propertiesPath := "/sys/class/kfd/kfd/topology/nodes/*/properties"
+		propertiesPath := amdgpu.SysfsPath("class/kfd/kfd/topology/nodes") + "/*/properties"
 		var files []string
 		var err error
 
@@ -295,7 +296,7 @@ var labelGenerators = map[string]func(map[string]map[string]interface{}) map[str
 			for _, file := range files {
 				render_minor, _ := amdgpu.ParseTopologyProperties(file, reDrmRenderMinor)
 
-				if int(render_minor) != gpu["renderD"] {
+				if int(render_minor) != gpu.RenderD {
 					continue
 				}
 
@@ -312,10 +313,10 @@ var labelGenerators = map[string]func(map[string]map[string]interface{}) map[str
 
 		return createLabels("simd-count", counts)
 	},
-	"cu-count": func(gpus map[string]map[string]interface{}) map[string]string {
+	"cu-count": func(gpus map[string]*amdgpu.GPU) map[string]string {
 		counts := map[string]int{}
 
-		propertiesPath := "/sys/class/kfd/kfd/topology/nodes/*/properties"
+		propertiesPath := amdgpu.SysfsPath("class/kfd/kfd/topology/nodes") + "/*/properties"
 		var files []string
 		var err error
 
@@ -331,7 +332,7 @@ var labelGenerators = map[string]func(map[string]map[string]interface{}) map[str
 			for _, file := range files {
 				render_minor, _ := amdgpu.ParseTopologyProperties(file, reDrmRenderMinor)
 
-				if int(render_minor) != gpu["renderD"] {
+				if int(render_minor) != gpu.RenderD {
 					continue
 				}
 
@@ -353,7 +354,7 @@ var labelGenerators = map[string]func(map[string]map[string]interface{}) map[str
 
 		return createLabels("cu-count", counts)
 	},
-	"compute-memory-partition": func(gpus map[string]map[string]interface{}) map[string]string {
+	"compute-memory-partition": func(gpus map[string]*amdgpu.GPU) map[string]string {
 		partitionCountMap := amdgpu.UniquePartitionConfigCount(gpus)
 		isHomogeneous := amdgpu.IsHomogeneous()
 		if isHomogeneous {
@@ -366,12 +367,34 @@ var labelGenerators = map[string]func(map[string]map[string]interface{}) map[str
 		}
 		return map[string]string{}
 	},
-	"compute-partitioning-supported": func(gpus map[string]map[string]interface{}) map[string]string {
+	"compute-partitioning-supported": func(gpus map[string]*amdgpu.GPU) map[string]string {
 		val := strconv.FormatBool(amdgpu.IsComputePartitionSupported())
 		pfx := createLabelPrefix("compute-partitioning-supported", false)
 		return map[string]string{pfx: val}
 	},
-	"memory-partitioning-supported": func(gpus map[string]map[string]interface{}) map[string]string {
+	"xgmi-hive": func(gpus map[string]*amdgpu.GPU) map[string]string {
+		// hives are counted by their number of GPUs, partitions being in
+		// the hive of their GPU
+		var devices []*allocator.Device
+		for id, gpu := range gpus {
+			devices = append(devices, &allocator.Device{Id: id, NodeId: gpu.NodeId, DevId: gpu.DevId})
+		}
+		hives, err := allocator.XGMIHives(devices, amdgpu.SysfsPath("class/kfd/kfd/topology/nodes"))
+		if err != nil {
+			log.Error(err, "Fail to find XGMI hives")
+			return map[string]string{}
+		}
+		counts := map[string]int{}
+		for _, hive := range hives {
+			devIds := map[string]bool{}
+			for _, d := range hive {
+				devIds[d.DevId] = true
+			}
+			counts[strconv.Itoa(len(devIds))]++
+		}
+		return createLabels("xgmi-hive", counts)
+	},
+	"memory-partitioning-supported": func(gpus map[string]*amdgpu.GPU) map[string]string {
 		val := strconv.FormatBool(amdgpu.IsMemoryPartitionSupported())
 		pfx := createLabelPrefix("memory-partitioning-supported", false)
 		return map[string]string{pfx: val}
@@ -382,7 +405,7 @@ var labelProperties = make(map[string]*bool, len(labelGenerators))
 
 func generateLabels(lblProps map[string]*bool) map[string]string {
 	results := make(map[string]string, len(labelGenerators))
-	gpus := amdgpu.GetAMDGPUs()
+	gpus := amdgpu.GetGPUs()
 
 	for l, f := range labelGenerators {
 		if !*lblProps[l] {
@@ -407,6 +430,9 @@ func main() {
 	for k := range labelGenerators {
 		labelProperties[k] = flag.Bool(k, false, "Set this to label nodes with "+k+" properties")
 	}
+	flag.StringVar(&amdgpu.SysfsRoot, "sysfs_root", amdgpu.SysfsRoot, "Root of the sysfs tree used for GPU discovery")
+	flag.StringVar(&amdgpu.DevfsRoot, "devfs_root", amdgpu.DevfsRoot, "Root of the device nodes used for GPU discovery")
+	flag.BoolVar(&amdgpu.UseHwloc, "hwloc", amdgpu.UseHwloc, "Consult hwloc for the NUMA nodes of the GPUs sysfs doesn't resolve. Disable it when -sysfs_root is not the sysfs of the node, ex: a snapshot")
 
 	flag.Parse()
 
@@ -22,6 +22,7 @@ var (
 		"amd.com/gpu.compute-memory-partition":       true,
 		"amd.com/gpu.compute-partitioning-supported": true,
 		"amd.com/gpu.memory-partitioning-supported":  true,
+		"amd.com/gpu.xgmi-hive":                      true,
 	}
 	expectedAllExperimentalLabelKeys = map[string]bool{
 		"beta.amd.com/gpu.family":                         true,
@@ -36,6 +37,7 @@ var (
 		"beta.amd.com/gpu.compute-memory-partition":       true,
 		"beta.amd.com/gpu.compute-partitioning-supported": true,
 		"beta.amd.com/gpu.memory-partitioning-supported":  true,
+		"beta.amd.com/gpu.xgmi-hive":                      true,
 	}
 )
 
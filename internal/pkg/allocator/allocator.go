@@ -28,3 +28,17 @@ type Policy interface {
 	Init(devs []*Device, topoDir string) error
 	Allocate(available, required []string, size int) ([]string, error)
 }
+
+// Candidate is a subset of devices considered for an allocation, with the
+// sum of the weights of its pairs of devices
+type Candidate struct {
+	Ids         []string
+	TotalWeight int
+}
+
+// CandidateLister is implemented by the policies scoring subsets of
+// devices. Candidates lists up to count of the subsets considered for an
+// allocation, from the preferred one down.
+type CandidateLister interface {
+	Candidates(available, required []string, size, count int) ([]Candidate, error)
+}
@@ -18,8 +18,10 @@ package allocator
 
 import (
 	"fmt"
-	"math"
+	"sort"
+	"strings"
 
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/config"
 	"github.com/golang/glog"
 )
 
@@ -29,6 +31,7 @@ import (
 *  1. Type of link between the GPUs(XGMI or PCIE)
 *  2. For partitioned GPUs, it tries to assign weights based on whether partitions are of same GPU or different GPUs
 *  3. If both GPUs are part of same numa node or not
+*  4. The bandwidth, latency and KFD weight reported for the link, compared to the other links of the same type
 *  Pair with lower weight takes higher precedence. We calculate the sum of weights b/n individual pair within a given
 *  subset and come up with total score for the subset. Subset with lowest score is given preference during allocation.
 **/
@@ -47,10 +50,16 @@ type BestEffortPolicy struct {
 	devicesMap       map[string]*Device
 	devicePartitions map[string]*DevicePartitions
 	p2pWeights       map[int]map[int]int
+	weights          config.WeightsConfig
 }
 
 func NewBestEffortPolicy() *BestEffortPolicy {
+	return newBestEffortPolicy(config.DefaultWeights())
+}
+
+func newBestEffortPolicy(weights config.WeightsConfig) *BestEffortPolicy {
 	return &BestEffortPolicy{
+		weights:          weights,
 		devices:          make([]*Device, 0),
 		devicesMap:       make(map[string]*Device),
 		devicePartitions: make(map[string]*DevicePartitions),
@@ -68,7 +77,12 @@ func (b *BestEffortPolicy) getDevicesFromIds(ids []string) []*Device {
 
 // Init initializes pair wise weights of all devices and stores in-memory
 func (b *BestEffortPolicy) Init(devs []*Device, topoDir string) error {
-	err := fetchAllPairWeights(devs, b.p2pWeights, topoDir)
+	// Init may be called again when the devices change, start from scratch
+	b.devices = make([]*Device, 0)
+	b.devicesMap = make(map[string]*Device)
+	b.devicePartitions = make(map[string]*DevicePartitions)
+	b.p2pWeights = make(map[int]map[int]int)
+	err := fetchAllPairWeights(devs, b.p2pWeights, topoDir, b.weights)
 	if len(b.p2pWeights) == 0 {
 		return fmt.Errorf("Besteffort Policy init failed to initialize p2pWeights")
 	}
@@ -81,30 +95,64 @@ func (b *BestEffortPolicy) Init(devs []*Device, topoDir string) error {
 		for _, par := range b.devicePartitions {
 			glog.Infof("Device: %s Partitions: %v", par.ParentId, par.Devs)
 		}
+		b.logWeights()
 	}
 	return err
 }
 
-func (b *BestEffortPolicy) Allocate(availableIds, requiredIds []string, size int) ([]string, error) {
-	outset := []string{}
+// logWeights logs the weights and the resulting pair weights of the nodes,
+// one line per node listing its peers with a higher node ID
+func (b *BestEffortPolicy) logWeights() {
+	glog.Infof("Pair weights: sameGPU:%d differentGPU:%d sameNUMANode:%d differentNUMANode:%d links:%v otherLink:%d bandwidth:%d latency:%d hops:%d",
+		b.weights.SameGPU, b.weights.DifferentGPU, b.weights.SameNUMANode, b.weights.DifferentNUMANode,
+		b.weights.Links, b.weights.OtherLink, b.weights.Bandwidth, b.weights.Latency, b.weights.Hops)
+	from := make([]int, 0, len(b.p2pWeights))
+	for id := range b.p2pWeights {
+		from = append(from, id)
+	}
+	sort.Ints(from)
+	for _, id := range from {
+		to := make([]int, 0, len(b.p2pWeights[id]))
+		for peer := range b.p2pWeights[id] {
+			to = append(to, peer)
+		}
+		sort.Ints(to)
+		var row strings.Builder
+		for _, peer := range to {
+			fmt.Fprintf(&row, " %d:%d", peer, b.p2pWeights[id][peer])
+		}
+		glog.Infof("Node %d pair weights:%s", id, row.String())
+	}
+}
+
+// validateRequest checks the arguments of an Allocate call against the
+// devices the policy was initialized with
+func validateRequest(devices []*Device, availableIds, requiredIds []string, size int) error {
 	if size <= 0 {
-		return outset, fmt.Errorf(invalidSize)
+		return fmt.Errorf(invalidSize)
 	}
 
 	if len(availableIds) < size {
-		return outset, fmt.Errorf(invalidAvailable)
+		return fmt.Errorf(invalidAvailable)
 	}
 
 	if len(requiredIds) > size {
-		return outset, fmt.Errorf(invalidRequired)
+		return fmt.Errorf(invalidRequired)
 	}
 
 	if len(requiredIds) > len(availableIds) {
-		return outset, fmt.Errorf(invalidReqAvailable)
+		return fmt.Errorf(invalidReqAvailable)
+	}
+
+	if len(devices) == 0 {
+		return fmt.Errorf(invalidInit)
 	}
+	return nil
+}
 
-	if len(b.devices) == 0 {
-		return outset, fmt.Errorf(invalidInit)
+func (b *BestEffortPolicy) Allocate(availableIds, requiredIds []string, size int) ([]string, error) {
+	if err := validateRequest(b.devices, availableIds, requiredIds, size); err != nil {
+		return []string{}, err
 	}
 
 	if len(availableIds) == size {
@@ -115,28 +163,31 @@ func (b *BestEffortPolicy) Allocate(availableIds, requiredIds []string, size int
 		return requiredIds, nil
 	}
 
+	outset, score, err := b.allocate(availableIds, requiredIds, size)
+	if err != nil {
+		return outset, err
+	}
+	glog.Infof("best device subset:%v best score:%v", outset, score)
+	return outset, nil
+}
+
+// allocate returns the subset of size devices with the lowest total pair
+// weight, along with that weight
+func (b *BestEffortPolicy) allocate(availableIds, requiredIds []string, size int) ([]string, int, error) {
+	outset := []string{}
 	if len(b.p2pWeights) == 0 {
-		return outset, fmt.Errorf(invalidInit)
+		return outset, 0, fmt.Errorf(invalidInit)
 	}
 
 	if !setContainsAll(availableIds, requiredIds) {
-		return outset, fmt.Errorf(noCandidateFound)
+		return outset, 0, fmt.Errorf(noCandidateFound)
 	}
 
 	available := b.getDevicesFromIds(availableIds)
 	required := b.getDevicesFromIds(requiredIds)
-	allSubsets, err := getCandidateDeviceSubsets(b.devicePartitions, b.devices, available, required, size, b.p2pWeights)
+	candidate, err := findBestDeviceSubset(b.devicePartitions, available, required, size, b.p2pWeights)
 	if err != nil {
-		return outset, err
-	}
-
-	bestScore := math.MaxInt32
-	var candidate *DeviceSet
-	for _, subset := range allSubsets {
-		if subset.TotalWeight < bestScore {
-			candidate = subset
-			bestScore = subset.TotalWeight
-		}
+		return outset, 0, err
 	}
 	for _, id := range candidate.Ids {
 		for _, d := range available {
@@ -146,6 +197,37 @@ func (b *BestEffortPolicy) Allocate(availableIds, requiredIds []string, size int
 			}
 		}
 	}
-	glog.Infof("best device subset:%v best score:%v", outset, candidate.TotalWeight)
-	return outset, nil
+	return outset, candidate.TotalWeight, nil
+}
+
+// Candidates lists the best subsets of devices found for an allocation
+func (b *BestEffortPolicy) Candidates(availableIds, requiredIds []string, size, count int) ([]Candidate, error) {
+	if err := validateRequest(b.devices, availableIds, requiredIds, size); err != nil {
+		return nil, err
+	}
+	if len(b.p2pWeights) == 0 {
+		return nil, fmt.Errorf(invalidInit)
+	}
+	if !setContainsAll(availableIds, requiredIds) {
+		return nil, fmt.Errorf(noCandidateFound)
+	}
+	available := b.getDevicesFromIds(availableIds)
+	required := b.getDevicesFromIds(requiredIds)
+	subsets, err := findDeviceSubsets(b.devicePartitions, available, required, size, b.p2pWeights, count)
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[int]string)
+	for _, d := range b.devices {
+		ids[d.NodeId] = d.Id
+	}
+	candidates := make([]Candidate, 0, len(subsets))
+	for _, subset := range subsets {
+		candidate := Candidate{TotalWeight: subset.TotalWeight}
+		for _, id := range subset.Ids {
+			candidate.Ids = append(candidate.Ids, ids[id])
+		}
+		candidates = append(candidates, candidate)
+	}
+	return candidates, nil
 }
@@ -214,3 +214,94 @@ func TestBestPolicyAllocator(t *testing.T) {
 		t.Logf("-------END tests for Topology %d-------", idx+1)
 	}
 }
+
+func TestBestEffortCandidates(t *testing.T) {
+	devices := mi300CPXTopo.getTestDevices()
+	a := NewBestEffortPolicy()
+	if err := a.Init(devices, mi300CPXTopo.topoFolderPath); err != nil {
+		t.Fatalf("expected Init to pass. But failed with error %v", err)
+	}
+	var available []string
+	for _, d := range devices {
+		available = append(available, d.Id)
+	}
+	candidates, err := a.Candidates(available, nil, 7, 3)
+	if err != nil {
+		t.Fatalf("expected Candidates to pass. But failed with error %v", err)
+	}
+	if len(candidates) != 3 {
+		t.Fatalf("expected 3 candidates but got %d", len(candidates))
+	}
+	best, err := a.Allocate(available, nil, 7)
+	if err != nil {
+		t.Fatalf("expected Allocate to pass. But failed with error %v", err)
+	}
+	sort.Strings(best)
+	sort.Strings(candidates[0].Ids)
+	if fmt.Sprint(best) != fmt.Sprint(candidates[0].Ids) {
+		t.Errorf("expected the first candidate to be the allocated subset %v but got %v", best, candidates[0].Ids)
+	}
+	for i := 1; i < len(candidates); i++ {
+		if candidates[i].TotalWeight < candidates[i-1].TotalWeight {
+			t.Errorf("candidates not in order of weight: %+v", candidates)
+		}
+	}
+
+	// subsets of whole GPUs are reachable from several sets of GPUs, each
+	// must be listed once
+	devices = mi210Topo.getTestDevices()
+	a = NewBestEffortPolicy()
+	if err := a.Init(devices, mi210Topo.topoFolderPath); err != nil {
+		t.Fatalf("expected Init to pass. But failed with error %v", err)
+	}
+	available = nil
+	for _, d := range devices {
+		available = append(available, d.Id)
+	}
+	candidates, err = a.Candidates(available, nil, 3, 5)
+	if err != nil {
+		t.Fatalf("expected Candidates to pass. But failed with error %v", err)
+	}
+	seen := make(map[string]bool)
+	for _, c := range candidates {
+		sort.Strings(c.Ids)
+		key := fmt.Sprint(c.Ids)
+		if seen[key] {
+			t.Errorf("candidate %v listed more than once: %+v", c.Ids, candidates)
+		}
+		seen[key] = true
+	}
+	if len(candidates) != 5 {
+		t.Errorf("expected 5 candidates but got %d", len(candidates))
+	}
+}
+
+func TestBestEffortMemoryDomains(t *testing.T) {
+	// as in CPX/NPS4, each pair of partitions of the first GPU is in its own
+	// memory partition, and the second partition is already allocated
+	devices := mi300CPXTopo.getTestDevices()
+	for i, d := range devices[:8] {
+		d.MemoryDomain = i/2 + 1
+	}
+	a := NewBestEffortPolicy()
+	if err := a.Init(devices, mi300CPXTopo.topoFolderPath); err != nil {
+		t.Fatalf("expected Init to pass. But failed with error %v", err)
+	}
+	available := []string{"test1", "amdgpu_xcp_2", "amdgpu_xcp_3", "amdgpu_xcp_5"}
+	result, err := a.Allocate(available, nil, 2)
+	if err != nil {
+		t.Fatalf("expected Allocate to pass. But failed with error %v", err)
+	}
+	sort.Strings(result)
+	if fmt.Sprint(result) != "[amdgpu_xcp_2 amdgpu_xcp_3]" {
+		t.Errorf("expected the partitions of a single memory partition, but got %v", result)
+	}
+	result, err = a.Allocate(available, []string{"amdgpu_xcp_5"}, 3)
+	if err != nil {
+		t.Fatalf("expected Allocate to pass. But failed with error %v", err)
+	}
+	sort.Strings(result)
+	if fmt.Sprint(result) != "[amdgpu_xcp_2 amdgpu_xcp_3 amdgpu_xcp_5]" {
+		t.Errorf("expected the partitions of a single memory partition with the required one, but got %v", result)
+	}
+}
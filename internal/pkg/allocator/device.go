@@ -23,36 +23,19 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
-	"slices"
 	"sort"
 	"strconv"
 	"strings"
 
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/amdgpu"
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/config"
 	"github.com/golang/glog"
 )
 
-const (
-	topoRootPath = "/sys/class/kfd/kfd/topology/nodes"
-)
-
-// below scores/weights are used to determine the closeness/efficiency of communication between GPU pairs
-const (
-	// weight if GPUs/partitions belong to same GPU
-	sameDevIdWeight = 10
-	// weight if a pair is connected via XGMI link
-	xgmiLinkWeight = 10
-	// weight if GPU pair belongs to same numa node
-	sameNumaNodeWeight = 10
-	// weight if GPUs/partitions belong to different GPU.
-	// In case of full GPUs, the weight is 3
-	differentDevIdWeight = 20
-	// weight if GPU pair belongs to different numa node
-	differentNumaNodeWeight = 20
-	// weight if a pair is connected via PCIE link
-	pcieLinkWeight = 40
-	// weight if a pair is connected via any other link apart from XGMI or PCIE
-	otherLinkWeight = 50
-)
+// topoRootPath returns the KFD topology folder used when none is given
+func topoRootPath() string {
+	return amdgpu.SysfsPath("class/kfd/kfd/topology/nodes")
+}
 
 type Device struct {
 	Id                   string
@@ -63,6 +46,9 @@ type Device struct {
 	RenderD              int
 	ComputePartitionType string
 	MemoryPartitionType  string
+	// MemoryDomain is the memory partition of its GPU the memory of a
+	// partition is in, numbered from 1, 0 if unknown
+	MemoryDomain int
 }
 
 type DeviceSet struct {
@@ -133,48 +119,86 @@ func fetchTopoProperties(path string, re []*regexp.Regexp) ([]int, error) {
 	return res, nil
 }
 
-func calculatePairWeight(from, to *Device, linkType int) int {
+// calculatePairWeight returns the weight of a pair of devices linked by an
+// io_link of linkType
+func calculatePairWeight(from, to *Device, linkType int, weights config.WeightsConfig) int {
 	weight := 0
 	if from.DevId == to.DevId {
-		weight = weight + sameDevIdWeight
+		weight = weight + weights.SameGPU
 	} else {
-		weight = weight + differentDevIdWeight
+		weight = weight + weights.DifferentGPU
 	}
 
-	if linkType == 11 { // link type 11 is xgmi
-		weight = weight + xgmiLinkWeight
-	} else if linkType == 2 { //link type 2 is PCIE
-		weight = weight + pcieLinkWeight
+	if linkWeight, ok := weights.Links[linkType]; ok {
+		weight = weight + linkWeight
 	} else { // other link types are given higher weight
-		weight = weight + otherLinkWeight
+		weight = weight + weights.OtherLink
 	}
 
-	if from.NumaNode == to.NumaNode {
-		weight = weight + sameNumaNodeWeight
+	if from.NumaNode == to.NumaNode && sameMemoryDomain(from, to) {
+		weight = weight + weights.SameNUMANode
 	} else {
-		weight = weight + differentNumaNodeWeight
+		weight = weight + weights.DifferentNUMANode
 	}
 	return weight
 }
 
-func scanAndPopulatePeerWeights(fromPath string, devices []*Device, lookupNodes map[int]struct{}, p2pWeights map[int]map[int]int) error {
+// sameMemoryDomain returns false for partitions of the same GPU in different
+// memory partitions, ex: in NPS4, which are as far apart as different NUMA
+// nodes
+func sameMemoryDomain(from, to *Device) bool {
+	if from.DevId != to.DevId || from.MemoryDomain == 0 || to.MemoryDomain == 0 {
+		return true
+	}
+	return from.MemoryDomain == to.MemoryDomain
+}
+
+// ioLink holds the properties of an io_link or p2p_link between two nodes,
+// the measured values are 0 when not reported
+type ioLink struct {
+	from, to     int
+	linkType     int
+	weight       int
+	minBandwidth int
+	maxBandwidth int
+	minLatency   int
+	maxLatency   int
+}
+
+// linkReference holds the best values reported by the links of a type
+type linkReference struct {
+	maxBandwidth int
+	minLatency   int
+	minWeight    int
+}
+
+var ioLinkProperties = []*regexp.Regexp{
+	regexp.MustCompile(`node_from\s(\d+)`),
+	regexp.MustCompile(`node_to\s(\d+)`),
+	regexp.MustCompile(`type\s(\d+)`),
+	regexp.MustCompile(`weight\s(\d+)`),
+	regexp.MustCompile(`min_bandwidth\s(\d+)`),
+	regexp.MustCompile(`max_bandwidth\s(\d+)`),
+	regexp.MustCompile(`min_latency\s(\d+)`),
+	regexp.MustCompile(`max_latency\s(\d+)`),
+}
+
+// scanPeerLinks returns the io_links and p2p_links of a node to the nodes in
+// lookupNodes, with from < to
+func scanPeerLinks(fromPath string, lookupNodes map[int]struct{}) ([]*ioLink, error) {
 	paths, err1 := filepath.Glob(filepath.Join(fromPath, "io_links", "[0-9]*"))
 	p2pPaths, err2 := filepath.Glob(filepath.Join(fromPath, "p2p_links", "[0-9]*"))
 	if err1 != nil && err2 != nil {
 		glog.Errorf("unable to fetch io_links and p2p_links folders. Error1:%v Error2:%v", err1, err2)
-		return fmt.Errorf("Unable to Glob io_links and p2p_links paths")
+		return nil, fmt.Errorf("Unable to Glob io_links and p2p_links paths")
 	}
 	if len(p2pPaths) > 0 {
 		paths = append(paths, p2pPaths...)
 	}
-	re := []*regexp.Regexp{
-		regexp.MustCompile(`node_from\s(\d+)`),
-		regexp.MustCompile(`node_to\s(\d+)`),
-		regexp.MustCompile(`type\s(\d+)`),
-	}
+	var links []*ioLink
 	for _, topath := range paths {
 		propFile := filepath.Join(topath, "properties")
-		vals, err := fetchTopoProperties(propFile, re)
+		vals, err := fetchTopoProperties(propFile, ioLinkProperties)
 		if err != nil {
 			continue
 		}
@@ -193,39 +217,78 @@ func scanAndPopulatePeerWeights(fromPath string, devices []*Device, lookupNodes
 		if _, ok := lookupNodes[to]; !ok {
 			continue
 		}
+		links = append(links, &ioLink{
+			from:         from,
+			to:           to,
+			linkType:     vals[2],
+			weight:       vals[3],
+			minBandwidth: vals[4],
+			maxBandwidth: vals[5],
+			minLatency:   vals[6],
+			maxLatency:   vals[7],
+		})
+	}
+	return links, nil
+}
 
-		var fromDev, toDev *Device
-		devsFound := false
-		for idx := range devices {
-			if devices[idx].NodeId == from {
-				fromDev = devices[idx]
-			}
-			if devices[idx].NodeId == to {
-				toDev = devices[idx]
-			}
-			if fromDev != nil && toDev != nil {
-				devsFound = true
-				break
-			}
+// linkReferences returns the best values reported by the links of every
+// type
+func linkReferences(links []*ioLink) map[int]*linkReference {
+	refs := make(map[int]*linkReference)
+	for _, link := range links {
+		ref, ok := refs[link.linkType]
+		if !ok {
+			ref = &linkReference{}
+			refs[link.linkType] = ref
 		}
-		if devsFound {
-			if _, ok := p2pWeights[from]; !ok {
-				p2pWeights[from] = make(map[int]int)
-			}
-			p2pWeights[from][to] = calculatePairWeight(fromDev, toDev, int(vals[2]))
+		ref.maxBandwidth = max(ref.maxBandwidth, link.maxBandwidth)
+		if link.minLatency > 0 && (ref.minLatency == 0 || link.minLatency < ref.minLatency) {
+			ref.minLatency = link.minLatency
+		}
+		if link.weight > 0 && (ref.minWeight == 0 || link.weight < ref.minWeight) {
+			ref.minWeight = link.weight
 		}
 	}
-	return nil
+	return refs
 }
 
-func fetchAllPairWeights(devices []*Device, p2pWeights map[int]map[int]int, folderPath string) error {
+// measuredLinkWeight returns the weight added to a pair for the values
+// measured on its link, relative to the best ones of the links of the same
+// type. A link with the best bandwidth, latency and KFD weight adds nothing,
+// a link much slower, further or with more hops adds up to the Bandwidth,
+// Latency and Hops weights. Values that are not reported add nothing.
+func measuredLinkWeight(link *ioLink, ref *linkReference, weights config.WeightsConfig) int {
+	weight := 0
+	if link.maxBandwidth > 0 && ref.maxBandwidth > 0 {
+		weight = weight + weights.Bandwidth*(ref.maxBandwidth-link.maxBandwidth)/ref.maxBandwidth
+	}
+	if link.minLatency > 0 && ref.minLatency > 0 {
+		weight = weight + weights.Latency*(link.minLatency-ref.minLatency)/link.minLatency
+	}
+	if link.weight > 0 && ref.minWeight > 0 {
+		weight = weight + weights.Hops*(link.weight-ref.minWeight)/link.weight
+	}
+	return weight
+}
+
+// PairWeights returns the weights of the pairs of linked devices, keyed by
+// the lowest of their node IDs then by the other one
+func PairWeights(devices []*Device, topoDir string, weights config.WeightsConfig) (map[int]map[int]int, error) {
+	p2pWeights := make(map[int]map[int]int)
+	if err := fetchAllPairWeights(devices, p2pWeights, topoDir, weights); err != nil {
+		return nil, err
+	}
+	return p2pWeights, nil
+}
+
+func fetchAllPairWeights(devices []*Device, p2pWeights map[int]map[int]int, folderPath string, weights config.WeightsConfig) error {
 	if len(devices) == 0 {
 		errMsg := "Devices list is empty. Unable to calculate pair wise weights"
 		glog.Info(errMsg)
 		return errors.New(errMsg)
 	}
 	if folderPath == "" {
-		folderPath = topoRootPath
+		folderPath = topoRootPath()
 	}
 	paths, err := filepath.Glob(filepath.Join(folderPath, "[0-9]*"))
 	if err != nil {
@@ -236,6 +299,7 @@ func fetchAllPairWeights(devices []*Device, p2pWeights map[int]map[int]int, fold
 		nodeIds[devices[idx].NodeId] = struct{}{}
 	}
 	drmRenderMinor := []*regexp.Regexp{regexp.MustCompile(`drm_render_minor\s(\d+)`)}
+	var links []*ioLink
 	for _, path := range paths {
 		propFilePath := filepath.Join(path, "properties")
 		vals, err := fetchTopoProperties(propFilePath, drmRenderMinor)
@@ -243,34 +307,104 @@ func fetchAllPairWeights(devices []*Device, p2pWeights map[int]map[int]int, fold
 		if err != nil || vals[0] <= 0 {
 			continue
 		}
-		err = scanAndPopulatePeerWeights(path, devices, nodeIds, p2pWeights)
+		nodeLinks, err := scanPeerLinks(path, nodeIds)
 		if err != nil {
 
 			return err
 		}
+		links = append(links, nodeLinks...)
+	}
+
+	devicesByNode := make(map[int]*Device)
+	for _, d := range devices {
+		devicesByNode[d.NodeId] = d
+	}
+	refs := linkReferences(links)
+	for _, link := range links {
+		fromDev, toDev := devicesByNode[link.from], devicesByNode[link.to]
+		if _, ok := p2pWeights[link.from]; !ok {
+			p2pWeights[link.from] = make(map[int]int)
+		}
+		p2pWeights[link.from][link.to] = calculatePairWeight(fromDev, toDev, link.linkType, weights) +
+			measuredLinkWeight(link, refs[link.linkType], weights)
 	}
 	return nil
 }
 
-func addDeviceToSubsetAndUpdateWeight(subset *DeviceSet, devId, devIdx int, p2pWeights map[int]map[int]int) *DeviceSet {
-	currentWeight := subset.TotalWeight
-	var from, to int
-	ids := make([]int, 0)
-	for _, d := range subset.Ids {
-		if d < devId {
-			from = d
-			to = devId
+// XGMIHives groups the devices into XGMI hives. The devices of a hive are
+// connected to each other through XGMI links, directly or through other
+// devices of the hive. Partitions are in the hive of their GPU, devices
+// without XGMI links are alone in theirs. Hives are ordered by their lowest
+// node ID, and their devices by node ID.
+func XGMIHives(devices []*Device, folderPath string) ([][]*Device, error) {
+	if len(devices) == 0 {
+		return nil, errors.New("Devices list is empty. Unable to find XGMI hives")
+	}
+	if folderPath == "" {
+		folderPath = topoRootPath()
+	}
+	// parent maps node IDs to another node of their hive, the root of a
+	// hive being its own parent
+	parent := make(map[int]int)
+	var root func(int) int
+	root = func(id int) int {
+		if parent[id] != id {
+			parent[id] = root(parent[id])
+		}
+		return parent[id]
+	}
+	union := func(a, b int) {
+		ra, rb := root(a), root(b)
+		if ra < rb {
+			parent[rb] = ra
+		} else {
+			parent[ra] = rb
+		}
+	}
+	gpus := make(map[string]int)
+	nodeIds := make(map[int]struct{})
+	for _, d := range devices {
+		parent[d.NodeId] = d.NodeId
+		nodeIds[d.NodeId] = struct{}{}
+	}
+	for _, d := range devices {
+		if id, ok := gpus[d.DevId]; ok {
+			union(id, d.NodeId)
 		} else {
-			from = devId
-			to = d
+			gpus[d.DevId] = d.NodeId
 		}
-		currentWeight = currentWeight + p2pWeights[from][to]
 	}
-	ids = append(ids, subset.Ids...)
-	ids = append(ids, devId)
 
-	newSubset := NewDeviceSet(ids, subset.ParentIds, currentWeight, devIdx)
-	return newSubset
+	for _, d := range devices {
+		links, err := scanPeerLinks(filepath.Join(folderPath, strconv.Itoa(d.NodeId)), nodeIds)
+		if err != nil {
+			return nil, err
+		}
+		for _, link := range links {
+			if link.linkType == config.LinkTypeXGMI {
+				union(link.from, link.to)
+			}
+		}
+	}
+
+	sorted := make([]*Device, len(devices))
+	copy(sorted, devices)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].NodeId < sorted[j].NodeId
+	})
+	hiveIdx := make(map[int]int)
+	hives := make([][]*Device, 0)
+	for _, d := range sorted {
+		r := root(d.NodeId)
+		idx, ok := hiveIdx[r]
+		if !ok {
+			idx = len(hives)
+			hiveIdx[r] = idx
+			hives = append(hives, nil)
+		}
+		hives[idx] = append(hives[idx], d)
+	}
+	return hives, nil
 }
 
 func NewDeviceSet(nodeIds, parentIds []int, weight, lastIdx int) *DeviceSet {
@@ -350,94 +484,3 @@ func filterPartitions(partitions map[string]*DevicePartitions, available, requir
 	})
 	return outset
 }
-
-func getCandidateDeviceSubsets(allDevPartitions map[string]*DevicePartitions, total, available, required []*Device, size int, p2pWeights map[int]map[int]int) ([]*DeviceSet, error) {
-	if size <= 0 {
-		return []*DeviceSet{}, fmt.Errorf("subset size should be positive integer")
-	}
-
-	if len(available) < size {
-		return []*DeviceSet{}, fmt.Errorf("subset size is more than available devices")
-	}
-
-	sort.Slice(available, func(i, j int) bool {
-		return available[i].NodeId < available[j].NodeId
-	})
-
-	// filterPartitions - partitions from same gpu are grouped into one set.
-	// the sets are sorted in ascending order of partitions available for allocation.
-	devPartitions := filterPartitions(allDevPartitions, available, required)
-	newSize := size - len(required)
-	subsetsTemp := make([]*DeviceSet, 0)
-	subsetsFinal := make([]*DeviceSet, 0)
-	// if the requested size is less than available partitions of a single gpu, try to allocate all from same gpu
-	// subsetsFinal - contains candidate set that has requested number of gpus/partitions
-	// subsetsTemp - if one gpu can not suffice requested number of partitions, we store in subsetsTemp
-	for idx, partition := range devPartitions {
-		ids := []int{partition.Ids[0]}
-		parentIds := []int{idx}
-		devset := NewDeviceSet(ids, parentIds, 0, idx)
-		if newSize == 1 {
-			for _, req := range required {
-				devset = addDeviceToSubsetAndUpdateWeight(devset, req.NodeId, idx, p2pWeights)
-			}
-			subsetsFinal = append(subsetsFinal, devset)
-			continue
-		}
-		sizeFulfilled := false
-		for i := 1; i < len(partition.Ids); i++ {
-			devset = addDeviceToSubsetAndUpdateWeight(devset, partition.Ids[i], idx, p2pWeights)
-			if i == newSize-1 {
-				sizeFulfilled = true
-				break
-			}
-		}
-		if sizeFulfilled {
-			for _, req := range required {
-				devset = addDeviceToSubsetAndUpdateWeight(devset, req.NodeId, idx, p2pWeights)
-			}
-			subsetsFinal = append(subsetsFinal, devset)
-		} else {
-			subsetsTemp = append(subsetsTemp, devset)
-		}
-	}
-	// for each subsetsTemp, we loop over all the devPartitions
-	// pick partitions from other gpu until the subsetsTemp has requested number of gpus/partitions
-	for {
-		if len(subsetsTemp) == 0 {
-			break
-		}
-		currentSubset := subsetsTemp[0]
-		subsetsTemp = subsetsTemp[1:]
-		if len(currentSubset.ParentIds) == len(devPartitions) {
-			continue
-		}
-		// devPartitions is sorted in ascending order of avilable partitions.
-		// when we loop over to pick a candidate set, preference is given to gpus with lesser partitions available.
-		// this way we can avoid fragmentation of gpus
-		for idx := 0; idx < len(devPartitions); idx++ {
-			// if current subset already has partitions from the current gpu, skip adding them again
-			if slices.Contains(currentSubset.ParentIds, idx) {
-				continue
-			}
-			var parentIds []int
-			parentIds = append(parentIds, currentSubset.ParentIds...)
-			parentIds = append(parentIds, idx)
-			devset := NewDeviceSet(currentSubset.Ids, parentIds, currentSubset.TotalWeight, currentSubset.LastIdx)
-			for _, id := range devPartitions[idx].Ids {
-				devset = addDeviceToSubsetAndUpdateWeight(devset, id, idx, p2pWeights)
-				if devset.Size == newSize {
-					for _, req := range required {
-						devset = addDeviceToSubsetAndUpdateWeight(devset, req.NodeId, idx, p2pWeights)
-					}
-					subsetsFinal = append(subsetsFinal, devset)
-					break
-				}
-			}
-			if devset.Size < newSize {
-				subsetsTemp = append(subsetsTemp, devset)
-			}
-		}
-	}
-	return subsetsFinal, nil
-}
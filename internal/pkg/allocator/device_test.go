@@ -18,9 +18,15 @@ package allocator
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 	"slices"
+	"sort"
 	"strconv"
 	"testing"
+
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/amdgpu"
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/config"
 )
 
 type testInfo struct {
@@ -81,7 +87,7 @@ func TestPairWeightsCalculationEmptyDevices(t *testing.T) {
 	folderPath := "../../../testdata/topology-parsing-mi308/topology/nodes"
 	p2pWeights := make(map[int]map[int]int)
 	var devices []*Device
-	err := fetchAllPairWeights(devices, p2pWeights, folderPath)
+	err := fetchAllPairWeights(devices, p2pWeights, folderPath, config.DefaultWeights())
 	if err == nil {
 		t.Errorf("fetchAllPairWeights call is expected to return error but got nil")
 	}
@@ -98,7 +104,7 @@ func TestPairWeightsCalculation(t *testing.T) {
 		topoFolderPath:       "../../../testdata/topology-parsing-mi308/topology/nodes",
 	}
 	devices := tinfo.getTestDevices()
-	err := fetchAllPairWeights(devices, p2pWeights, tinfo.topoFolderPath)
+	err := fetchAllPairWeights(devices, p2pWeights, tinfo.topoFolderPath, config.DefaultWeights())
 	if err != nil {
 		t.Errorf("fetchAllPairWeights call failed. Error:%v", err)
 	}
@@ -134,7 +140,7 @@ func TestGetSubsetsMethod(t *testing.T) {
 	}
 	devices := tinfo.getTestDevices()
 	devIdMap := groupPartitionsByDevId(devices)
-	err := fetchAllPairWeights(devices, p2pWeights, tinfo.topoFolderPath)
+	err := fetchAllPairWeights(devices, p2pWeights, tinfo.topoFolderPath, config.DefaultWeights())
 	if err != nil {
 		t.Errorf("fetchAllPairWeights call failed. Error:%v", err)
 	}
@@ -154,16 +160,211 @@ func TestGetSubsetsMethod(t *testing.T) {
 	for _, tcase := range testcases {
 		t.Logf("Starting testcase %s", tcase.description)
 		tcase.result = "PASS"
-		subsets, err := getCandidateDeviceSubsets(devIdMap, devices, devices, nil, tcase.size, p2pWeights)
+		subsets, err := findDeviceSubsets(devIdMap, devices, nil, tcase.size, p2pWeights, 100)
 		if err != nil {
-			t.Errorf("expected getAllDeviceSubsets to pass. But got error %v", err)
+			t.Errorf("expected findDeviceSubsets to pass. But got error %v", err)
 			tcase.result = "FAIL"
 		}
 		if len(subsets) != tcase.expectedSubsetsLength {
 			t.Errorf("expected subsets length to be %d but got %d", tcase.expectedSubsetsLength, len(subsets))
 			tcase.result = "FAIL"
 		}
+		seen := make(map[string]bool)
+		for i, subset := range subsets {
+			ids := slices.Clone(subset.Ids)
+			slices.Sort(ids)
+			if subset.Size != tcase.size || len(ids) != tcase.size || seen[fmt.Sprint(ids)] {
+				t.Errorf("expected distinct subsets of %d devices but got %v", tcase.size, subset.Ids)
+				tcase.result = "FAIL"
+			}
+			seen[fmt.Sprint(ids)] = true
+			if i > 0 && subset.TotalWeight < subsets[i-1].TotalWeight {
+				t.Errorf("expected subsets from the best to the worst but got %d after %d", subset.TotalWeight, subsets[i-1].TotalWeight)
+				tcase.result = "FAIL"
+			}
+			// the devices are taken from as few GPUs as possible
+			gpus := make(map[string]bool)
+			for _, id := range ids {
+				gpus[devices[slices.IndexFunc(devices, func(d *Device) bool { return d.NodeId == id })].DevId] = true
+			}
+			if len(gpus) != (tcase.size+tinfo.partitionCountPerDev-1)/tinfo.partitionCountPerDev {
+				t.Errorf("expected the subset %v to span %d GPUs but got %d", ids, (tcase.size+tinfo.partitionCountPerDev-1)/tinfo.partitionCountPerDev, len(gpus))
+				tcase.result = "FAIL"
+			}
+		}
 		t.Logf("Result: %v", tcase.result)
 		t.Logf("Ending Testcase %s", tcase.description)
 	}
 }
+
+func TestXGMIHives(t *testing.T) {
+	testcases := []struct {
+		topo  testInfo
+		hives [][]string
+	}{
+		{mi210Topo, [][]string{{"test1", "test2", "test3", "test4"}, {"test5", "test6", "test7", "test8"}}},
+		{mi300CPXTopo, nil},
+	}
+	for _, tc := range testcases {
+		devices := tc.topo.getTestDevices()
+		hives, err := XGMIHives(devices, tc.topo.topoFolderPath)
+		if err != nil {
+			t.Fatalf("XGMIHives call failed. Error:%v", err)
+		}
+		if tc.hives == nil {
+			// all the partitions of all the GPUs are in one hive
+			if len(hives) != 1 || len(hives[0]) != len(devices) {
+				t.Errorf("expected all %d devices in one hive of %s, but got %d hives", len(devices), tc.topo.topoFolderPath, len(hives))
+			}
+			continue
+		}
+		var ids [][]string
+		for _, hive := range hives {
+			var hiveIds []string
+			for _, d := range hive {
+				hiveIds = append(hiveIds, d.Id)
+			}
+			ids = append(ids, hiveIds)
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(tc.hives) {
+			t.Errorf("hives of %s not as expected. Expected %v but got %v", tc.topo.topoFolderPath, tc.hives, ids)
+		}
+	}
+	if _, err := XGMIHives(nil, ""); err == nil {
+		t.Errorf("XGMIHives call is expected to return error but got nil")
+	}
+}
+
+func TestDefaultTopoFolder(t *testing.T) {
+	defer func(sysfs string) { amdgpu.SysfsRoot = sysfs }(amdgpu.SysfsRoot)
+	amdgpu.SysfsRoot = t.TempDir()
+	topo, err := filepath.Abs(mi210Topo.topoFolderPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kfd := amdgpu.SysfsPath("class/kfd/kfd/topology")
+	if err := os.MkdirAll(kfd, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(topo, filepath.Join(kfd, "nodes")); err != nil {
+		t.Fatal(err)
+	}
+	devices := mi210Topo.getTestDevices()
+	hives, err := XGMIHives(devices, "")
+	if err != nil {
+		t.Fatalf("XGMIHives call failed. Error:%v", err)
+	}
+	if len(hives) != 2 {
+		t.Errorf("expected the 2 hives of %s under the sysfs root, but got %d hives", mi210Topo.topoFolderPath, len(hives))
+	}
+	p2pWeights, err := PairWeights(devices, "", config.DefaultWeights())
+	if err != nil {
+		t.Fatalf("PairWeights call failed. Error:%v", err)
+	}
+	expWeights, err := PairWeights(devices, mi210Topo.topoFolderPath, config.DefaultWeights())
+	if err != nil {
+		t.Fatalf("PairWeights call failed. Error:%v", err)
+	}
+	if fmt.Sprint(p2pWeights) != fmt.Sprint(expWeights) {
+		t.Errorf("weights under the sysfs root not as expected. Expected %v but got %v", expWeights, p2pWeights)
+	}
+}
+
+func TestCalculatePairWeight(t *testing.T) {
+	gpu0 := &Device{Id: "test1", DevId: "0", NumaNode: 0, MemoryDomain: 1}
+	xcp0 := &Device{Id: "amdgpu_xcp_1", DevId: "0", NumaNode: 0, MemoryDomain: 1}
+	xcp1 := &Device{Id: "amdgpu_xcp_2", DevId: "0", NumaNode: 0, MemoryDomain: 2}
+	xcpUnknown := &Device{Id: "amdgpu_xcp_3", DevId: "0", NumaNode: 0}
+	gpu1 := &Device{Id: "test2", DevId: "1", NumaNode: 1}
+	numaFirst := config.DefaultWeights()
+	numaFirst.DifferentNUMANode = 100
+	numaFirst.Links = map[int]int{config.LinkTypeXGMI: 0}
+
+	testcases := []struct {
+		description string
+		from, to    *Device
+		linkType    int
+		weights     config.WeightsConfig
+		expected    int
+	}{
+		{"partitions of the same GPU", gpu0, xcp0, config.LinkTypeXGMI, config.DefaultWeights(), 30},
+		{"partitions of different memory partitions", gpu0, xcp1, config.LinkTypeXGMI, config.DefaultWeights(), 40},
+		{"partition of an unknown memory partition", xcp1, xcpUnknown, config.LinkTypeXGMI, config.DefaultWeights(), 30},
+		{"GPUs linked by XGMI", gpu0, gpu1, config.LinkTypeXGMI, config.DefaultWeights(), 50},
+		{"GPUs linked by PCIe", gpu0, gpu1, config.LinkTypePCIe, config.DefaultWeights(), 80},
+		{"GPUs linked by another link", gpu0, gpu1, 5, config.DefaultWeights(), 90},
+		{"NUMA node over link type", gpu0, gpu1, config.LinkTypeXGMI, numaFirst, 120},
+		{"link type without weight", gpu0, gpu1, config.LinkTypePCIe, numaFirst, 170},
+	}
+	for _, tc := range testcases {
+		if weight := calculatePairWeight(tc.from, tc.to, tc.linkType, tc.weights); weight != tc.expected {
+			t.Errorf("%s: expected weight %d but got %d", tc.description, tc.expected, weight)
+		}
+	}
+}
+
+// writeTopology writes a KFD topology of GPU nodes 1 to gpuCount, linked
+// by the given XGMI links properties
+func writeTopology(t *testing.T, gpuCount int, links map[[2]int]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	linkIdx := make(map[int]int)
+	for node := 1; node <= gpuCount; node++ {
+		nodeDir := filepath.Join(dir, strconv.Itoa(node))
+		if err := os.MkdirAll(nodeDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		props := fmt.Sprintf("simd_count 304\ndrm_render_minor %d\n", 127+node)
+		if err := os.WriteFile(filepath.Join(nodeDir, "properties"), []byte(props), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for pair, props := range links {
+		for _, from := range pair {
+			linkDir := filepath.Join(dir, strconv.Itoa(from), "io_links", strconv.Itoa(linkIdx[from]))
+			linkIdx[from]++
+			if err := os.MkdirAll(linkDir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			data := fmt.Sprintf("type 11\nnode_from %d\nnode_to %d\n%s", from, pair[0]+pair[1]-from, props)
+			if err := os.WriteFile(filepath.Join(linkDir, "properties"), []byte(data), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return dir
+}
+
+func TestMeasuredLinkWeights(t *testing.T) {
+	// GPUs 1 and 2 are linked by two XGMI links, the others by one
+	dir := writeTopology(t, 3, map[[2]int]string{
+		{1, 2}: "weight 15\nmin_bandwidth 100000\nmax_bandwidth 100000\nmin_latency 500\n",
+		{1, 3}: "weight 15\nmin_bandwidth 50000\nmax_bandwidth 50000\nmin_latency 1000\n",
+		{2, 3}: "weight 30\nmin_bandwidth 0\nmax_bandwidth 0\nmin_latency 0\n",
+	})
+	devices := []*Device{
+		{Id: "test1", NodeId: 1, DevId: "0"},
+		{Id: "test2", NodeId: 2, DevId: "1"},
+		{Id: "test3", NodeId: 3, DevId: "2"},
+	}
+	p2pWeights := make(map[int]map[int]int)
+	if err := fetchAllPairWeights(devices, p2pWeights, dir, config.DefaultWeights()); err != nil {
+		t.Fatalf("fetchAllPairWeights call failed. Error:%v", err)
+	}
+	// the type based weight of all pairs is 40, to which the half bandwidth
+	// and double latency of 1-3 add 5 each, and the double weight of 2-3 adds 5
+	expected := map[int]map[int]int{1: {2: 40, 3: 50}, 2: {3: 45}}
+	if fmt.Sprint(p2pWeights) != fmt.Sprint(expected) {
+		t.Errorf("pair weights not as expected. Expected %v but got %v", expected, p2pWeights)
+	}
+
+	policy := NewBestEffortPolicy()
+	if err := policy.Init(devices, dir); err != nil {
+		t.Fatalf("expected Init to pass. But failed with error %v", err)
+	}
+	ids, err := policy.Allocate([]string{"test1", "test2", "test3"}, nil, 2)
+	sort.Strings(ids)
+	if err != nil || fmt.Sprint(ids) != "[test1 test2]" {
+		t.Errorf("expected the pair with two XGMI links to be allocated, but got %v %v", ids, err)
+	}
+}
@@ -0,0 +1,80 @@
+/**
+ * Copyright 2025 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package allocator
+
+import (
+	"strconv"
+
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/config"
+	"github.com/golang/glog"
+)
+
+/**
+*  XGMI hive policy only allocates devices of the same XGMI hive, so that all of them can communicate over XGMI. It
+*  fails if no hive has enough devices available, rather than falling back to devices connected over PCIe. Among the
+*  hives that fit, the one with the fewest devices available is preferred, and the devices within it are picked as
+*  the best effort policy does.
+**/
+
+type HivePolicy struct {
+	bestEffort *BestEffortPolicy
+	// hives maps the device IDs to the index of their hive
+	hives map[string]int
+}
+
+func NewHivePolicy() *HivePolicy {
+	return newHivePolicy(config.DefaultWeights())
+}
+
+func newHivePolicy(weights config.WeightsConfig) *HivePolicy {
+	return &HivePolicy{
+		bestEffort: newBestEffortPolicy(weights),
+		hives:      make(map[string]int),
+	}
+}
+
+// Init initializes the pair wise weights of all devices and their hives
+func (h *HivePolicy) Init(devs []*Device, topoDir string) error {
+	if err := h.bestEffort.Init(devs, topoDir); err != nil {
+		return err
+	}
+	hives, err := XGMIHives(devs, topoDir)
+	if err != nil {
+		return err
+	}
+	h.hives = make(map[string]int)
+	for idx, hive := range hives {
+		for _, d := range hive {
+			h.hives[d.Id] = idx
+		}
+	}
+	glog.Infof("found %d XGMI hives", len(hives))
+	return nil
+}
+
+func (h *HivePolicy) hive(d *Device) string {
+	return strconv.Itoa(h.hives[d.Id])
+}
+
+func (h *HivePolicy) Allocate(availableIds, requiredIds []string, size int) ([]string, error) {
+	outset, score, err := allocateInGroup(h.bestEffort, availableIds, requiredIds, size, h.hive, "XGMI hive")
+	if err != nil {
+		return outset, err
+	}
+	glog.Infof("XGMI hive device subset:%v score:%v", outset, score)
+	return outset, nil
+}
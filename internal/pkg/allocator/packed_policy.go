@@ -0,0 +1,155 @@
+/**
+ * Copyright 2025 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package allocator
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/config"
+	"github.com/golang/glog"
+)
+
+/**
+*  Packed policy only allocates devices of the same NUMA node and, if the GPUs are partitioned, partitions of the
+*  same GPU and NUMA node, as the partitions of a GPU in different memory partitions may be on different NUMA nodes.
+*  It fails if no NUMA node or GPU has enough devices available. Among the NUMA nodes or GPUs that fit, the one with
+*  the fewest devices available is preferred to limit fragmentation, and the devices within it are picked as the
+*  best effort policy does.
+**/
+
+type PackedPolicy struct {
+	bestEffort *BestEffortPolicy
+	// partitioned is set if the GPUs are partitioned, devices are then
+	// packed per GPU and NUMA node instead of per NUMA node
+	partitioned bool
+}
+
+func NewPackedPolicy() *PackedPolicy {
+	return newPackedPolicy(config.DefaultWeights())
+}
+
+func newPackedPolicy(weights config.WeightsConfig) *PackedPolicy {
+	return &PackedPolicy{
+		bestEffort: newBestEffortPolicy(weights),
+	}
+}
+
+// Init initializes the pair wise weights of all devices
+func (p *PackedPolicy) Init(devs []*Device, topoDir string) error {
+	if err := p.bestEffort.Init(devs, topoDir); err != nil {
+		return err
+	}
+	p.partitioned = false
+	for _, par := range p.bestEffort.devicePartitions {
+		if len(par.Devs) > 1 {
+			p.partitioned = true
+			break
+		}
+	}
+	return nil
+}
+
+// pack returns the group a device is packed in
+func (p *PackedPolicy) pack(d *Device) string {
+	if p.partitioned {
+		return d.DevId + "/" + strconv.Itoa(d.NumaNode)
+	}
+	return strconv.Itoa(d.NumaNode)
+}
+
+func (p *PackedPolicy) Allocate(availableIds, requiredIds []string, size int) ([]string, error) {
+	outset, score, err := allocateInGroup(p.bestEffort, availableIds, requiredIds, size, p.pack, "NUMA node or GPU")
+	if err != nil {
+		return outset, err
+	}
+	glog.Infof("packed device subset:%v score:%v", outset, score)
+	return outset, nil
+}
+
+// allocateInGroup allocates devices of a single group, as returned by group,
+// picking them as the best effort policy does. Among the groups that fit,
+// the one with the fewest devices available is preferred. kind names the
+// groups in errors.
+func allocateInGroup(b *BestEffortPolicy, availableIds, requiredIds []string, size int, group func(*Device) string, kind string) ([]string, int, error) {
+	outset := []string{}
+	if err := validateRequest(b.devices, availableIds, requiredIds, size); err != nil {
+		return outset, 0, err
+	}
+	if !setContainsAll(availableIds, requiredIds) {
+		return outset, 0, fmt.Errorf(noCandidateFound)
+	}
+
+	requiredGroup := ""
+	for _, d := range b.getDevicesFromIds(requiredIds) {
+		if requiredGroup != "" && group(d) != requiredGroup {
+			return outset, 0, fmt.Errorf("must_include devices are not on the same %s", kind)
+		}
+		requiredGroup = group(d)
+	}
+	if len(requiredIds) == size {
+		return requiredIds, 0, nil
+	}
+
+	groups := make(map[string][]string)
+	for _, d := range b.getDevicesFromIds(availableIds) {
+		groups[group(d)] = append(groups[group(d)], d.Id)
+	}
+	fit := make([]string, 0, len(groups))
+	for g, ids := range groups {
+		if len(ids) >= size && (requiredGroup == "" || g == requiredGroup) {
+			fit = append(fit, g)
+		}
+	}
+	noCandidate := fmt.Errorf("no %s has %d devices available", kind, size)
+	if len(fit) == 0 {
+		return outset, 0, noCandidate
+	}
+	sort.Slice(fit, func(i, j int) bool {
+		if len(groups[fit[i]]) == len(groups[fit[j]]) {
+			return fit[i] < fit[j]
+		}
+		return len(groups[fit[i]]) < len(groups[fit[j]])
+	})
+
+	// only the groups with the fewest devices available are considered
+	bestScore := math.MaxInt32
+	for _, g := range fit {
+		ids := groups[g]
+		if len(ids) > len(groups[fit[0]]) {
+			break
+		}
+		subset := ids
+		score := 0
+		if len(ids) > size {
+			var err error
+			if subset, score, err = b.allocate(ids, requiredIds, size); err != nil {
+				continue
+			}
+		}
+		if score < bestScore {
+			outset = subset
+			bestScore = score
+		}
+	}
+	if len(outset) == 0 {
+		return outset, 0, noCandidate
+	}
+	return outset, bestScore, nil
+}
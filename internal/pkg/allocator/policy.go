@@ -0,0 +1,47 @@
+/**
+ * Copyright 2025 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package allocator
+
+import (
+	"fmt"
+
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/config"
+)
+
+// policies holds the constructors of the named policies, given the pair
+// weights. The none policy has no constructor, the kubelet allocates
+// devices on its own.
+var policies = map[string]func(weights config.WeightsConfig) Policy{
+	config.PolicyBestEffort: func(weights config.WeightsConfig) Policy { return newBestEffortPolicy(weights) },
+	config.PolicyPacked:     func(weights config.WeightsConfig) Policy { return newPackedPolicy(weights) },
+	config.PolicySpread:     func(config.WeightsConfig) Policy { return NewSpreadPolicy() },
+	config.PolicyXGMIHive:   func(weights config.WeightsConfig) Policy { return newHivePolicy(weights) },
+	config.PolicyNone:       nil,
+}
+
+// NewPolicy returns a new instance of the named policy scoring pairs of
+// devices with weights, or nil for the none policy
+func NewPolicy(name string, weights config.WeightsConfig) (Policy, error) {
+	newPolicy, ok := policies[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown allocator policy %q", name)
+	}
+	if newPolicy == nil {
+		return nil, nil
+	}
+	return newPolicy(weights), nil
+}
@@ -0,0 +1,242 @@
+/**
+ * Copyright 2025 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package allocator
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/config"
+)
+
+var (
+	mi210Topo = testInfo{
+		devCount:             8,
+		partitionCountPerDev: 1,
+		numanodeCount:        2,
+		startNodeId:          2,
+		endNodeId:            9,
+		topoFolderPath:       "../../../testdata/topo-mi210-xgmi-pcie/nodes",
+	}
+	mi300CPXTopo = testInfo{
+		devCount:             8,
+		partitionCountPerDev: 8,
+		numanodeCount:        2,
+		startNodeId:          2,
+		endNodeId:            64,
+		topoFolderPath:       "../../../testdata/topo-mi300-cpx/topology/nodes",
+	}
+)
+
+func TestNewPolicy(t *testing.T) {
+	for _, name := range config.Policies {
+		policy, err := NewPolicy(name, config.DefaultWeights())
+		if err != nil {
+			t.Errorf("expected policy %s to be registered, got error %v", name, err)
+		}
+		if (policy == nil) != (name == config.PolicyNone) {
+			t.Errorf("unexpected instance of policy %s: %v", name, policy)
+		}
+	}
+	if _, err := NewPolicy("random", config.DefaultWeights()); err == nil {
+		t.Errorf("expected unknown policy to fail")
+	}
+}
+
+func testPolicy(t *testing.T, policy Policy, topo testInfo, testcases []testInfo) {
+	t.Helper()
+	devices := topo.getTestDevices()
+	allAvailableIds := make([]string, 0)
+	for _, d := range devices {
+		allAvailableIds = append(allAvailableIds, d.Id)
+	}
+	if err := policy.Init(devices, topo.topoFolderPath); err != nil {
+		t.Fatalf("expected Init to pass. But failed with error %v", err)
+	}
+	for _, tc := range testcases {
+		av := allAvailableIds
+		if len(tc.available) > 0 {
+			av = tc.available
+		}
+		if len(tc.filtered) > 0 {
+			av = topo.getFilteredDeviceIds(av, tc.filtered)
+		}
+		result, err := policy.Allocate(av, tc.required, tc.size)
+		if tc.expectedIds == nil {
+			if err == nil {
+				t.Errorf("%s: expected Allocate to fail, but got %v", tc.description, result)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: expected Allocate to pass. But failed with error %v", tc.description, err)
+			continue
+		}
+		sort.Strings(result)
+		sort.Strings(tc.expectedIds)
+		if strings.Join(result, ",") != strings.Join(tc.expectedIds, ",") {
+			t.Errorf("%s: result set not as expected. Expected %v but got %v", tc.description, tc.expectedIds, result)
+		}
+	}
+}
+
+func TestPackedPolicy(t *testing.T) {
+	testPolicy(t, NewPackedPolicy(), mi210Topo, []testInfo{
+		{
+			description: "Allocate 3 devices of the same NUMA node",
+			size:        3,
+			expectedIds: []string{"test1", "test2", "test3"},
+		},
+		{
+			description: "Allocate on the NUMA node with the fewest devices available",
+			size:        2,
+			filtered:    []string{"test6"},
+			expectedIds: []string{"test5", "test7"},
+		},
+		{
+			description: "Allocate more devices than a NUMA node has",
+			size:        5,
+		},
+		{
+			description: "Allocate with required devices on different NUMA nodes",
+			size:        2,
+			required:    []string{"test1", "test5"},
+		},
+	})
+	testPolicy(t, NewPackedPolicy(), mi300CPXTopo, []testInfo{
+		{
+			description: "Allocate 3 partitions of the GPU with the fewest available",
+			size:        3,
+			expectedIds: []string{"test8", "amdgpu_xcp_57", "amdgpu_xcp_58"},
+		},
+		{
+			description: "Allocate with a required partition",
+			size:        2,
+			required:    []string{"test5"},
+			expectedIds: []string{"test5", "amdgpu_xcp_33"},
+		},
+		{
+			description: "Allocate more partitions than a GPU has",
+			size:        9,
+		},
+	})
+}
+
+func TestPackedPolicyPartitionNumaNodes(t *testing.T) {
+	// as in NPS4, the last 4 partitions of the first GPU are in a memory
+	// partition of another NUMA node
+	devices := mi300CPXTopo.getTestDevices()
+	var available []string
+	for i, d := range devices[:8] {
+		available = append(available, d.Id)
+		d.MemoryDomain = i/4 + 1
+		if i >= 4 {
+			d.NumaNode = 1
+		}
+	}
+	p := NewPackedPolicy()
+	if err := p.Init(devices, mi300CPXTopo.topoFolderPath); err != nil {
+		t.Fatalf("expected Init to pass. But failed with error %v", err)
+	}
+	testcases := []struct {
+		description string
+		required    []string
+		size        int
+		expectedIds []string
+	}{
+		{"Allocate partitions of a single NUMA node", nil, 4, []string{"test1", "amdgpu_xcp_1", "amdgpu_xcp_2", "amdgpu_xcp_3"}},
+		{"Allocate with a required partition", []string{"amdgpu_xcp_5"}, 3, []string{"amdgpu_xcp_4", "amdgpu_xcp_5", "amdgpu_xcp_6"}},
+		{"Allocate more partitions than a NUMA node of the GPU has", nil, 5, nil},
+		{"Allocate with required partitions on different NUMA nodes", []string{"test1", "amdgpu_xcp_5"}, 2, nil},
+	}
+	for _, tc := range testcases {
+		result, err := p.Allocate(available, tc.required, tc.size)
+		if tc.expectedIds == nil {
+			if err == nil {
+				t.Errorf("%s: expected Allocate to fail, but got %v", tc.description, result)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: expected Allocate to pass. But failed with error %v", tc.description, err)
+			continue
+		}
+		sort.Strings(result)
+		sort.Strings(tc.expectedIds)
+		if strings.Join(result, ",") != strings.Join(tc.expectedIds, ",") {
+			t.Errorf("%s: result set not as expected. Expected %v but got %v", tc.description, tc.expectedIds, result)
+		}
+	}
+}
+
+func TestSpreadPolicy(t *testing.T) {
+	testPolicy(t, NewSpreadPolicy(), mi300CPXTopo, []testInfo{
+		{
+			description: "Allocate 4 partitions of different GPUs and NUMA nodes",
+			size:        4,
+			expectedIds: []string{"test1", "test2", "test5", "test6"},
+		},
+		{
+			description: "Allocate 9 partitions",
+			size:        9,
+			expectedIds: []string{"test1", "test2", "test3", "test4", "test5", "test6", "test7", "test8", "amdgpu_xcp_1"},
+		},
+		{
+			description: "Allocate with a required partition",
+			size:        3,
+			required:    []string{"amdgpu_xcp_1"},
+			expectedIds: []string{"amdgpu_xcp_1", "test5", "test2"},
+		},
+	})
+}
+
+func TestHivePolicy(t *testing.T) {
+	testPolicy(t, NewHivePolicy(), mi210Topo, []testInfo{
+		{
+			description: "Allocate a whole hive",
+			size:        4,
+			expectedIds: []string{"test1", "test2", "test3", "test4"},
+		},
+		{
+			description: "Allocate on the hive with the fewest devices available",
+			size:        3,
+			filtered:    []string{"test2"},
+			expectedIds: []string{"test1", "test3", "test4"},
+		},
+		{
+			description: "Allocate with a required device",
+			size:        2,
+			required:    []string{"test6"},
+			expectedIds: []string{"test6", "test5"},
+		},
+		{
+			description: "Allocate more devices than a hive has",
+			size:        5,
+		},
+		{
+			description: "Allocate when no hive has enough devices available",
+			size:        4,
+			filtered:    []string{"test1", "test8"},
+		},
+		{
+			description: "Allocate with required devices in different hives",
+			size:        2,
+			required:    []string{"test1", "test5"},
+		},
+	})
+}
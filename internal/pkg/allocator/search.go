@@ -0,0 +1,331 @@
+/**
+# Copyright 2025 Advanced Micro Devices, Inc. All rights reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the \"License\");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an \"AS IS\" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+**/
+
+package allocator
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// searchTimeout bounds the time spent looking for the best subset of
+// devices in an allocation, the best subset found so far is returned once
+// it is exceeded
+var searchTimeout = 100 * time.Millisecond
+
+// deadlineCheckInterval is the number of search nodes visited between two
+// checks of the deadline
+const deadlineCheckInterval = 1024
+
+// subsetSearch looks for the subset of devices with the lowest total weight
+// with a depth first branch and bound search.
+//
+// A candidate subset takes all the available partitions of some GPUs, the
+// full groups, and completes the request with the partitions of one more
+// GPU, the partial group, that add the lowest weight to them. Every set of full groups is visited once, and
+// completed with every partial group that fits. The branches whose total
+// weight can't get below the best one found, or the worst of the best ones
+// kept, are cut.
+//
+// Groups are sorted in ascending order of available partitions. On ties, the
+// subset spread over the fewest GPUs is preferred, then the one completed
+// from the GPU with the fewest partitions available, so that the GPUs with
+// the most partitions available are not fragmented.
+type subsetSearch struct {
+	groups     []*DevicePartitions
+	p2pWeights map[int]map[int]int
+	size       int
+	// minWeight is the lowest weight of a pair of devices
+	minWeight int
+	deadline  time.Time
+	visited   int
+	timedOut  bool
+
+	// keep is the number of candidates kept, best holds them from the
+	// best to the worst
+	keep int
+	best []*searchCandidate
+}
+
+type searchCandidate struct {
+	set     *DeviceSet
+	full    []int
+	partial int
+}
+
+// less returns true if the candidate c is better than o
+func (c *searchCandidate) less(o *searchCandidate) bool {
+	if c.set.TotalWeight != o.set.TotalWeight {
+		return c.set.TotalWeight < o.set.TotalWeight
+	}
+	if len(c.full) != len(o.full) {
+		return len(c.full) < len(o.full)
+	}
+	if c.partial != o.partial {
+		return c.partial < o.partial
+	}
+	return slices.Compare(c.full, o.full) < 0
+}
+
+func pairWeight(p2pWeights map[int]map[int]int, a, b int) int {
+	if a > b {
+		a, b = b, a
+	}
+	return p2pWeights[a][b]
+}
+
+// addedWeight returns the weight added to a subset of ids by adding more
+func (s *subsetSearch) addedWeight(ids, more []int) int {
+	weight := 0
+	for i, id := range more {
+		for _, other := range ids {
+			weight += pairWeight(s.p2pWeights, id, other)
+		}
+		for _, other := range more[:i] {
+			weight += pairWeight(s.p2pWeights, id, other)
+		}
+	}
+	return weight
+}
+
+// complete returns the need partitions of a group that best complete a
+// subset of ids, and the weight they add. Starting from each partition of
+// the group in turn, the partition adding the lowest weight is picked until
+// need are, so that the partitions sharing a memory partition or a NUMA node
+// with the subset are preferred. On ties, the partitions with the lowest
+// node ids are picked.
+func (s *subsetSearch) complete(ids, group []int, need int) ([]int, int) {
+	if need == len(group) {
+		return group, s.addedWeight(ids, group)
+	}
+	// base holds the weight each partition adds to ids
+	base := make([]int, len(group))
+	for k, id := range group {
+		for _, other := range ids {
+			base[k] += pairWeight(s.p2pWeights, id, other)
+		}
+	}
+	var best []int
+	bestWeight := 0
+	added := make([]int, len(group))
+	picked := make([]bool, len(group))
+	for first := range group {
+		copy(added, base)
+		clear(picked)
+		subset := make([]int, 0, need)
+		weight := 0
+		for next := first; ; {
+			picked[next] = true
+			subset = append(subset, group[next])
+			weight += added[next]
+			if len(subset) == need {
+				break
+			}
+			next = -1
+			for k, id := range group {
+				if picked[k] {
+					continue
+				}
+				added[k] += pairWeight(s.p2pWeights, id, subset[len(subset)-1])
+				if next < 0 || added[k] < added[next] {
+					next = k
+				}
+			}
+		}
+		if best == nil || weight < bestWeight {
+			best, bestWeight = subset, weight
+		}
+	}
+	return best, bestWeight
+}
+
+// worst returns the worst of the candidates kept once as many as needed
+// were found
+func (s *subsetSearch) worst() *searchCandidate {
+	if len(s.best) < s.keep {
+		return nil
+	}
+	return s.best[len(s.best)-1]
+}
+
+// sameIds returns true if the candidates hold the same devices
+func (c *searchCandidate) sameIds(o *searchCandidate) bool {
+	a, b := slices.Clone(c.set.Ids), slices.Clone(o.set.Ids)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(a, b)
+}
+
+// add keeps a candidate if it is among the best ones. A subset can be
+// reached from several sets of full groups when whole groups complete it,
+// only its best occurrence is kept.
+func (s *subsetSearch) add(c *searchCandidate) {
+	if worst := s.worst(); worst != nil && !c.less(worst) {
+		return
+	}
+	for i, o := range s.best {
+		if c.sameIds(o) {
+			if !c.less(o) {
+				return
+			}
+			s.best = slices.Delete(s.best, i, i+1)
+			break
+		}
+	}
+	idx, _ := slices.BinarySearchFunc(s.best, c, func(a, b *searchCandidate) int {
+		if a.less(b) {
+			return -1
+		}
+		return 1
+	})
+	s.best = slices.Insert(s.best, idx, c)
+	if len(s.best) > s.keep {
+		s.best = s.best[:s.keep]
+	}
+}
+
+// lowerBound returns the lowest total weight a subset of count devices and
+// weight can reach once completed
+func (s *subsetSearch) lowerBound(count, weight int) int {
+	pairs := s.size*(s.size-1)/2 - count*(count-1)/2
+	return weight + pairs*s.minWeight
+}
+
+// search completes the full groups with every partial group that fits, then
+// adds each of the groups from i on to the full groups
+func (s *subsetSearch) search(i int, full, ids []int, weight int) {
+	if s.timedOut {
+		return
+	}
+	s.visited++
+	if s.visited%deadlineCheckInterval == 0 && time.Now().After(s.deadline) {
+		s.timedOut = true
+		return
+	}
+	need := s.size - len(ids)
+	for j, group := range s.groups {
+		if len(group.Ids) < need || slices.Contains(full, j) {
+			continue
+		}
+		partial, added := s.complete(ids, group.Ids, need)
+		total := weight + added
+		if worst := s.worst(); worst != nil && total > worst.set.TotalWeight {
+			continue
+		}
+		subset := make([]int, 0, s.size)
+		subset = append(subset, ids...)
+		subset = append(subset, partial...)
+		s.add(&searchCandidate{set: NewDeviceSet(subset, full, total, j), full: full, partial: j})
+	}
+	for k := i; k < len(s.groups); k++ {
+		group := s.groups[k].Ids
+		if len(group) >= need {
+			continue
+		}
+		total := weight + s.addedWeight(ids, group)
+		if worst := s.worst(); worst != nil && s.lowerBound(len(ids)+len(group), total) > worst.set.TotalWeight {
+			continue
+		}
+		nextFull := append(slices.Clone(full), k)
+		nextIds := append(slices.Clone(ids), group...)
+		s.search(k+1, nextFull, nextIds, total)
+	}
+}
+
+// minPairWeight returns the lowest weight of a pair of the devices. Pairs
+// without a weight, such as devices without an io_link between them, weigh
+// 0 as in pairWeight.
+func minPairWeight(available, required []*Device, p2pWeights map[int]map[int]int) int {
+	var ids []int
+	for _, d := range slices.Concat(available, required) {
+		if !slices.Contains(ids, d.NodeId) {
+			ids = append(ids, d.NodeId)
+		}
+	}
+	minWeight := -1
+	for i, a := range ids {
+		for _, b := range ids[i+1:] {
+			weight, ok := p2pWeights[min(a, b)][max(a, b)]
+			if !ok {
+				return 0
+			}
+			if minWeight < 0 || weight < minWeight {
+				minWeight = weight
+			}
+		}
+	}
+	return max(minWeight, 0)
+}
+
+// findBestDeviceSubset returns the subset of size devices, including the
+// required ones, with the lowest total pair weight that could be found
+// within searchTimeout
+func findBestDeviceSubset(allDevPartitions map[string]*DevicePartitions, available, required []*Device, size int, p2pWeights map[int]map[int]int) (*DeviceSet, error) {
+	subsets, err := findDeviceSubsets(allDevPartitions, available, required, size, p2pWeights, 1)
+	if err != nil {
+		return nil, err
+	}
+	return subsets[0], nil
+}
+
+// findDeviceSubsets returns up to keep subsets of size devices, including
+// the required ones, from the best to the worst
+func findDeviceSubsets(allDevPartitions map[string]*DevicePartitions, available, required []*Device, size int, p2pWeights map[int]map[int]int, keep int) ([]*DeviceSet, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("subset size should be positive integer")
+	}
+
+	if len(available) < size {
+		return nil, fmt.Errorf("subset size is more than available devices")
+	}
+
+	// filterPartitions - partitions from same gpu are grouped into one set.
+	// the sets are sorted in ascending order of partitions available for allocation.
+	s := &subsetSearch{
+		groups:     filterPartitions(allDevPartitions, available, required),
+		p2pWeights: p2pWeights,
+		size:       size,
+		deadline:   time.Now().Add(searchTimeout),
+		keep:       max(keep, 1),
+	}
+	s.minWeight = minPairWeight(available, required, p2pWeights)
+
+	var ids []int
+	for _, req := range required {
+		ids = append(ids, req.NodeId)
+	}
+	weight := s.addedWeight(nil, ids)
+	if len(ids) == size {
+		return []*DeviceSet{NewDeviceSet(ids, nil, weight, 0)}, nil
+	}
+	s.search(0, nil, ids, weight)
+	if s.timedOut && len(s.best) == 0 {
+		glog.Warningf("device subset search stopped after %v and %d nodes without finding a subset", searchTimeout, s.visited)
+	} else if s.timedOut {
+		glog.Warningf("device subset search stopped after %v and %d nodes, using the best subset found", searchTimeout, s.visited)
+	}
+	if len(s.best) == 0 {
+		return nil, fmt.Errorf(noCandidateFound)
+	}
+	subsets := make([]*DeviceSet, 0, len(s.best))
+	for _, c := range s.best {
+		subsets = append(subsets, c.set)
+	}
+	return subsets, nil
+}
@@ -0,0 +1,317 @@
+/**
+# Copyright 2025 Advanced Micro Devices, Inc. All rights reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the \"License\");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an \"AS IS\" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+**/
+
+package allocator
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/config"
+)
+
+// searchFixture holds the devices of a topology and their pair weights
+type searchFixture struct {
+	devices    []*Device
+	partitions map[string]*DevicePartitions
+	p2pWeights map[int]map[int]int
+}
+
+func newSearchFixture(tb testing.TB, topo testInfo) *searchFixture {
+	tb.Helper()
+	f := &searchFixture{
+		devices:    topo.getTestDevices(),
+		p2pWeights: make(map[int]map[int]int),
+	}
+	if err := fetchAllPairWeights(f.devices, f.p2pWeights, topo.topoFolderPath, config.DefaultWeights()); err != nil {
+		tb.Fatalf("fetchAllPairWeights call failed. Error:%v", err)
+	}
+	f.partitions = groupPartitionsByDevId(f.devices)
+	return f
+}
+
+// available returns the devices left after allocating random ones
+func (f *searchFixture) available(r *rand.Rand, allocated int) []*Device {
+	res := make([]*Device, len(f.devices))
+	copy(res, f.devices)
+	r.Shuffle(len(res), func(i, j int) { res[i], res[j] = res[j], res[i] })
+	return res[allocated:]
+}
+
+// enumeratedScore returns the lowest score of the subsets enumerated
+// exhaustively
+func (f *searchFixture) enumeratedScore(available, required []*Device, size int) int {
+	subsets, _ := enumerateDeviceSubsets(f.partitions, f.devices, available, required, size, f.p2pWeights)
+	best := math.MaxInt32
+	for _, subset := range subsets {
+		best = min(best, subset.TotalWeight)
+	}
+	return best
+}
+
+// unboundedScore returns the lowest score of the subsets the search visits
+// when no branch is cut
+func (f *searchFixture) unboundedScore(available, required []*Device, size int) int {
+	s := &subsetSearch{
+		groups:     filterPartitions(f.partitions, available, required),
+		p2pWeights: f.p2pWeights,
+		size:       size,
+		deadline:   time.Now().Add(time.Hour),
+		keep:       math.MaxInt32,
+	}
+	var ids []int
+	for _, req := range required {
+		ids = append(ids, req.NodeId)
+	}
+	s.search(0, nil, ids, s.addedWeight(nil, ids))
+	return s.best[0].set.TotalWeight
+}
+
+func TestFindBestDeviceSubset(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, topo := range []testInfo{mi210Topo, mi300CPXTopo} {
+		f := newSearchFixture(t, topo)
+		for _, allocated := range []int{0, 3, 10} {
+			if allocated >= len(f.devices) {
+				continue
+			}
+			available := f.available(r, allocated)
+			for size := 1; size <= 12 && size <= len(available); size++ {
+				var required []*Device
+				if size > 2 {
+					required = available[:1]
+				}
+				expected := f.enumeratedScore(available, required, size)
+				subset, err := findBestDeviceSubset(f.partitions, available, required, size, f.p2pWeights)
+				if err != nil {
+					t.Errorf("%s: expected search of %d devices to pass. But failed with error %v", topo.topoFolderPath, size, err)
+					continue
+				}
+				if subset.Size != size || subset.TotalWeight != expected {
+					t.Errorf("%s: subset of %d devices with %d allocated not as expected. Expected score %d but got %d for %v",
+						topo.topoFolderPath, size, allocated, expected, subset.TotalWeight, subset.Ids)
+				}
+			}
+		}
+	}
+}
+
+func TestFindBestDeviceSubsetSparse(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	f := newSearchFixture(t, mi300CPXTopo)
+	// drop a third of the pairs, as if the devices had no io_link between
+	// them, they weigh 0 and the pairs left can't bound the search
+	for _, peers := range f.p2pWeights {
+		for to := range peers {
+			if r.Intn(3) == 0 {
+				delete(peers, to)
+			}
+		}
+	}
+	for _, allocated := range []int{0, 10, 20, 30} {
+		available := f.available(r, allocated)
+		for size := 2; size <= 10; size++ {
+			// the partitions completing a subset are chosen by weight, the
+			// search can do better than the enumeration
+			expected := f.unboundedScore(available, nil, size)
+			if enumerated := f.enumeratedScore(available, nil, size); expected > enumerated {
+				t.Errorf("subset of %d devices with %d allocated scores %d, worse than the %d enumerated", size, allocated, expected, enumerated)
+			}
+			subset, err := findBestDeviceSubset(f.partitions, available, nil, size, f.p2pWeights)
+			if err != nil {
+				t.Errorf("expected search of %d devices to pass. But failed with error %v", size, err)
+				continue
+			}
+			if subset.TotalWeight != expected {
+				t.Errorf("subset of %d devices with %d allocated not as expected. Expected score %d but got %d for %v",
+					size, allocated, expected, subset.TotalWeight, subset.Ids)
+			}
+		}
+	}
+}
+
+func TestFindBestDeviceSubsetTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { searchTimeout = timeout }(searchTimeout)
+	searchTimeout = 0
+	f := newSearchFixture(t, mi300CPXTopo)
+	// the best subset found when the search stops is returned
+	subset, err := findBestDeviceSubset(f.partitions, f.devices, nil, 40, f.p2pWeights)
+	if err != nil || subset.Size != 40 {
+		t.Errorf("expected a subset of 40 devices, but got %v %v", subset, err)
+	}
+
+	// the search stops even if no subset was found yet
+	s := &subsetSearch{groups: filterPartitions(f.partitions, f.devices, nil), size: 40, keep: 1}
+	s.visited = deadlineCheckInterval - 1
+	s.search(0, nil, nil, 0)
+	if !s.timedOut || len(s.best) != 0 {
+		t.Errorf("expected the search to stop before finding a subset, got %d subsets", len(s.best))
+	}
+}
+
+func BenchmarkFindBestDeviceSubset(b *testing.B) {
+	f := newSearchFixture(b, mi300CPXTopo)
+	available := f.available(rand.New(rand.NewSource(1)), 5)
+	for _, size := range []int{2, 8, 16, 32, 48} {
+		b.Run(fmt.Sprintf("size-%d", size), func(b *testing.B) {
+			var score int
+			for i := 0; i < b.N; i++ {
+				subset, err := findBestDeviceSubset(f.partitions, available, nil, size, f.p2pWeights)
+				if err != nil {
+					b.Fatal(err)
+				}
+				score = subset.TotalWeight
+			}
+			b.ReportMetric(float64(score), "score")
+		})
+	}
+}
+
+// BenchmarkEnumerateDeviceSubsets measures the exhaustive enumeration the
+// search replaced, for the same requests and with the same scores
+func BenchmarkEnumerateDeviceSubsets(b *testing.B) {
+	f := newSearchFixture(b, mi300CPXTopo)
+	available := f.available(rand.New(rand.NewSource(1)), 5)
+	for _, size := range []int{2, 8, 16, 32, 48} {
+		b.Run(fmt.Sprintf("size-%d", size), func(b *testing.B) {
+			var score int
+			for i := 0; i < b.N; i++ {
+				score = f.enumeratedScore(available, nil, size)
+			}
+			b.ReportMetric(float64(score), "score")
+		})
+	}
+}
+
+func addDeviceToSubsetAndUpdateWeight(subset *DeviceSet, devId, devIdx int, p2pWeights map[int]map[int]int) *DeviceSet {
+	currentWeight := subset.TotalWeight
+	var from, to int
+	ids := make([]int, 0)
+	for _, d := range subset.Ids {
+		if d < devId {
+			from = d
+			to = devId
+		} else {
+			from = devId
+			to = d
+		}
+		currentWeight = currentWeight + p2pWeights[from][to]
+	}
+	ids = append(ids, subset.Ids...)
+	ids = append(ids, devId)
+
+	newSubset := NewDeviceSet(ids, subset.ParentIds, currentWeight, devIdx)
+	return newSubset
+}
+
+// enumerateDeviceSubsets is the exhaustive breadth first enumeration of the
+// candidate subsets findBestDeviceSubset replaced. It is kept as a reference
+// for the scores of the search.
+func enumerateDeviceSubsets(allDevPartitions map[string]*DevicePartitions, total, available, required []*Device, size int, p2pWeights map[int]map[int]int) ([]*DeviceSet, error) {
+	if size <= 0 {
+		return []*DeviceSet{}, fmt.Errorf("subset size should be positive integer")
+	}
+
+	if len(available) < size {
+		return []*DeviceSet{}, fmt.Errorf("subset size is more than available devices")
+	}
+
+	sort.Slice(available, func(i, j int) bool {
+		return available[i].NodeId < available[j].NodeId
+	})
+
+	// filterPartitions - partitions from same gpu are grouped into one set.
+	// the sets are sorted in ascending order of partitions available for allocation.
+	devPartitions := filterPartitions(allDevPartitions, available, required)
+	newSize := size - len(required)
+	subsetsTemp := make([]*DeviceSet, 0)
+	subsetsFinal := make([]*DeviceSet, 0)
+	// if the requested size is less than available partitions of a single gpu, try to allocate all from same gpu
+	// subsetsFinal - contains candidate set that has requested number of gpus/partitions
+	// subsetsTemp - if one gpu can not suffice requested number of partitions, we store in subsetsTemp
+	for idx, partition := range devPartitions {
+		ids := []int{partition.Ids[0]}
+		parentIds := []int{idx}
+		devset := NewDeviceSet(ids, parentIds, 0, idx)
+		if newSize == 1 {
+			for _, req := range required {
+				devset = addDeviceToSubsetAndUpdateWeight(devset, req.NodeId, idx, p2pWeights)
+			}
+			subsetsFinal = append(subsetsFinal, devset)
+			continue
+		}
+		sizeFulfilled := false
+		for i := 1; i < len(partition.Ids); i++ {
+			devset = addDeviceToSubsetAndUpdateWeight(devset, partition.Ids[i], idx, p2pWeights)
+			if i == newSize-1 {
+				sizeFulfilled = true
+				break
+			}
+		}
+		if sizeFulfilled {
+			for _, req := range required {
+				devset = addDeviceToSubsetAndUpdateWeight(devset, req.NodeId, idx, p2pWeights)
+			}
+			subsetsFinal = append(subsetsFinal, devset)
+		} else {
+			subsetsTemp = append(subsetsTemp, devset)
+		}
+	}
+	// for each subsetsTemp, we loop over all the devPartitions
+	// pick partitions from other gpu until the subsetsTemp has requested number of gpus/partitions
+	for {
+		if len(subsetsTemp) == 0 {
+			break
+		}
+		currentSubset := subsetsTemp[0]
+		subsetsTemp = subsetsTemp[1:]
+		if len(currentSubset.ParentIds) == len(devPartitions) {
+			continue
+		}
+		// devPartitions is sorted in ascending order of avilable partitions.
+		// when we loop over to pick a candidate set, preference is given to gpus with lesser partitions available.
+		// this way we can avoid fragmentation of gpus
+		for idx := 0; idx < len(devPartitions); idx++ {
+			// if current subset already has partitions from the current gpu, skip adding them again
+			if slices.Contains(currentSubset.ParentIds, idx) {
+				continue
+			}
+			var parentIds []int
+			parentIds = append(parentIds, currentSubset.ParentIds...)
+			parentIds = append(parentIds, idx)
+			devset := NewDeviceSet(currentSubset.Ids, parentIds, currentSubset.TotalWeight, currentSubset.LastIdx)
+			for _, id := range devPartitions[idx].Ids {
+				devset = addDeviceToSubsetAndUpdateWeight(devset, id, idx, p2pWeights)
+				if devset.Size == newSize {
+					for _, req := range required {
+						devset = addDeviceToSubsetAndUpdateWeight(devset, req.NodeId, idx, p2pWeights)
+					}
+					subsetsFinal = append(subsetsFinal, devset)
+					break
+				}
+			}
+			if devset.Size < newSize {
+				subsetsTemp = append(subsetsTemp, devset)
+			}
+		}
+	}
+	return subsetsFinal, nil
+}
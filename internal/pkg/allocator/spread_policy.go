@@ -0,0 +1,108 @@
+/**
+ * Copyright 2025 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package allocator
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/golang/glog"
+)
+
+/**
+*  Spread policy spreads the devices over as many GPUs, and then NUMA nodes, as possible to maximise the memory
+*  bandwidth available to the workload. Devices are picked one at a time from the GPU with the fewest devices
+*  already picked, ties being broken by the NUMA node with the fewest devices already picked.
+**/
+
+type SpreadPolicy struct {
+	devices    []*Device
+	devicesMap map[string]*Device
+}
+
+func NewSpreadPolicy() *SpreadPolicy {
+	return &SpreadPolicy{
+		devices:    make([]*Device, 0),
+		devicesMap: make(map[string]*Device),
+	}
+}
+
+// Init stores the devices, the policy does not depend on the links between
+// them
+func (s *SpreadPolicy) Init(devs []*Device, topoDir string) error {
+	if len(devs) == 0 {
+		return fmt.Errorf("Spread Policy init failed, no devices")
+	}
+	s.devices = devs
+	s.devicesMap = make(map[string]*Device)
+	for _, d := range devs {
+		s.devicesMap[d.Id] = d
+	}
+	return nil
+}
+
+func (s *SpreadPolicy) Allocate(availableIds, requiredIds []string, size int) ([]string, error) {
+	outset := []string{}
+	if err := validateRequest(s.devices, availableIds, requiredIds, size); err != nil {
+		return outset, err
+	}
+	if !setContainsAll(availableIds, requiredIds) {
+		return outset, fmt.Errorf(noCandidateFound)
+	}
+
+	perGPU := make(map[string]int)
+	perNuma := make(map[int]int)
+	picked := make(map[string]bool)
+	pick := func(d *Device) {
+		outset = append(outset, d.Id)
+		perGPU[d.DevId]++
+		perNuma[d.NumaNode]++
+		picked[d.Id] = true
+	}
+	for _, id := range requiredIds {
+		d, ok := s.devicesMap[id]
+		if !ok {
+			return []string{}, fmt.Errorf(noCandidateFound)
+		}
+		pick(d)
+	}
+	var remaining []*Device
+	for _, id := range availableIds {
+		if d, ok := s.devicesMap[id]; ok && !picked[id] {
+			remaining = append(remaining, d)
+		}
+	}
+	for len(outset) < size {
+		if len(remaining) == 0 {
+			return []string{}, fmt.Errorf(noCandidateFound)
+		}
+		sort.Slice(remaining, func(i, j int) bool {
+			a, b := remaining[i], remaining[j]
+			if perGPU[a.DevId] != perGPU[b.DevId] {
+				return perGPU[a.DevId] < perGPU[b.DevId]
+			}
+			if perNuma[a.NumaNode] != perNuma[b.NumaNode] {
+				return perNuma[a.NumaNode] < perNuma[b.NumaNode]
+			}
+			return a.NodeId < b.NodeId
+		})
+		pick(remaining[0])
+		remaining = remaining[1:]
+	}
+	glog.Infof("spread device subset:%v", outset)
+	return outset, nil
+}
@@ -100,8 +100,47 @@ func GetCardFamilyName(cardName string) (string, error) {
 	return FamilyIDtoString(uint32(info.family_id))
 }
 
+// SysfsRoot and DevfsRoot are where sysfs and the device nodes are mounted.
+// Discovery reads everything relative to them so it can be pointed at a
+// captured snapshot of a node instead of the live system.
+var (
+	SysfsRoot = "/sys"
+	DevfsRoot = "/dev"
+)
+
+// UseHwloc makes discovery consult hwloc for the NUMA nodes sysfs doesn't
+// resolve. hwloc reads the live system, it must be disabled when SysfsRoot
+// is not the sysfs of the node, ex: a captured snapshot.
+var UseHwloc = true
+
+// SysfsPath returns the path of a sysfs entry relative to SysfsRoot
+// ex: SysfsPath("class", "kfd") returns /sys/class/kfd
+func SysfsPath(elem ...string) string {
+	return filepath.Join(append([]string{SysfsRoot}, elem...)...)
+}
+
+// DevfsPath returns the path of a device node relative to DevfsRoot
+func DevfsPath(elem ...string) string {
+	return filepath.Join(append([]string{DevfsRoot}, elem...)...)
+}
+
+// pciDevicePaths returns the sysfs directories of the PCI devices bound to
+// the amdgpu driver
+func pciDevicePaths() []string {
+	//ex: /sys/module/amdgpu/drivers/pci:amdgpu/0000:19:00.0
+	matches, _ := filepath.Glob(SysfsPath("module/amdgpu/drivers/pci:amdgpu") + "/[0-9a-fA-F][0-9a-fA-F][0-9a-fA-F][0-9a-fA-F]:*")
+	return matches
+}
+
+// PciDevicePath returns the sysfs directory of a GPU bound to the amdgpu
+// driver from its PCI address. It disappears if the GPU is unbound or falls
+// off the bus.
+func PciDevicePath(pciAddress string) string {
+	return SysfsPath("module/amdgpu/drivers/pci:amdgpu", pciAddress)
+}
+
 func GetDevIdsFromTopology(topoRootParam ...string) map[int]string {
-	topoRoot := "/sys/class/kfd/kfd"
+	topoRoot := SysfsPath("class/kfd/kfd")
 	if len(topoRootParam) == 1 {
 		topoRoot = topoRootParam[0]
 	}
@@ -152,141 +191,259 @@ func GetDevIdsFromTopology(topoRootParam ...string) map[int]string {
 // test process isn't killed on machines without AMD GPUs.
 var FatalOnDriverUnavailable = true
 
+// GPU describes an AMD GPU, or a compute partition (XCP) of one, as
+// discovered through sysfs and the KFD topology
+type GPU struct {
+	// Id identifies the device to the kubelet: the PCI address for a GPU
+	// (ex: 0000:19:00.0) or the platform device name for a partition
+	// (ex: amdgpu_xcp_30)
+	Id string
+	// PciAddress of the GPU, or of the parent GPU for a partition
+	PciAddress string
+	Card       int
+	RenderD    int
+	// NodeId is the KFD topology node backing this GPU/partition
+	NodeId int
+	// NumaNode is the NUMA node of the GPU, -1 if unknown
+	NumaNode int
+	// NumaNodes are the NUMA nodes the GPU is local to: NumaNode and the
+	// other NUMA nodes of its package with sub-NUMA clustering, or all the
+	// NUMA nodes it's equally close to if the firmware doesn't report its
+	// NUMA node
+	NumaNodes []int
+	// DevId is the PCI address derived from the KFD topology, shared by a
+	// GPU and all of its partitions
+	DevId                string
+	ComputePartitionType string
+	MemoryPartitionType  string
+	// MemoryDomain is the memory partition of the GPU the memory of a GPU
+	// or partition is in, numbered from 1, ex: 1 to 4 in NPS4, 0 if unknown
+	MemoryDomain int
+	// ParentId is the Id of the GPU a partition belongs to. It is empty for
+	// the GPU itself
+	ParentId string
+	UniqueId string
+	// DeviceId is the PCI device id without the 0x prefix (ex: 740f)
+	DeviceId  string
+	VramBytes int64
+	// PartitionIndex is the index of a partition among the KFD nodes of its
+	// GPU in node order, 0 for the GPU itself
+	PartitionIndex int
+}
+
+// IsPartition returns true if the device is a compute partition of a GPU
+func (g *GPU) IsPartition() bool {
+	return g.ParentId != ""
+}
+
+// PartitionType returns the combined compute and memory partition type
+// (ex: cpx_nps1), or an empty string if partitioning is not reported
+func (g *GPU) PartitionType() string {
+	if g.ComputePartitionType == "" || g.MemoryPartitionType == "" {
+		return ""
+	}
+	return g.ComputePartitionType + "_" + g.MemoryPartitionType
+}
+
+// ToMap returns the legacy untyped representation of the device as
+// returned by GetAMDGPUs
+func (g *GPU) ToMap() map[string]interface{} {
+	return map[string]interface{}{
+		"card":                 g.Card,
+		"renderD":              g.RenderD,
+		"devID":                g.DevId,
+		"computePartitionType": g.ComputePartitionType,
+		"memoryPartitionType":  g.MemoryPartitionType,
+		"numaNode":             g.NumaNode,
+		"nodeId":               g.NodeId,
+	}
+}
+
 // GetAMDGPUs return a map of AMD GPU on a node identified by the part of the pci address
+//
+// Deprecated: use GetGPUs, which returns typed device entries. This is kept
+// only for compatibility with existing callers.
 func GetAMDGPUs() map[string]map[string]interface{} {
-	if _, err := os.Stat("/sys/module/amdgpu/drivers/"); err != nil {
+	devices := make(map[string]map[string]interface{})
+	for id, gpu := range GetGPUs() {
+		devices[id] = gpu.ToMap()
+	}
+	return devices
+}
+
+// readSysfsString returns the trimmed, lower case content of a sysfs file
+func readSysfsString(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.ToLower(strings.TrimSpace(string(data))), nil
+}
+
+// drmMinors returns the card and renderD minor numbers of the drm nodes
+// listed under a device's sysfs directory
+func drmMinors(path string) (card, renderD int) {
+	devPaths, _ := filepath.Glob(path + "/drm/*")
+	for _, devPath := range devPaths {
+		switch name := filepath.Base(devPath); {
+		case strings.HasPrefix(name, "card"):
+			card, _ = strconv.Atoi(name[4:])
+		case strings.HasPrefix(name, "renderD"):
+			renderD, _ = strconv.Atoi(name[7:])
+		}
+	}
+	return card, renderD
+}
+
+var topoSizeInBytesRe = regexp.MustCompile(`size_in_bytes\s(\d+)`)
+
+// getVramFromTopology returns the size of the first memory bank of a KFD node
+func getVramFromTopology(nodeId int) int64 {
+	path := SysfsPath("class/kfd/kfd/topology/nodes", strconv.Itoa(nodeId), "mem_banks/0/properties")
+	v, err := ParseTopologyProperties(path, topoSizeInBytesRe)
+	if err != nil {
+		glog.Warningf("Failed to read vram size from %s: %s", path, err)
+		return 0
+	}
+	return v
+}
+
+// GetGPUs return a map of AMD GPU and their partitions on a node, identified
+// by the pci address for GPUs and by the platform device name for partitions
+func GetGPUs() map[string]*GPU {
+	if _, err := os.Stat(SysfsPath("module/amdgpu/drivers")); err != nil {
 		if FatalOnDriverUnavailable {
 			glog.Fatalf("amdgpu driver unavailable. exiting with exit code 2. error: %s", err)
 		}
 		glog.Warningf("amdgpu driver unavailable: %s", err)
-		return map[string]map[string]interface{}{}
+		return map[string]*GPU{}
 	}
 
-	//ex: /sys/module/amdgpu/drivers/pci:amdgpu/0000:19:00.0
-	matches, _ := filepath.Glob("/sys/module/amdgpu/drivers/pci:amdgpu/[0-9a-fA-F][0-9a-fA-F][0-9a-fA-F][0-9a-fA-F]:*")
+	matches := pciDevicePaths()
 
-	devID := ""
-	devices := make(map[string]map[string]interface{})
-	card, renderD, nodeId := 0, 128, 0
+	devices := make(map[string]*GPU)
 	renderDevIds := GetDevIdsFromTopology()
 	renderNodeIds := GetNodeIdsFromTopology()
+	numa := newNumaResolver()
+	defer numa.close()
 
 	for _, path := range matches {
 		computePartitionFile := filepath.Join(path, "current_compute_partition")
 		memoryPartitionFile := filepath.Join(path, "current_memory_partition")
-		numaNodeFile := filepath.Join(path, "numa_node")
 
-		computePartitionType, memoryPartitionType := "", ""
-		numaNode := -1
+		gpu := &GPU{
+			Id:         filepath.Base(path),
+			PciAddress: filepath.Base(path),
+			NumaNode:   -1,
+		}
 
 		// Read the compute partition
-		if data, err := ioutil.ReadFile(computePartitionFile); err == nil {
-			computePartitionType = strings.ToLower(strings.TrimSpace(string(data)))
+		if v, err := readSysfsString(computePartitionFile); err == nil {
+			gpu.ComputePartitionType = v
 		} else {
 			glog.Warningf("Failed to read 'current_compute_partition' file at %s: %s", computePartitionFile, err)
 		}
 
 		// Read the memory partition
-		if data, err := ioutil.ReadFile(memoryPartitionFile); err == nil {
-			memoryPartitionType = strings.ToLower(strings.TrimSpace(string(data)))
+		if v, err := readSysfsString(memoryPartitionFile); err == nil {
+			gpu.MemoryPartitionType = v
 		} else {
 			glog.Warningf("Failed to read 'current_memory_partition' file at %s: %s", memoryPartitionFile, err)
 		}
 
-		if data, err := ioutil.ReadFile(numaNodeFile); err == nil {
-			numaNodeStr := strings.TrimSpace(string(data))
-			numaNode, err = strconv.Atoi(numaNodeStr)
-			if err != nil {
-				glog.Warningf("Failed to convert 'numa_node' value to int: %s", err)
-				continue
-			}
-		} else {
-			glog.Warningf("Failed to read 'numa_node' file at %s: %s", numaNodeFile, err)
-			continue
+		if v, err := readSysfsString(filepath.Join(path, "unique_id")); err == nil {
+			gpu.UniqueId = v
+		}
+		if v, err := readSysfsString(filepath.Join(path, "device")); err == nil {
+			gpu.DeviceId = strings.TrimPrefix(v, "0x")
 		}
 
 		glog.Info(path)
-		devPaths, _ := filepath.Glob(path + "/drm/*")
-
-		for _, devPath := range devPaths {
-			switch name := filepath.Base(devPath); {
-			case name[0:4] == "card":
-				card, _ = strconv.Atoi(name[4:])
-			case name[0:7] == "renderD":
-				renderD, _ = strconv.Atoi(name[7:])
-				if val, exists := renderDevIds[renderD]; exists {
-					devID = val
-				}
-				if id, exists := renderNodeIds[renderD]; exists {
-					nodeId = id
-				}
-			}
-
-		}
+		gpu.Card, gpu.RenderD = drmMinors(path)
 		// add devID so that we can identify later which gpu should get reported under which resource type
-		devices[filepath.Base(path)] = map[string]interface{}{"card": card, "renderD": renderD, "devID": devID, "computePartitionType": computePartitionType, "memoryPartitionType": memoryPartitionType, "numaNode": numaNode, "nodeId": nodeId}
+		if val, exists := renderDevIds[gpu.RenderD]; exists {
+			gpu.DevId = val
+		}
+		if id, exists := renderNodeIds[gpu.RenderD]; exists {
+			gpu.NodeId = id
+			gpu.VramBytes = getVramFromTopology(id)
+			gpu.PartitionIndex = partitionIndex(gpu.RenderD, renderDevIds, renderNodeIds)
+		}
+		numa.resolve(gpu, path)
+		devices[gpu.Id] = gpu
 	}
 
 	// certain products have additional devices (such as MI300's partitions)
 	//ex: /sys/devices/platform/amdgpu_xcp_30
-	platformMatches, _ := filepath.Glob("/sys/devices/platform/amdgpu_xcp_*")
+	platformMatches, _ := filepath.Glob(SysfsPath("devices/platform") + "/amdgpu_xcp_*")
 
 	for _, path := range platformMatches {
 		glog.Info(path)
-		devPaths, _ := filepath.Glob(path + "/drm/*")
-
-		computePartitionType, memoryPartitionType := "", ""
-		numaNode := -1
-
-		for _, devPath := range devPaths {
-			switch name := filepath.Base(devPath); {
-			case name[0:4] == "card":
-				card, _ = strconv.Atoi(name[4:])
-			case name[0:7] == "renderD":
-				renderD, _ = strconv.Atoi(name[7:])
-				if val, exists := renderDevIds[renderD]; exists {
-					devID = val
-				}
-				// Set the computePartitionType and memoryPartitionType from the real GPU or from other partitions using the common devID
-				for _, device := range devices {
-					if device["devID"] == devID {
-						if device["computePartitionType"].(string) != "" && device["memoryPartitionType"].(string) != "" {
-							computePartitionType = device["computePartitionType"].(string)
-							memoryPartitionType = device["memoryPartitionType"].(string)
-							numaNode = device["numaNode"].(int)
-							break
-						}
-					}
-				}
-				if id, exists := renderNodeIds[renderD]; exists {
-					nodeId = id
-				}
-			}
+		partition := &GPU{
+			Id:       filepath.Base(path),
+			NumaNode: -1,
 		}
+		partition.Card, partition.RenderD = drmMinors(path)
+
 		// This is needed because some of the visible renderD are actually not valid
 		// Their validity depends on topology information from KFD
-
-		if _, exists := renderDevIds[renderD]; !exists {
+		devID, exists := renderDevIds[partition.RenderD]
+		if !exists {
 			continue
 		}
-		if numaNode == -1 {
+		partition.DevId = devID
+
+		// Set the partition types from the real GPU using the common devID
+		var parent *GPU
+		for _, gpu := range devices {
+			if gpu.IsPartition() || gpu.DevId != devID {
+				continue
+			}
+			if gpu.ComputePartitionType != "" && gpu.MemoryPartitionType != "" {
+				parent = gpu
+				partition.ParentId = gpu.Id
+				partition.PciAddress = gpu.PciAddress
+				partition.ComputePartitionType = gpu.ComputePartitionType
+				partition.MemoryPartitionType = gpu.MemoryPartitionType
+				partition.UniqueId = gpu.UniqueId
+				partition.DeviceId = gpu.DeviceId
+				break
+			}
+		}
+		if parent == nil {
 			continue
 		}
-		devices[filepath.Base(path)] = map[string]interface{}{"card": card, "renderD": renderD, "devID": devID, "computePartitionType": computePartitionType, "memoryPartitionType": memoryPartitionType, "numaNode": numaNode, "nodeId": nodeId}
+		if id, exists := renderNodeIds[partition.RenderD]; exists {
+			partition.NodeId = id
+			partition.VramBytes = getVramFromTopology(id)
+			partition.PartitionIndex = partitionIndex(partition.RenderD, renderDevIds, renderNodeIds)
+		}
+		numa.resolvePartition(partition, parent)
+		devices[partition.Id] = partition
 	}
+	setMemoryDomains(devices)
 	glog.Infof("Devices map: %v", devices)
 	return devices
 }
 
-func UniquePartitionConfigCount(devices map[string]map[string]interface{}) map[string]int {
+// partitionIndex returns the index of the KFD node of a render minor among
+// the nodes of the same GPU, in node order. It only depends on the KFD
+// topology, not on the devices advertised.
+func partitionIndex(renderD int, renderDevIds map[int]string, renderNodeIds map[int]int) int {
+	index := 0
+	for minor, devId := range renderDevIds {
+		if devId == renderDevIds[renderD] && renderNodeIds[minor] < renderNodeIds[renderD] {
+			index++
+		}
+	}
+	return index
+}
+
+func UniquePartitionConfigCount(devices map[string]*GPU) map[string]int {
 	partitionCountMap := make(map[string]int)
 
 	for _, device := range devices {
-		computePartitionType := device["computePartitionType"].(string)
-		memoryPartitionType := device["memoryPartitionType"].(string)
-
-		if computePartitionType != "" && memoryPartitionType != "" {
-			overallPartition := computePartitionType + "_" + memoryPartitionType
+		if overallPartition := device.PartitionType(); overallPartition != "" {
 			partitionCountMap[overallPartition]++
 		}
 	}
@@ -296,7 +453,7 @@ func UniquePartitionConfigCount(devices map[string]map[string]interface{}) map[s
 }
 
 func IsHomogeneous() bool {
-	gpus := GetAMDGPUs()
+	gpus := GetGPUs()
 	partitionCountMap := UniquePartitionConfigCount(gpus)
 
 	// Homogeneous if the map is empty or contains exactly one partition type
@@ -304,8 +461,8 @@ func IsHomogeneous() bool {
 }
 
 func IsComputePartitionSupported() bool {
-	// Finding GPU paths using the same way its done in other functions like GetAMDGPUs()
-	matches, _ := filepath.Glob("/sys/module/amdgpu/drivers/pci:amdgpu/[0-9a-fA-F][0-9a-fA-F][0-9a-fA-F][0-9a-fA-F]:*")
+	// Finding GPU paths using the same way its done in other functions like GetGPUs()
+	matches := pciDevicePaths()
 	if len(matches) == 0 {
 		return false
 	}
@@ -322,8 +479,8 @@ func IsComputePartitionSupported() bool {
 }
 
 func IsMemoryPartitionSupported() bool {
-	// Finding GPU paths using the same way its done in other functions like GetAMDGPUs()
-	matches, _ := filepath.Glob("/sys/module/amdgpu/drivers/pci:amdgpu/[0-9a-fA-F][0-9a-fA-F][0-9a-fA-F][0-9a-fA-F]:*")
+	// Finding GPU paths using the same way its done in other functions like GetGPUs()
+	matches := pciDevicePaths()
 	if len(matches) == 0 {
 		return false
 	}
@@ -340,7 +497,7 @@ func IsMemoryPartitionSupported() bool {
 
 // AMDGPU check if a particular card is an AMD GPU by checking the device's vendor ID
 func AMDGPU(cardName string) bool {
-	sysfsVendorPath := "/sys/class/drm/" + cardName + "/device/vendor"
+	sysfsVendorPath := SysfsPath("class/drm", cardName, "device/vendor")
 	b, err := ioutil.ReadFile(sysfsVendorPath)
 	if err == nil {
 		vid := strings.TrimSpace(string(b))
@@ -359,7 +516,7 @@ func openAMDGPU(cardName string) (C.amdgpu_device_handle, error) {
 	if !AMDGPU(cardName) {
 		return nil, fmt.Errorf("%s is not an AMD GPU", cardName)
 	}
-	devPath := "/dev/dri/" + cardName
+	devPath := DevfsPath("dri", cardName)
 
 	dev, err := os.Open(devPath)
 
@@ -505,7 +662,7 @@ var topoLocationIdRe = regexp.MustCompile(`location_id\s(\d+)`)
 var topoDomainRe = regexp.MustCompile(`domain\s(\d+)`)
 
 func GetNodeIdsFromTopology(topoRootParam ...string) map[int]int {
-	topoRoot := "/sys/class/kfd/kfd"
+	topoRoot := SysfsPath("class/kfd/kfd")
 	if len(topoRootParam) == 1 {
 		topoRoot = topoRootParam[0]
 	}
@@ -17,6 +17,7 @@
 package amdgpu
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -24,17 +25,22 @@ import (
 	"path/filepath"
 	"reflect"
 	"regexp"
+	"sort"
 	"strings"
 	"testing"
+
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/snapshot"
 )
 
 func TestMain(m *testing.M) {
 	FatalOnDriverUnavailable = false
+	// the tests discover GPUs in fake sysfs trees hwloc doesn't see
+	UseHwloc = false
 	os.Exit(m.Run())
 }
 
 func hasAMDGPU(t *testing.T) bool {
-	devices := GetAMDGPUs()
+	devices := GetGPUs()
 
 	if len(devices) <= 0 {
 		return false
@@ -47,10 +53,10 @@ func TestFirmwareVersionConsistent(t *testing.T) {
 		t.Skip("Skipping test, no AMD GPU found.")
 	}
 
-	devices := GetAMDGPUs()
+	devices := GetGPUs()
 
 	for pci, dev := range devices {
-		card := fmt.Sprintf("card%d", dev["card"])
+		card := fmt.Sprintf("card%d", dev.Card)
 		t.Logf("%s, %s", pci, card)
 
 		//debugfs path/interface may not be stable
@@ -79,7 +85,7 @@ func TestAMDGPUcountConsistent(t *testing.T) {
 		t.Skip("Skipping test, no AMD GPU found.")
 	}
 
-	devices := GetAMDGPUs()
+	devices := GetGPUs()
 
 	matches, _ := filepath.Glob("/sys/class/drm/card[0-9]*/device/vendor")
 
@@ -115,10 +121,10 @@ func TestDevFunctional(t *testing.T) {
 		t.Skip("Skipping test, no AMD GPU found.")
 	}
 
-	devices := GetAMDGPUs()
+	devices := GetGPUs()
 
 	for _, dev := range devices {
-		card := fmt.Sprintf("card%d", dev["card"])
+		card := fmt.Sprintf("card%d", dev.Card)
 
 		ret := DevFunctional(card)
 		t.Logf("%s functional: %t", card, ret)
@@ -268,3 +274,431 @@ func TestRenderDevIdsFromTopology(t *testing.T) {
 		t.Errorf("Want: %s", exp)
 	}
 }
+
+func TestUniquePartitionConfigCount(t *testing.T) {
+	devices := map[string]*GPU{
+		"0000:0a:00.0":  {Id: "0000:0a:00.0", ComputePartitionType: "cpx", MemoryPartitionType: "nps1"},
+		"amdgpu_xcp_1":  {Id: "amdgpu_xcp_1", ParentId: "0000:0a:00.0", ComputePartitionType: "cpx", MemoryPartitionType: "nps1"},
+		"0000:80:00.0":  {Id: "0000:80:00.0", ComputePartitionType: "spx", MemoryPartitionType: "nps1"},
+		"0000:a4:00.0":  {Id: "0000:a4:00.0"},
+		"amdgpu_xcp_20": {Id: "amdgpu_xcp_20", ParentId: "0000:a4:00.0", ComputePartitionType: "cpx"},
+	}
+
+	expCounts := map[string]int{
+		"cpx_nps1": 2,
+		"spx_nps1": 1,
+	}
+	counts := UniquePartitionConfigCount(devices)
+	if !reflect.DeepEqual(counts, expCounts) {
+		t.Errorf("Partition counts were incorrect, got: %v, want: %v", counts, expCounts)
+	}
+}
+
+func TestGPUToMap(t *testing.T) {
+	gpu := &GPU{
+		Id:                   "amdgpu_xcp_1",
+		ParentId:             "0000:0a:00.0",
+		Card:                 2,
+		RenderD:              129,
+		NodeId:               3,
+		NumaNode:             0,
+		DevId:                "0000:0a:00:0",
+		ComputePartitionType: "cpx",
+		MemoryPartitionType:  "nps1",
+	}
+
+	expMap := map[string]interface{}{
+		"card":                 2,
+		"renderD":              129,
+		"devID":                "0000:0a:00:0",
+		"computePartitionType": "cpx",
+		"memoryPartitionType":  "nps1",
+		"numaNode":             0,
+		"nodeId":               3,
+	}
+	if m := gpu.ToMap(); !reflect.DeepEqual(m, expMap) {
+		t.Errorf("Legacy device map was incorrect, got: %v, want: %v", m, expMap)
+	}
+	if !gpu.IsPartition() {
+		t.Errorf("Expected %s to be reported as a partition", gpu.Id)
+	}
+	if gpu.PartitionType() != "cpx_nps1" {
+		t.Errorf("Partition type was incorrect, got: %s, want: cpx_nps1", gpu.PartitionType())
+	}
+}
+
+// newFakeSysfsRoot builds a minimal sysfs tree around a captured KFD topology
+// snapshot. Every GPU found in the topology is bound to the amdgpu driver and
+// all but its first render node are exposed as amdgpu_xcp_* partitions.
+func newFakeSysfsRoot(t *testing.T, topoDir, computePartition, memoryPartition string) string {
+	root := t.TempDir()
+	topoAbs, err := filepath.Abs(topoDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "class/kfd/kfd"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(topoAbs, "topology"), filepath.Join(root, "class/kfd/kfd/topology")); err != nil {
+		t.Fatal(err)
+	}
+
+	renderDevIds := GetDevIdsFromTopology(topoAbs)
+	renders := make([]int, 0, len(renderDevIds))
+	for renderD := range renderDevIds {
+		renders = append(renders, renderD)
+	}
+	sort.Ints(renders)
+
+	writeFile := func(path, content string) {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	seen := map[string]bool{}
+	for i, renderD := range renders {
+		devID := renderDevIds[renderD]
+		if !seen[devID] {
+			seen[devID] = true
+			pciAddr := devID[:len(devID)-2] + ".0"
+			path := filepath.Join(root, "module/amdgpu/drivers/pci:amdgpu", pciAddr)
+			writeFile(filepath.Join(path, "current_compute_partition"), strings.ToUpper(computePartition))
+			writeFile(filepath.Join(path, "current_memory_partition"), strings.ToUpper(memoryPartition))
+			writeFile(filepath.Join(path, "numa_node"), "0")
+			writeFile(filepath.Join(path, "device"), "0x74a1")
+			writeFile(filepath.Join(path, "drm", fmt.Sprintf("card%d", i), "dev"), "")
+			writeFile(filepath.Join(path, "drm", fmt.Sprintf("renderD%d", renderD), "dev"), "")
+			continue
+		}
+		path := filepath.Join(root, "devices/platform", fmt.Sprintf("amdgpu_xcp_%d", i))
+		writeFile(filepath.Join(path, "drm", fmt.Sprintf("card%d", i), "dev"), "")
+		writeFile(filepath.Join(path, "drm", fmt.Sprintf("renderD%d", renderD), "dev"), "")
+	}
+	return root
+}
+
+func TestGetGPUsFromSysfsRoot(t *testing.T) {
+	defer func(root string) { SysfsRoot = root }(SysfsRoot)
+	SysfsRoot = newFakeSysfsRoot(t, "../../../testdata/topology-parsing-mi308", "cpx", "nps1")
+
+	devices := GetGPUs()
+	if len(devices) != 32 {
+		t.Fatalf("Device count was incorrect, got: %d, want: %d.", len(devices), 32)
+	}
+
+	gpus, partitions := 0, 0
+	for id, dev := range devices {
+		if dev.IsPartition() {
+			partitions++
+			parent, ok := devices[dev.ParentId]
+			if !ok {
+				t.Errorf("Parent %s of %s not found", dev.ParentId, id)
+				continue
+			}
+			if parent.DevId != dev.DevId || parent.PciAddress != dev.PciAddress {
+				t.Errorf("Partition %s does not match its parent %s", id, parent.Id)
+			}
+		} else {
+			gpus++
+			if dev.DeviceId != "74a1" {
+				t.Errorf("Device id of %s was incorrect, got: %s, want: 74a1", id, dev.DeviceId)
+			}
+		}
+		if dev.NodeId == 0 {
+			t.Errorf("KFD node of %s was not resolved", id)
+		}
+	}
+	if gpus != 8 || partitions != 24 {
+		t.Errorf("GPU/partition counts were incorrect, got: %d/%d, want: 8/24", gpus, partitions)
+	}
+
+	// the partitions of a GPU are indexed in KFD node order
+	byDevId := make(map[string][]*GPU)
+	for _, dev := range devices {
+		byDevId[dev.DevId] = append(byDevId[dev.DevId], dev)
+	}
+	for devId, gpu := range byDevId {
+		sort.Slice(gpu, func(i, j int) bool {
+			return gpu[i].NodeId < gpu[j].NodeId
+		})
+		for i, dev := range gpu {
+			if dev.PartitionIndex != i || (i == 0 && dev.IsPartition()) {
+				t.Errorf("Partition index of %s of %s was incorrect, got: %d, want: %d", dev.Id, devId, dev.PartitionIndex, i)
+			}
+		}
+	}
+
+	expCounts := map[string]int{"cpx_nps1": 32}
+	if counts := UniquePartitionConfigCount(devices); !reflect.DeepEqual(counts, expCounts) {
+		t.Errorf("Partition counts were incorrect, got: %v, want: %v", counts, expCounts)
+	}
+	if !IsHomogeneous() {
+		t.Errorf("Expected node to be homogeneous")
+	}
+}
+
+func TestGetGPUsMemoryDomains(t *testing.T) {
+	defer func(root string) { SysfsRoot = root }(SysfsRoot)
+	for _, tc := range []struct {
+		memoryPartition string
+		expDomains      []int
+	}{
+		{"nps1", []int{1, 1, 1, 1}},
+		{"nps2", []int{1, 1, 2, 2}},
+		{"nps4", []int{1, 2, 3, 4}},
+		{"nps3", []int{0, 0, 0, 0}},
+	} {
+		SysfsRoot = newFakeSysfsRoot(t, "../../../testdata/topology-parsing-mi308", "cpx", tc.memoryPartition)
+		gpus := make(map[string][]*GPU)
+		for _, dev := range GetGPUs() {
+			gpus[dev.DevId] = append(gpus[dev.DevId], dev)
+		}
+		for devId, partitions := range gpus {
+			sort.Slice(partitions, func(i, j int) bool {
+				return partitions[i].NodeId < partitions[j].NodeId
+			})
+			domains := make([]int, 0, len(partitions))
+			for _, partition := range partitions {
+				domains = append(domains, partition.MemoryDomain)
+			}
+			if !reflect.DeepEqual(domains, tc.expDomains) {
+				t.Errorf("%s: memory partitions of %s were incorrect, got: %v, want: %v", tc.memoryPartition, devId, domains, tc.expDomains)
+			}
+		}
+	}
+}
+
+func TestGetGPUsFromSnapshot(t *testing.T) {
+	defer func(root string) { SysfsRoot = root }(SysfsRoot)
+	SysfsRoot = newFakeSysfsRoot(t, "../../../testdata/topology-parsing-mi308", "cpx", "nps1")
+	expected := GetGPUs()
+
+	var buf bytes.Buffer
+	if err := snapshot.Capture(&buf, SysfsRoot); err != nil {
+		t.Fatalf("Capture failed: %v", err)
+	}
+	SysfsRoot = t.TempDir()
+	if err := snapshot.Extract(&buf, SysfsRoot); err != nil {
+		t.Fatalf("Extract failed: %v", err)
+	}
+	if devices := GetGPUs(); !reflect.DeepEqual(devices, expected) {
+		t.Errorf("Devices discovered from the snapshot were incorrect, got: %v, want: %v", devices, expected)
+	}
+}
+
+func TestParseCPUList(t *testing.T) {
+	testcases := []struct {
+		list    string
+		expCPUs []int
+		expErr  bool
+	}{
+		{"0-3", []int{0, 1, 2, 3}, false},
+		{"0-1,8,10-11\n", []int{0, 1, 8, 10, 11}, false},
+		{"", nil, false},
+		{"3-1", nil, true},
+		{"a-b", nil, true},
+	}
+	for _, tc := range testcases {
+		cpus, err := parseCPUList(tc.list)
+		if (err != nil) != tc.expErr || !reflect.DeepEqual(cpus, tc.expCPUs) {
+			t.Errorf("CPU list %q was parsed incorrectly, got: %v, %v, want: %v", tc.list, cpus, err, tc.expCPUs)
+		}
+	}
+}
+
+func TestGetGPUsNumaFallback(t *testing.T) {
+	defer func(root string) { SysfsRoot = root }(SysfsRoot)
+	root := newFakeSysfsRoot(t, "../../../testdata/topology-parsing-mi308", "cpx", "nps1")
+	SysfsRoot = root
+
+	writeFile := func(path, content string) {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(SysfsPath("devices/system/node/node0/cpulist"), "0-3")
+	writeFile(SysfsPath("devices/system/node/node1/cpulist"), "4-7")
+
+	pciPaths := pciDevicePaths()
+	sort.Strings(pciPaths)
+	equidistant, bridged, unknown := pciPaths[0], pciPaths[1], pciPaths[2]
+	// the firmware reports no NUMA node but the GPU is local to the cpus of
+	// both NUMA nodes
+	writeFile(filepath.Join(equidistant, "numa_node"), "-1")
+	writeFile(filepath.Join(equidistant, "local_cpulist"), "0-7")
+	// without numa_node, the cpus local to the bridge the GPU is behind are
+	// used
+	bridge := filepath.Join(root, "devices/pci0000:40/0000:40:01.1")
+	if err := os.MkdirAll(bridge, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(bridged, filepath.Join(bridge, filepath.Base(bridged))); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(bridge, filepath.Base(bridged)), bridged); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filepath.Join(bridged, "numa_node"))
+	writeFile(filepath.Join(bridge, "local_cpulist"), "4-7")
+	// nothing to resolve the NUMA node from
+	writeFile(filepath.Join(unknown, "numa_node"), "-1")
+
+	devices := GetGPUs()
+	if len(devices) != 32 {
+		t.Fatalf("Device count was incorrect, got: %d, want: %d.", len(devices), 32)
+	}
+	expected := map[string][]int{
+		filepath.Base(equidistant): {0, 1},
+		filepath.Base(bridged):     {1},
+		filepath.Base(unknown):     nil,
+	}
+	for _, dev := range devices {
+		gpu := dev.Id
+		if dev.IsPartition() {
+			gpu = dev.ParentId
+		}
+		expNodes, ok := expected[gpu]
+		if !ok {
+			expNodes = []int{0}
+		}
+		expNode := -1
+		if len(expNodes) > 0 {
+			expNode = expNodes[0]
+		}
+		if !reflect.DeepEqual(dev.NumaNodes, expNodes) || dev.NumaNode != expNode {
+			t.Errorf("NUMA nodes of %s were incorrect, got: %d %v, want: %d %v", dev.Id, dev.NumaNode, dev.NumaNodes, expNode, expNodes)
+		}
+	}
+}
+
+// newSNCTopology writes the KFD topology of a node with two packages of two
+// sub NUMA clusters each, CPU nodes 0 to 3, and two GPUs: node 4 linked to
+// CPU node 1 and node 5 equally linked to CPU nodes 2 and 3. The NUMA nodes
+// of the CPU nodes are 0, 1, 4 and 5, NUMA node 2 only has memory.
+func newSNCTopology(t *testing.T, root string) {
+	nodes := filepath.Join(root, "class/kfd/kfd/topology/nodes")
+	writeFile := func(path, content string) {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[int][][2]int{
+		0: {{1, 12}, {2, 32}, {3, 32}},
+		1: {{0, 12}, {2, 32}, {3, 32}},
+		2: {{0, 32}, {1, 32}, {3, 12}},
+		3: {{0, 32}, {1, 32}, {2, 12}},
+		4: {{1, 20}, {5, 15}},
+		5: {{2, 20}, {3, 20}, {4, 15}},
+	}
+	for node, cpus := range map[int]string{0: "0-15", 1: "16-31", 2: "", 4: "32-47", 5: "48-63"} {
+		writeFile(filepath.Join(root, "devices/system/node", fmt.Sprintf("node%d", node), "cpulist"), cpus+"\n")
+	}
+	for node, nodeLinks := range links {
+		cores := 16
+		if node >= 4 {
+			cores = 0
+		}
+		writeFile(filepath.Join(nodes, fmt.Sprint(node), "properties"), fmt.Sprintf("cpu_cores_count %d\n", cores))
+		for i, link := range nodeLinks {
+			writeFile(filepath.Join(nodes, fmt.Sprint(node), "io_links", fmt.Sprint(i), "properties"),
+				fmt.Sprintf("type 2\nnode_from %d\nnode_to %d\nweight %d\n", node, link[0], link[1]))
+		}
+	}
+}
+
+func TestKFDNumaNodes(t *testing.T) {
+	defer func(root string) { SysfsRoot = root }(SysfsRoot)
+	SysfsRoot = t.TempDir()
+	newSNCTopology(t, SysfsRoot)
+
+	r := newNumaResolver()
+	defer r.close()
+	for nodeId, expNodes := range map[int][]int{4: {0, 1}, 5: {4, 5}, 6: nil} {
+		if nodes := r.kfdNumaNodes(nodeId); !reflect.DeepEqual(nodes, expNodes) {
+			t.Errorf("NUMA nodes of KFD node %d were incorrect, got: %v, want: %v", nodeId, nodes, expNodes)
+		}
+	}
+
+	// the CPU nodes can't be matched with the NUMA nodes when their cpus
+	// don't agree
+	if err := ioutil.WriteFile(filepath.Join(SysfsRoot, "devices/system/node/node5/cpulist"), []byte("48-55\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if nodes := newNumaResolver().kfdNumaNodes(4); nodes != nil {
+		t.Errorf("NUMA nodes of KFD node 4 were incorrect, got: %v, want: none", nodes)
+	}
+
+	path := filepath.Join(SysfsRoot, "module/amdgpu/drivers/pci:amdgpu/0000:05:00.0")
+	if err := os.MkdirAll(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	testcases := []struct {
+		numaNode    string
+		nodeId      int
+		expNumaNode int
+		expNodes    []int
+		expHwloc    bool
+	}{
+		// the other sub NUMA cluster of the package is local too
+		{"1", 4, 1, []int{0, 1}, false},
+		{"5", 5, 5, []int{4, 5}, false},
+		// the KFD topology doesn't agree with numa_node, it's ignored and
+		// hwloc is consulted instead, which doesn't know the GPU
+		{"5", 4, 5, []int{5}, true},
+	}
+	defer func(useHwloc bool) { UseHwloc = useHwloc }(UseHwloc)
+	UseHwloc = true
+	for _, tc := range testcases {
+		if err := ioutil.WriteFile(filepath.Join(path, "numa_node"), []byte(tc.numaNode+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		r.close()
+		r.hwlocLoaded = false
+		gpu := &GPU{Id: "0000:05:00.0", PciAddress: "0000:05:00.0", NodeId: tc.nodeId, NumaNode: -1}
+		r.resolve(gpu, path)
+		if gpu.NumaNode != tc.expNumaNode || !reflect.DeepEqual(gpu.NumaNodes, tc.expNodes) {
+			t.Errorf("NUMA nodes of KFD node %d were incorrect, got: %d %v, want: %d %v", tc.nodeId, gpu.NumaNode, gpu.NumaNodes, tc.expNumaNode, tc.expNodes)
+		}
+		if r.hwlocLoaded != tc.expHwloc {
+			t.Errorf("hwloc consulted for KFD node %d was incorrect, got: %v, want: %v", tc.nodeId, r.hwlocLoaded, tc.expHwloc)
+		}
+	}
+}
+
+func TestResolvePartition(t *testing.T) {
+	defer func(root string) { SysfsRoot = root }(SysfsRoot)
+	SysfsRoot = t.TempDir()
+	newSNCTopology(t, SysfsRoot)
+
+	r := newNumaResolver()
+	defer r.close()
+	gpu := &GPU{Id: "0000:05:00.0", NodeId: 4, NumaNode: 1, NumaNodes: []int{0, 1}}
+	testcases := []struct {
+		nodeId      int
+		expNumaNode int
+		expNodes    []int
+	}{
+		// linked to the CPU nodes of the GPU, or not linked
+		{4, 1, []int{0, 1}},
+		{6, 1, []int{0, 1}},
+		// linked to other CPU nodes than the GPU
+		{5, 4, []int{4, 5}},
+	}
+	for _, tc := range testcases {
+		partition := &GPU{Id: "amdgpu_xcp_1", NodeId: tc.nodeId, NumaNode: -1}
+		r.resolvePartition(partition, gpu)
+		if partition.NumaNode != tc.expNumaNode || !reflect.DeepEqual(partition.NumaNodes, tc.expNodes) {
+			t.Errorf("NUMA nodes of KFD node %d were incorrect, got: %d %v, want: %d %v", tc.nodeId, partition.NumaNode, partition.NumaNodes, tc.expNumaNode, tc.expNodes)
+		}
+	}
+}
@@ -0,0 +1,450 @@
+/**
+ * Copyright 2025 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package amdgpu
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/hwloc"
+	"github.com/golang/glog"
+)
+
+// remoteDistance is the distance from which NUMA nodes are in different
+// packages, the kernel's REMOTE_DISTANCE. The local distance is 10 and sub
+// NUMA clusters of a package are usually at 11 or 12 from each other.
+const remoteDistance = 20
+
+// numaResolver resolves the NUMA nodes of the GPUs during a discovery. The
+// hwloc and KFD topologies are only loaded once, if needed.
+type numaResolver struct {
+	hwloc       *hwloc.Hwloc
+	hwlocLoaded bool
+
+	kfdLoaded bool
+	// cpuNodes maps the KFD CPU nodes to their NUMA node
+	cpuNodes map[int]int
+	// links holds the weights of the KFD io_links from a node to the CPU
+	// nodes
+	links map[int]map[int]int64
+}
+
+func newNumaResolver() *numaResolver {
+	return &numaResolver{}
+}
+
+// close releases the hwloc topology
+func (r *numaResolver) close() {
+	if r.hwloc != nil {
+		r.hwloc.Destroy()
+		r.hwloc = nil
+	}
+}
+
+// hwlocNumaNodes returns the NUMA nodes hwloc finds local to a PCI device,
+// if UseHwloc is set
+func (r *numaResolver) hwlocNumaNodes(pciAddress string) []int {
+	if !UseHwloc || pciAddress == "" {
+		return nil
+	}
+	if !r.hwlocLoaded {
+		r.hwlocLoaded = true
+		h := &hwloc.Hwloc{}
+		if err := h.Init(); err != nil {
+			glog.Warningf("Failed to load hwloc topology: %s", err)
+			return nil
+		}
+		r.hwloc = h
+	}
+	if r.hwloc == nil {
+		return nil
+	}
+	ids, err := r.hwloc.GetNUMANodes(pciAddress)
+	if err != nil {
+		glog.Warningf("Failed to get NUMA nodes of %s from hwloc: %s", pciAddress, err)
+		return nil
+	}
+	nodes := make([]int, 0, len(ids))
+	for _, id := range ids {
+		nodes = append(nodes, int(id))
+	}
+	slices.Sort(nodes)
+	return nodes
+}
+
+// readTopologyProperties reads the <name> <value> entries of a KFD
+// properties file
+func readTopologyProperties(path string) (map[string]int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	properties := make(map[string]int64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		if v, err := strconv.ParseInt(fields[1], 0, 64); err == nil {
+			properties[fields[0]] = v
+		}
+	}
+	return properties, scanner.Err()
+}
+
+// numaNodeCPUs returns the number of cpus of the NUMA nodes with cpus, by
+// NUMA node
+func numaNodeCPUs() map[int]int {
+	res := make(map[int]int)
+	paths, _ := filepath.Glob(SysfsPath("devices/system/node/node*/cpulist"))
+	for _, path := range paths {
+		node, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(filepath.Dir(path)), "node"))
+		if err != nil {
+			continue
+		}
+		v, err := readSysfsString(path)
+		if err != nil {
+			continue
+		}
+		cpus, err := parseCPUList(v)
+		if err != nil {
+			glog.Warningf("Failed to parse %s: %s", path, err)
+			continue
+		}
+		if len(cpus) > 0 {
+			res[node] = len(cpus)
+		}
+	}
+	return res
+}
+
+// loadKFD reads the CPU nodes of the KFD topology and the links of every
+// node to them. The KFD creates a CPU node per NUMA node with cpus, in the
+// order of the NUMA nodes, which are matched with the NUMA nodes whose
+// cpulist isn't empty: memory only NUMA nodes, such as CXL memory, have no
+// CPU node and NUMA node ids may be sparse. The CPU nodes are left unmatched
+// if their number or cpus don't agree with the NUMA nodes.
+func (r *numaResolver) loadKFD() {
+	r.kfdLoaded = true
+	r.cpuNodes = make(map[int]int)
+	r.links = make(map[int]map[int]int64)
+	nodesDir := SysfsPath("class/kfd/kfd/topology/nodes")
+	paths, _ := filepath.Glob(filepath.Join(nodesDir, "*", "properties"))
+	cores := make(map[int]int)
+	var cpus []int
+	for _, path := range paths {
+		id, err := strconv.Atoi(filepath.Base(filepath.Dir(path)))
+		if err != nil {
+			continue
+		}
+		properties, err := readTopologyProperties(path)
+		if err != nil {
+			glog.Warningf("Failed to read %s: %s", path, err)
+			continue
+		}
+		if properties["cpu_cores_count"] > 0 {
+			cpus = append(cpus, id)
+			cores[id] = int(properties["cpu_cores_count"])
+		}
+	}
+	if len(cpus) == 0 {
+		return
+	}
+	slices.Sort(cpus)
+	nodeCPUs := numaNodeCPUs()
+	numaNodes := make([]int, 0, len(nodeCPUs))
+	for node := range nodeCPUs {
+		numaNodes = append(numaNodes, node)
+	}
+	slices.Sort(numaNodes)
+	if len(numaNodes) != len(cpus) {
+		glog.Warningf("Failed to match the %d KFD CPU nodes with the %d NUMA nodes with cpus", len(cpus), len(numaNodes))
+		return
+	}
+	for i, id := range cpus {
+		if cores[id] != nodeCPUs[numaNodes[i]] {
+			glog.Warningf("KFD CPU node %d has %d cores but NUMA node %d has %d cpus, ignoring the KFD CPU nodes",
+				id, cores[id], numaNodes[i], nodeCPUs[numaNodes[i]])
+			clear(r.cpuNodes)
+			return
+		}
+		r.cpuNodes[id] = numaNodes[i]
+	}
+	for _, path := range paths {
+		id, err := strconv.Atoi(filepath.Base(filepath.Dir(path)))
+		if err != nil {
+			continue
+		}
+		links, _ := filepath.Glob(filepath.Join(nodesDir, strconv.Itoa(id), "io_links", "*", "properties"))
+		for _, link := range links {
+			properties, err := readTopologyProperties(link)
+			if err != nil {
+				continue
+			}
+			to := int(properties["node_to"])
+			if _, isCPU := r.cpuNodes[to]; !isCPU || to == id {
+				continue
+			}
+			if r.links[id] == nil {
+				r.links[id] = make(map[int]int64)
+			}
+			r.links[id][to] = properties["weight"]
+		}
+	}
+}
+
+// kfdClosestCPUs returns the closest KFD CPU nodes a KFD GPU node is linked
+// to, in ascending order
+func (r *numaResolver) kfdClosestCPUs(nodeId int) []int {
+	if !r.kfdLoaded {
+		r.loadKFD()
+	}
+	links := r.links[nodeId]
+	closest := int64(-1)
+	for _, weight := range links {
+		if closest < 0 || weight < closest {
+			closest = weight
+		}
+	}
+	var cpus []int
+	for cpu, weight := range links {
+		if weight == closest {
+			cpus = append(cpus, cpu)
+		}
+	}
+	slices.Sort(cpus)
+	return cpus
+}
+
+// kfdNumaNodes returns the NUMA nodes local to a KFD GPU node: the ones of
+// the closest CPU nodes it's linked to, and of the CPU nodes at less than
+// remoteDistance from them
+func (r *numaResolver) kfdNumaNodes(nodeId int) []int {
+	var nodes []int
+	for _, cpu := range r.kfdClosestCPUs(nodeId) {
+		nodes = append(nodes, r.cpuNodes[cpu])
+		for sibling, distance := range r.links[cpu] {
+			if distance < remoteDistance {
+				nodes = append(nodes, r.cpuNodes[sibling])
+			}
+		}
+	}
+	slices.Sort(nodes)
+	return slices.Compact(nodes)
+}
+
+// parseCPUList parses a kernel cpu list, ex: 0-15,32-47
+func parseCPUList(s string) ([]int, error) {
+	var cpus []int
+	for _, r := range strings.Split(strings.TrimSpace(s), ",") {
+		if r == "" {
+			continue
+		}
+		first, last, isRange := strings.Cut(r, "-")
+		from, err := strconv.Atoi(first)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cpu list %q", s)
+		}
+		to := from
+		if isRange {
+			if to, err = strconv.Atoi(last); err != nil || to < from {
+				return nil, fmt.Errorf("invalid cpu list %q", s)
+			}
+		}
+		for cpu := from; cpu <= to; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
+
+// numaNodesOfCPUs returns the NUMA nodes some of the cpus belong to, in
+// ascending order
+func numaNodesOfCPUs(cpus []int) []int {
+	var nodes []int
+	paths, _ := filepath.Glob(SysfsPath("devices/system/node/node*/cpulist"))
+	for _, path := range paths {
+		node, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(filepath.Dir(path)), "node"))
+		if err != nil {
+			continue
+		}
+		v, err := readSysfsString(path)
+		if err != nil {
+			continue
+		}
+		nodeCPUs, err := parseCPUList(v)
+		if err != nil {
+			glog.Warningf("Failed to parse %s: %s", path, err)
+			continue
+		}
+		for _, cpu := range nodeCPUs {
+			if slices.Contains(cpus, cpu) {
+				nodes = append(nodes, node)
+				break
+			}
+		}
+	}
+	slices.Sort(nodes)
+	return nodes
+}
+
+// cpuListNumaNodes returns the NUMA nodes of the cpus local to a PCI device,
+// as listed by the device itself or by the bridge it sits behind
+func cpuListNumaNodes(path string) []int {
+	dirs := []string{path}
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		dirs = append(dirs, filepath.Dir(real))
+	}
+	for _, dir := range dirs {
+		v, err := readSysfsString(filepath.Join(dir, "local_cpulist"))
+		if err != nil {
+			continue
+		}
+		cpus, err := parseCPUList(v)
+		if err != nil || len(cpus) == 0 {
+			continue
+		}
+		if nodes := numaNodesOfCPUs(cpus); len(nodes) > 0 {
+			return nodes
+		}
+	}
+	return nil
+}
+
+// localNumaNodes resolves the NUMA nodes local to a GPU whose numa_node is
+// unknown, from the cpus local to it or its bridge, then from hwloc. A GPU
+// at the same distance of several NUMA nodes, such as one on a bus shared
+// by the sockets, is local to all of them.
+func (r *numaResolver) localNumaNodes(path, pciAddress string) []int {
+	if nodes := cpuListNumaNodes(path); len(nodes) > 0 {
+		return nodes
+	}
+	return r.hwlocNumaNodes(pciAddress)
+}
+
+// readNumaNode returns the NUMA node of a PCI device, -1 if the firmware
+// didn't report it
+func readNumaNode(path string) (int, error) {
+	v, err := readSysfsString(filepath.Join(path, "numa_node"))
+	if err != nil {
+		return -1, err
+	}
+	node, err := strconv.Atoi(v)
+	if err != nil {
+		return -1, fmt.Errorf("invalid numa_node %q: %v", v, err)
+	}
+	return node, nil
+}
+
+// resolve sets the NUMA nodes of a GPU. The NUMA node is read from its
+// numa_node, or from the cpus local to it if unknown. The GPU is also local
+// to the NUMA nodes of the same package as its NUMA node, which the KFD
+// topology reports on systems with sub-NUMA clustering, ex: NPS4. hwloc is
+// only consulted if the KFD topology has no answer, since loading its
+// topology on every discovery is costly.
+func (r *numaResolver) resolve(gpu *GPU, path string) {
+	node, err := readNumaNode(path)
+	if err != nil {
+		glog.Warningf("Failed to read the NUMA node of %s: %s", gpu.Id, err)
+	}
+	if node >= 0 {
+		gpu.NumaNode = node
+		gpu.NumaNodes = []int{node}
+	} else {
+		gpu.NumaNodes = r.localNumaNodes(path, gpu.PciAddress)
+		if len(gpu.NumaNodes) == 0 {
+			glog.Warningf("NUMA node of %s is unknown", gpu.Id)
+			gpu.NumaNode = -1
+			return
+		}
+		glog.Infof("NUMA node of %s is unknown, using the NUMA nodes local to it: %v", gpu.Id, gpu.NumaNodes)
+		gpu.NumaNode = gpu.NumaNodes[0]
+	}
+
+	// the nodes are only trusted if they agree with the NUMA node found
+	nodes := r.kfdNumaNodes(gpu.NodeId)
+	if !slices.Contains(nodes, gpu.NumaNode) {
+		nodes = r.hwlocNumaNodes(gpu.PciAddress)
+	}
+	if slices.Contains(nodes, gpu.NumaNode) {
+		gpu.NumaNodes = append(gpu.NumaNodes, nodes...)
+	}
+	slices.Sort(gpu.NumaNodes)
+	gpu.NumaNodes = slices.Compact(gpu.NumaNodes)
+}
+
+// resolvePartition sets the NUMA nodes of a compute partition to the ones of
+// its GPU, unless the KFD topology links the partition to other CPU nodes
+// than the GPU, as when its memory partition is local to another NUMA node
+func (r *numaResolver) resolvePartition(partition, gpu *GPU) {
+	partition.NumaNode = gpu.NumaNode
+	partition.NumaNodes = gpu.NumaNodes
+	cpus := r.kfdClosestCPUs(partition.NodeId)
+	if len(cpus) == 0 || slices.Equal(cpus, r.kfdClosestCPUs(gpu.NodeId)) {
+		return
+	}
+	partition.NumaNode = r.cpuNodes[cpus[0]]
+	partition.NumaNodes = r.kfdNumaNodes(partition.NodeId)
+	glog.Infof("NUMA nodes of %s are %v, not the ones of %s", partition.Id, partition.NumaNodes, gpu.Id)
+}
+
+// memoryPartitionCount returns the number of memory partitions of a memory
+// partition type, ex: 4 for nps4, 0 if unknown
+func memoryPartitionCount(memoryPartitionType string) int {
+	n, err := strconv.Atoi(strings.TrimPrefix(memoryPartitionType, "nps"))
+	if err != nil || n <= 0 || !strings.HasPrefix(memoryPartitionType, "nps") {
+		return 0
+	}
+	return n
+}
+
+// setMemoryDomains sets the memory partition of the GPUs and their compute
+// partitions. The driver assigns the compute partitions to the memory
+// partitions evenly and in order, so are their KFD nodes: with 8 compute
+// partitions in NPS4, the first two KFD nodes of the GPU are in the first
+// memory partition. Only the partitions with a memory bank of their own in
+// the KFD topology are assigned one.
+func setMemoryDomains(devices map[string]*GPU) {
+	gpus := make(map[string][]*GPU)
+	for _, gpu := range devices {
+		if gpu.PartitionType() != "" && gpu.DevId != "" {
+			gpus[gpu.DevId] = append(gpus[gpu.DevId], gpu)
+		}
+	}
+	for devId, partitions := range gpus {
+		count := memoryPartitionCount(partitions[0].MemoryPartitionType)
+		if count == 0 || len(partitions)%count != 0 {
+			glog.Warningf("Failed to map the %d partitions of %s to its %s memory partitions", len(partitions), devId, partitions[0].MemoryPartitionType)
+			continue
+		}
+		slices.SortFunc(partitions, func(a, b *GPU) int {
+			return a.NodeId - b.NodeId
+		})
+		for i, partition := range partitions {
+			if partition.VramBytes > 0 {
+				partition.MemoryDomain = i*count/len(partitions) + 1
+			}
+		}
+	}
+}
@@ -0,0 +1,174 @@
+/**
+ * Copyright 2025 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package amdgpu
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/golang/glog"
+)
+
+// settleDelay is how long the watcher waits after a filesystem event before
+// re-reading sysfs. Repartitioning a GPU removes and re-creates all of its
+// partition devices, so this lets the tree settle first.
+const settleDelay = 2 * time.Second
+
+// Watcher detects GPUs being added or removed and changes to their compute
+// or memory partitioning, and notifies its subscribers. Changes are picked
+// up through inotify on the amdgpu sysfs directories when available, and by
+// polling sysfs at a fixed interval as a fallback, since sysfs attributes
+// such as current_compute_partition do not generate inotify events.
+type Watcher struct {
+	interval    time.Duration
+	mu          sync.Mutex
+	subscribers []chan struct{}
+	last        string
+}
+
+// NewWatcher returns a watcher polling sysfs every interval
+func NewWatcher(interval time.Duration) *Watcher {
+	return &Watcher{
+		interval: interval,
+	}
+}
+
+// Subscribe returns a channel receiving a notification every time the GPU
+// configuration of the node changes. Notifications are coalesced, so a slow
+// subscriber sees at most one pending notification.
+func (w *Watcher) Subscribe() <-chan struct{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	ch := make(chan struct{}, 1)
+	w.subscribers = append(w.subscribers, ch)
+	return ch
+}
+
+// Unsubscribe stops notifications on a channel returned by Subscribe
+func (w *Watcher) Unsubscribe(ch <-chan struct{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for i, sub := range w.subscribers {
+		if sub == ch {
+			w.subscribers = append(w.subscribers[:i], w.subscribers[i+1:]...)
+			return
+		}
+	}
+}
+
+func (w *Watcher) notify() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, sub := range w.subscribers {
+		select {
+		case sub <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// check compares the current sysfs state against the last one seen and
+// notifies subscribers if it differs
+func (w *Watcher) check() {
+	current := deviceFingerprint()
+	if current == w.last {
+		return
+	}
+	glog.Infof("AMD GPU configuration changed, previous: [%s] current: [%s]", w.last, current)
+	w.last = current
+	w.notify()
+}
+
+// Run watches for changes until stop is closed
+func (w *Watcher) Run(stop <-chan struct{}) {
+	w.last = deviceFingerprint()
+
+	var events chan fsnotify.Event
+	var errs chan error
+	fsWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		glog.Warningf("inotify unavailable, polling for GPU changes every %v: %v", w.interval, err)
+	} else {
+		defer fsWatcher.Close()
+		for _, path := range []string{
+			SysfsPath("module/amdgpu/drivers/pci:amdgpu"),
+			SysfsPath("devices/platform"),
+		} {
+			if err := fsWatcher.Add(path); err != nil {
+				glog.Warningf("Unable to watch %s, relying on polling: %v", path, err)
+			}
+		}
+		events = fsWatcher.Events
+		errs = fsWatcher.Errors
+	}
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	var settled <-chan time.Time
+
+	for {
+		select {
+		case <-stop:
+			return
+		case event, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			if !strings.HasPrefix(filepath.Base(event.Name), "amdgpu_xcp_") &&
+				filepath.Dir(event.Name) != SysfsPath("module/amdgpu/drivers/pci:amdgpu") {
+				continue
+			}
+			glog.V(3).Infof("Received sysfs event: %s", event)
+			if settled == nil {
+				settled = time.After(settleDelay)
+			}
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			glog.Warningf("sysfs watch error: %v", err)
+		case <-settled:
+			settled = nil
+			w.check()
+		case <-ticker.C:
+			w.check()
+		}
+	}
+}
+
+// deviceFingerprint summarises the AMD GPUs, their partition modes and their
+// partitions as currently visible in sysfs
+func deviceFingerprint() string {
+	var b strings.Builder
+	for _, path := range pciDevicePaths() {
+		computePartitionType, _ := readSysfsString(filepath.Join(path, "current_compute_partition"))
+		memoryPartitionType, _ := readSysfsString(filepath.Join(path, "current_memory_partition"))
+		fmt.Fprintf(&b, "%s:%s_%s;", filepath.Base(path), computePartitionType, memoryPartitionType)
+	}
+	platformMatches, _ := filepath.Glob(SysfsPath("devices/platform") + "/amdgpu_xcp_*")
+	for _, path := range platformMatches {
+		_, renderD := drmMinors(path)
+		fmt.Fprintf(&b, "%s:%d;", filepath.Base(path), renderD)
+	}
+	return b.String()
+}
@@ -0,0 +1,69 @@
+/**
+ * Copyright 2025 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package amdgpu
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatcherDetectsRepartition(t *testing.T) {
+	defer func(root string) { SysfsRoot = root }(SysfsRoot)
+	SysfsRoot = newFakeSysfsRoot(t, "../../../testdata/topology-parsing-mi308", "cpx", "nps1")
+
+	w := NewWatcher(50 * time.Millisecond)
+	updates := w.Subscribe()
+	stop := make(chan struct{})
+	defer close(stop)
+	go w.Run(stop)
+
+	// give the watcher time to take its initial snapshot
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case <-updates:
+		t.Fatalf("Unexpected notification without any change")
+	default:
+	}
+
+	partitionFile := filepath.Join(pciDevicePaths()[0], "current_compute_partition")
+	if err := ioutil.WriteFile(partitionFile, []byte("SPX\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-updates:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("No notification after compute partition change")
+	}
+
+	xcps, _ := filepath.Glob(SysfsPath("devices/platform") + "/amdgpu_xcp_*")
+	if err := os.RemoveAll(xcps[0]); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-updates:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("No notification after partition removal")
+	}
+
+	w.Unsubscribe(updates)
+	if len(w.subscribers) != 0 {
+		t.Errorf("Subscriber was not removed")
+	}
+}
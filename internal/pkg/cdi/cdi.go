@@ -0,0 +1,179 @@
+/**
+ * Copyright 2025 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+// Package cdi generates Container Device Interface (CDI) specs for the AMD
+// GPUs of a node, letting container runtimes such as containerd and CRI-O
+// inject the device nodes themselves.
+// See https://github.com/cncf-tags/container-device-interface/blob/main/SPEC.md
+package cdi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/amdgpu"
+)
+
+const (
+	// Version of the CDI specification the generated specs conform to
+	Version = "0.5.0"
+	// DefaultSpecDir is where container runtimes look for dynamic specs
+	DefaultSpecDir = "/var/run/cdi"
+	// Class is the device class of the generated specs
+	Class = "gpu"
+	// AllDevices is the name of the device injecting every GPU of the node
+	AllDevices = "all"
+)
+
+// Spec is a CDI spec file
+type Spec struct {
+	Version        string         `json:"cdiVersion"`
+	Kind           string         `json:"kind"`
+	Devices        []Device       `json:"devices"`
+	ContainerEdits ContainerEdits `json:"containerEdits,omitempty"`
+}
+
+// Device is a device of a CDI spec, referred to as <kind>=<name>
+type Device struct {
+	Name           string         `json:"name"`
+	ContainerEdits ContainerEdits `json:"containerEdits"`
+}
+
+// ContainerEdits are the changes made to a container using a device
+type ContainerEdits struct {
+	Env         []string      `json:"env,omitempty"`
+	DeviceNodes []*DeviceNode `json:"deviceNodes,omitempty"`
+}
+
+// DeviceNode is a device node made available in the container
+type DeviceNode struct {
+	Path        string `json:"path"`
+	HostPath    string `json:"hostPath,omitempty"`
+	Permissions string `json:"permissions,omitempty"`
+}
+
+// Kind returns the CDI kind of the devices advertised under a vendor
+// namespace, ex: amd.com/gpu
+func Kind(vendor string) string {
+	return vendor + "/" + Class
+}
+
+// QualifiedName returns the fully qualified CDI name of a device, ex:
+// amd.com/gpu=0000:03:00.0
+func QualifiedName(vendor, device string) string {
+	return Kind(vendor) + "=" + device
+}
+
+// SpecFileName returns the name of the spec file of a vendor, ex:
+// amd.com-gpu.json
+func SpecFileName(vendor string) string {
+	return strings.ReplaceAll(Kind(vendor), "/", "-") + ".json"
+}
+
+func deviceNode(path string) *DeviceNode {
+	return &DeviceNode{
+		Path:        path,
+		HostPath:    path,
+		Permissions: "rw",
+	}
+}
+
+// gpuDeviceNodes returns the device nodes of a GPU or partition
+func gpuDeviceNodes(gpu *amdgpu.GPU) []*DeviceNode {
+	return []*DeviceNode{
+		deviceNode(fmt.Sprintf("/dev/dri/card%d", gpu.Card)),
+		deviceNode(fmt.Sprintf("/dev/dri/renderD%d", gpu.RenderD)),
+	}
+}
+
+// NewSpec returns a spec with one device per GPU or partition, named after
+// its ID, and an "all" device. /dev/kfd is added to every container using
+// one of the devices if injectKFD is set.
+func NewSpec(vendor string, gpus map[string]*amdgpu.GPU, injectKFD bool) *Spec {
+	spec := &Spec{
+		Version: Version,
+		Kind:    Kind(vendor),
+		Devices: []Device{},
+	}
+	if injectKFD {
+		spec.ContainerEdits.DeviceNodes = append(spec.ContainerEdits.DeviceNodes, deviceNode("/dev/kfd"))
+	}
+
+	ids := make([]string, 0, len(gpus))
+	for id := range gpus {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	all := Device{Name: AllDevices}
+	for _, id := range ids {
+		nodes := gpuDeviceNodes(gpus[id])
+		spec.Devices = append(spec.Devices, Device{
+			Name: id,
+			ContainerEdits: ContainerEdits{
+				DeviceNodes: nodes,
+			},
+		})
+		all.ContainerEdits.DeviceNodes = append(all.ContainerEdits.DeviceNodes, nodes...)
+	}
+	if len(ids) > 0 {
+		spec.Devices = append(spec.Devices, all)
+	}
+	return spec
+}
+
+// Encode writes the spec as JSON
+func (s *Spec) Encode(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
+
+// Write atomically writes the spec into dir and returns the path of the
+// file. Runtimes watch the directory, so the spec is written to a temporary
+// file first and renamed into place.
+func (s *Spec) Write(dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("unable to create CDI spec dir %s: %v", dir, err)
+	}
+	vendor, _, _ := strings.Cut(s.Kind, "/")
+	path := filepath.Join(dir, SpecFileName(vendor))
+
+	tmp, err := os.CreateTemp(dir, ".tmp-"+SpecFileName(vendor))
+	if err != nil {
+		return "", fmt.Errorf("unable to create CDI spec: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if err := s.Encode(tmp); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("unable to write CDI spec: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("unable to write CDI spec: %v", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return "", fmt.Errorf("unable to write CDI spec: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", fmt.Errorf("unable to write CDI spec %s: %v", path, err)
+	}
+	return path, nil
+}
@@ -0,0 +1,99 @@
+/**
+ * Copyright 2025 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package cdi
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/amdgpu"
+)
+
+func TestNewSpec(t *testing.T) {
+	gpus := map[string]*amdgpu.GPU{
+		"0000:80:00.0": {Id: "0000:80:00.0", Card: 2, RenderD: 129},
+		"0000:0a:00.0": {Id: "0000:0a:00.0", Card: 1, RenderD: 128},
+	}
+	spec := NewSpec("amd.com", gpus, true)
+	if spec.Kind != "amd.com/gpu" || spec.Version != Version {
+		t.Errorf("Spec header was incorrect, got: %s %s", spec.Kind, spec.Version)
+	}
+	if len(spec.ContainerEdits.DeviceNodes) != 1 || spec.ContainerEdits.DeviceNodes[0].Path != "/dev/kfd" {
+		t.Errorf("Expected /dev/kfd in the spec container edits, got: %+v", spec.ContainerEdits)
+	}
+	expDevices := []struct {
+		name  string
+		paths []string
+	}{
+		{"0000:0a:00.0", []string{"/dev/dri/card1", "/dev/dri/renderD128"}},
+		{"0000:80:00.0", []string{"/dev/dri/card2", "/dev/dri/renderD129"}},
+		{AllDevices, []string{"/dev/dri/card1", "/dev/dri/renderD128", "/dev/dri/card2", "/dev/dri/renderD129"}},
+	}
+	if len(spec.Devices) != len(expDevices) {
+		t.Fatalf("Expected %d devices, got: %+v", len(expDevices), spec.Devices)
+	}
+	for i, exp := range expDevices {
+		dev := spec.Devices[i]
+		if dev.Name != exp.name || len(dev.ContainerEdits.DeviceNodes) != len(exp.paths) {
+			t.Errorf("Device %d was incorrect, got: %+v, want: %+v", i, dev, exp)
+			continue
+		}
+		for j, node := range dev.ContainerEdits.DeviceNodes {
+			if node.Path != exp.paths[j] || node.HostPath != exp.paths[j] {
+				t.Errorf("Device node of %s was incorrect, got: %+v, want: %s", dev.Name, node, exp.paths[j])
+			}
+		}
+	}
+
+	if spec := NewSpec("amd.com", gpus, false); len(spec.ContainerEdits.DeviceNodes) != 0 {
+		t.Errorf("Expected no /dev/kfd without injection, got: %+v", spec.ContainerEdits)
+	}
+	if QualifiedName("amd.com", "0000:0a:00.0") != "amd.com/gpu=0000:0a:00.0" {
+		t.Errorf("Qualified name was incorrect, got: %s", QualifiedName("amd.com", "0000:0a:00.0"))
+	}
+}
+
+func TestWrite(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "cdi")
+	spec := NewSpec("amd.com", map[string]*amdgpu.GPU{
+		"amdgpu_xcp_1": {Id: "amdgpu_xcp_1", Card: 3, RenderD: 130},
+	}, true)
+	path, err := spec.Write(dir)
+	if err != nil {
+		t.Fatalf("expected Write to pass. But failed with error %v", err)
+	}
+	if path != filepath.Join(dir, "amd.com-gpu.json") {
+		t.Errorf("Spec path was incorrect, got: %s", path)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("Expected only the spec file in %s, got: %v", dir, entries)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var read Spec
+	if err := json.Unmarshal(data, &read); err != nil {
+		t.Fatalf("expected spec to be valid JSON. But failed with error %v", err)
+	}
+	if read.Kind != spec.Kind || len(read.Devices) != 2 || read.Devices[0].Name != "amdgpu_xcp_1" {
+		t.Errorf("Written spec was incorrect, got: %+v", read)
+	}
+}
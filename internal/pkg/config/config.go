@@ -0,0 +1,623 @@
+/**
+# Copyright 2025 Advanced Micro Devices, Inc. All rights reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the \"License\");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an \"AS IS\" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+**/
+
+// Package config holds the versioned configuration of the device plugin.
+// The configuration is read from a YAML or JSON file, typically mounted from
+// a ConfigMap, and can carry per-node overrides so a single file can serve a
+// heterogeneous cluster.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+// Version is the only configuration version understood by this release
+const Version = "v1"
+
+const (
+	StrategySingle = "single"
+	StrategyMixed  = "mixed"
+
+	// PolicyBestEffort prefers the devices with the best connectivity
+	PolicyBestEffort = "besteffort"
+	// PolicyPacked only allocates devices of the same NUMA node, and
+	// partitions of the same GPU
+	PolicyPacked = "packed"
+	// PolicySpread spreads the devices over as many GPUs and NUMA nodes as
+	// possible
+	PolicySpread = "spread"
+	// PolicyXGMIHive only allocates devices of the same XGMI hive
+	PolicyXGMIHive = "xgmi-hive"
+	// PolicyNone leaves the allocation to the kubelet
+	PolicyNone = "none"
+)
+
+// KFD io_link types
+const (
+	LinkTypePCIe = 2
+	LinkTypeXGMI = 11
+)
+
+// Policies lists the allocator policies
+var Policies = []string{PolicyBestEffort, PolicyPacked, PolicySpread, PolicyXGMIHive, PolicyNone}
+
+// Native per-device health checks
+const (
+	// HealthCheckDRM checks that the render node of the device opens, it
+	// needs /dev/dri in the container and is off by default
+	HealthCheckDRM = "drm"
+	// HealthCheckKFD checks that the KFD topology node of the device exists
+	HealthCheckKFD = "kfd"
+	// HealthCheckPCILink checks that the GPU is on the bus with its link up
+	HealthCheckPCILink = "pciLink"
+	// HealthCheckRAS checks the RAS error counts of the GPU
+	HealthCheckRAS = "ras"
+)
+
+// Values of the environment variables set by Allocate, each one is a comma
+// separated list with an entry per allocated device
+const (
+	// EnvIndex is the index of the device among the allocated ones, in the
+	// order the ROCm runtime enumerates them
+	EnvIndex = "index"
+	// EnvUniqueID is an identifier defined by the plugin: GPU-<unique id> for
+	// a GPU, the unique id being the one ROCm reports, suffixed with
+	// -<partition index> for its partitions, which share the unique id
+	EnvUniqueID = "uniqueId"
+	// EnvRenderMinor is the minor of the /dev/dri/renderD node
+	EnvRenderMinor = "renderMinor"
+	// EnvPCIBusID is the PCI address of the GPU, shared by its partitions
+	EnvPCIBusID = "pciBusId"
+	// EnvPartitionMode is the compute and memory partition mode, ex: cpx_nps1
+	EnvPartitionMode = "partitionMode"
+	// EnvDeviceID is the device ID advertised to the kubelet
+	EnvDeviceID = "deviceId"
+)
+
+// Config is the device plugin configuration
+type Config struct {
+	Version string `json:"version"`
+	// ResourceNamespace is the namespace resources are advertised under,
+	// ex: amd.com for amd.com/gpu
+	ResourceNamespace string `json:"resourceNamespace"`
+	// ResourceNamingStrategy is single or mixed
+	ResourceNamingStrategy string `json:"resourceNamingStrategy"`
+	// Pulse is the time between health checks in seconds, 0 disables them
+	Pulse int `json:"pulse"`
+	// WatchInterval is the time between sysfs polls for GPU hot-plug and
+	// repartitioning in seconds, 0 disables watching
+	WatchInterval int             `json:"watchInterval"`
+	Exporter      ExporterConfig  `json:"exporter"`
+	Allocate      AllocateConfig  `json:"allocate"`
+	Allocator     AllocatorConfig `json:"allocator"`
+	Sharing       SharingConfig   `json:"sharing"`
+	CDI           CDIConfig       `json:"cdi"`
+	PreStart      PreStartConfig  `json:"preStart"`
+	Health        HealthConfig    `json:"health"`
+	Metrics       MetricsConfig   `json:"metrics"`
+	Events        EventsConfig    `json:"events"`
+	// NodeOverrides are applied in order on top of the rest of the
+	// configuration for the nodes they select
+	NodeOverrides []NodeOverride `json:"nodeOverrides,omitempty"`
+}
+
+// ExporterConfig describes how to reach the amd-metrics-exporter
+type ExporterConfig struct {
+	SocketPath string `json:"socketPath"`
+	// PollInterval is the time between health queries in seconds. With 0,
+	// the health is only queried every pulse.
+	PollInterval int `json:"pollInterval"`
+	// StaleAfter is the time in seconds after which the health last
+	// received is ignored if the exporter stopped answering
+	StaleAfter int `json:"staleAfter"`
+}
+
+// AllocateConfig controls what Allocate hands to the containers
+type AllocateConfig struct {
+	// InjectKFD adds /dev/kfd to every container allocated a device
+	InjectKFD bool `json:"injectKFD"`
+	// Env lists the environment variables describing the allocated devices,
+	// none by default
+	Env []EnvVar `json:"env"`
+}
+
+// EnvVar is an environment variable set by Allocate
+type EnvVar struct {
+	Name string `json:"name"`
+	// Value is one of index, uuid, renderMinor, pciBusId, partitionMode or
+	// deviceId
+	Value string `json:"value"`
+}
+
+// AllocatorConfig selects the preferred allocation policy
+type AllocatorConfig struct {
+	Policy string `json:"policy"`
+	// Resources overrides the policy of some resources, keyed by their
+	// name as advertised without the namespace, ex: cpx_nps4
+	Resources map[string]string `json:"resources,omitempty"`
+	// Weights score the pairs of devices in the policies picking the best
+	// connected devices
+	Weights WeightsConfig `json:"weights"`
+}
+
+// WeightsConfig holds the weights summed into the score of a pair of
+// devices. The set of devices with the lowest total score is preferred.
+type WeightsConfig struct {
+	// SameGPU applies to partitions of the same GPU, DifferentGPU to
+	// devices of different GPUs
+	SameGPU      int `json:"sameGPU"`
+	DifferentGPU int `json:"differentGPU"`
+	// SameNUMANode and DifferentNUMANode apply to devices of the same or of
+	// different NUMA nodes. Partitions of a GPU in different memory
+	// partitions are in different NUMA nodes.
+	SameNUMANode      int `json:"sameNUMANode"`
+	DifferentNUMANode int `json:"differentNUMANode"`
+	// Links maps the KFD io_link types to the weight of the devices linked
+	// by them, ex: 11 for XGMI and 2 for PCIe
+	Links map[int]int `json:"links"`
+	// OtherLink applies to the link types missing from Links
+	OtherLink int `json:"otherLink"`
+	// Bandwidth, Latency and Hops apply in proportion to how much lower
+	// the bandwidth, and higher the latency and KFD weight, reported for a
+	// link are than the best ones of its type. The KFD weight of a link
+	// grows with the number of hops. They only apply to the values the KFD
+	// reports.
+	Bandwidth int `json:"bandwidth"`
+	Latency   int `json:"latency"`
+	Hops      int `json:"hops"`
+}
+
+// DefaultWeights returns the default weights, favouring partitions of the
+// same GPU, then XGMI links, then devices of the same NUMA node
+func DefaultWeights() WeightsConfig {
+	return WeightsConfig{
+		SameGPU:           10,
+		DifferentGPU:      20,
+		SameNUMANode:      10,
+		DifferentNUMANode: 20,
+		Links: map[int]int{
+			LinkTypePCIe: 40,
+			LinkTypeXGMI: 10,
+		},
+		OtherLink: 50,
+		Bandwidth: 10,
+		Latency:   10,
+		Hops:      10,
+	}
+}
+
+// Validate checks that the weights are not negative
+func (w WeightsConfig) Validate() error {
+	for name, weight := range map[string]int{
+		"sameGPU":           w.SameGPU,
+		"differentGPU":      w.DifferentGPU,
+		"sameNUMANode":      w.SameNUMANode,
+		"differentNUMANode": w.DifferentNUMANode,
+		"otherLink":         w.OtherLink,
+		"bandwidth":         w.Bandwidth,
+		"latency":           w.Latency,
+		"hops":              w.Hops,
+	} {
+		if weight < 0 {
+			return fmt.Errorf("allocator weight %s %d must not be negative", name, weight)
+		}
+	}
+	for linkType, weight := range w.Links {
+		if linkType < 0 {
+			return fmt.Errorf("invalid allocator link type %d", linkType)
+		}
+		if weight < 0 {
+			return fmt.Errorf("allocator weight of link type %d %d must not be negative", linkType, weight)
+		}
+	}
+	return nil
+}
+
+// AllocatorPolicy returns the allocator policy of an advertised resource
+func (c *Config) AllocatorPolicy(resource string) string {
+	if policy, ok := c.Allocator.Resources[resource]; ok {
+		return policy
+	}
+	return c.Allocator.Policy
+}
+
+// CDIConfig controls Container Device Interface support
+type CDIConfig struct {
+	// Enabled writes a CDI spec for the devices of the node into SpecDir
+	Enabled bool   `json:"enabled"`
+	SpecDir string `json:"specDir"`
+	// AllocateDevices makes Allocate return CDI device names instead of
+	// device nodes, leaving their injection to the container runtime
+	AllocateDevices bool `json:"allocateDevices"`
+}
+
+// PreStartConfig controls the checks run on the allocated devices before
+// their containers start
+type PreStartConfig struct {
+	// Enabled makes the kubelet call PreStartContainer, which fails the
+	// container start if one of its devices can't be opened or has no KFD
+	// node
+	Enabled bool `json:"enabled"`
+	// Command, if set, is run for every allocated device once it passed the
+	// checks, ex: to reset or scrub it. The device is described by the
+	// AMD_GPU_DEVICE_ID, AMD_GPU_PCI_BUS_ID, AMD_GPU_CARD and
+	// AMD_GPU_RENDER_MINOR environment variables.
+	Command []string `json:"command,omitempty"`
+	// Timeout of Command in seconds
+	Timeout int `json:"timeout"`
+}
+
+// HealthConfig controls the native health checks run on every device each
+// pulse. The health reported by the amd-metrics-exporter, when available,
+// is applied on top of them.
+type HealthConfig struct {
+	// Checks lists the checks to run among drm, kfd, pciLink and ras
+	Checks []string  `json:"checks"`
+	RAS    RASConfig `json:"ras"`
+}
+
+// RASConfig sets the RAS error counts at which a GPU, and all of its
+// partitions, are unhealthy. A threshold of 0 disables it.
+type RASConfig struct {
+	// UncorrectableErrors is the number of uncorrectable errors reported since
+	// the plugin started, errors logged before are ignored
+	UncorrectableErrors int64 `json:"uncorrectableErrors"`
+	// CorrectableErrors is the total of correctable errors
+	CorrectableErrors int64 `json:"correctableErrors"`
+	// CorrectableErrorRate is the number of correctable errors within
+	// RateWindow
+	CorrectableErrorRate int64 `json:"correctableErrorRate"`
+	// RateWindow in seconds
+	RateWindow int `json:"rateWindow"`
+	// BadPages is the number of retired VRAM pages
+	BadPages int `json:"badPages"`
+}
+
+// MetricsConfig controls the Prometheus metrics endpoint
+type MetricsConfig struct {
+	// Address the /metrics endpoint listens on, ex: :9110. It is disabled
+	// if empty.
+	Address string `json:"address"`
+}
+
+// EventsConfig controls how device health transitions are reported on the
+// Node object. Both require the node name and RBAC permissions.
+type EventsConfig struct {
+	// Enabled emits an Event on the Node for every device health
+	// transition
+	Enabled bool `json:"enabled"`
+	// NodeCondition maintains the AMDGPUHealthy condition of the Node
+	NodeCondition bool `json:"nodeCondition"`
+}
+
+// SharingConfig controls how devices are shared between containers
+type SharingConfig struct {
+	TimeSlicing TimeSlicingConfig `json:"timeSlicing"`
+}
+
+// TimeSlicingConfig advertises every device of a resource several times so
+// that multiple containers are allocated the same GPU or partition
+type TimeSlicingConfig struct {
+	Resources []SharedResource `json:"resources,omitempty"`
+}
+
+// SharedResource describes how the devices of a resource are shared
+type SharedResource struct {
+	// Name of the resource whose devices are shared, ex: gpu or cpx_nps4
+	Name string `json:"name"`
+	// Replicas is the number of times each device is advertised
+	Replicas int `json:"replicas"`
+	// Rename, if set, advertises the replicas under this resource name
+	// instead, ex: gpu.shared
+	Rename string `json:"rename,omitempty"`
+}
+
+// NodeOverride applies a partial configuration to the nodes matching either
+// one of NodeNames or all of NodeLabels
+type NodeOverride struct {
+	NodeNames  []string          `json:"nodeNames,omitempty"`
+	NodeLabels map[string]string `json:"nodeLabels,omitempty"`
+	// Config has the same layout as Config, only the fields present are
+	// overridden
+	Config json.RawMessage `json:"config"`
+}
+
+// Default returns the configuration used when no file is provided
+func Default() *Config {
+	return &Config{
+		Version:                Version,
+		ResourceNamespace:      "amd.com",
+		ResourceNamingStrategy: StrategySingle,
+		Pulse:                  0,
+		WatchInterval:          30,
+		Exporter: ExporterConfig{
+			SocketPath:   "/var/lib/amd-metrics-exporter/amdgpu_device_metrics_exporter_grpc.socket",
+			PollInterval: 0,
+			StaleAfter:   30,
+		},
+		Allocate: AllocateConfig{
+			InjectKFD: true,
+		},
+		Allocator: AllocatorConfig{
+			Policy:  PolicyBestEffort,
+			Weights: DefaultWeights(),
+		},
+		CDI: CDIConfig{
+			SpecDir: "/var/run/cdi",
+		},
+		PreStart: PreStartConfig{
+			Timeout: 30,
+		},
+		Health: HealthConfig{
+			Checks: []string{HealthCheckKFD, HealthCheckPCILink, HealthCheckRAS},
+			RAS: RASConfig{
+				UncorrectableErrors: 1,
+				RateWindow:          3600,
+			},
+		},
+	}
+}
+
+// Load reads a configuration file on top of the defaults and validates it
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read config file %s: %v", path, err)
+	}
+	return Parse(data)
+}
+
+// Parse decodes a YAML or JSON configuration on top of the defaults and
+// validates it
+func Parse(data []byte) (*Config, error) {
+	cfg := Default()
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("unable to parse config: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	for i, o := range cfg.NodeOverrides {
+		if len(o.NodeNames) == 0 && len(o.NodeLabels) == 0 {
+			return nil, fmt.Errorf("nodeOverrides[%d]: nodeNames or nodeLabels must be set", i)
+		}
+		// make sure the override is well formed even if it doesn't apply here
+		if _, err := cfg.apply(o); err != nil {
+			return nil, fmt.Errorf("nodeOverrides[%d]: %v", i, err)
+		}
+	}
+	return cfg, nil
+}
+
+// Validate checks that the configuration is usable
+func (c *Config) Validate() error {
+	if c.Version != Version {
+		return fmt.Errorf("unsupported config version %q, expected %q", c.Version, Version)
+	}
+	if errs := validation.IsDNS1123Subdomain(c.ResourceNamespace); len(errs) > 0 {
+		return fmt.Errorf("invalid resourceNamespace %q: %v", c.ResourceNamespace, errs)
+	}
+	if !slices.Contains([]string{StrategySingle, StrategyMixed}, c.ResourceNamingStrategy) {
+		return fmt.Errorf("invalid resourceNamingStrategy %q, must be %s or %s", c.ResourceNamingStrategy, StrategySingle, StrategyMixed)
+	}
+	if c.Pulse < 0 {
+		return fmt.Errorf("pulse can not be negative")
+	}
+	if c.WatchInterval < 0 {
+		return fmt.Errorf("watchInterval can not be negative")
+	}
+	if !filepath.IsAbs(c.Exporter.SocketPath) {
+		return fmt.Errorf("exporter socketPath %q must be an absolute path", c.Exporter.SocketPath)
+	}
+	if c.Exporter.PollInterval < 0 {
+		return fmt.Errorf("exporter pollInterval can not be negative")
+	}
+	if c.Exporter.PollInterval > 0 && c.Exporter.StaleAfter < c.Exporter.PollInterval {
+		return fmt.Errorf("exporter staleAfter %d must be at least pollInterval %d", c.Exporter.StaleAfter, c.Exporter.PollInterval)
+	}
+	if !slices.Contains(Policies, c.Allocator.Policy) {
+		return fmt.Errorf("invalid allocator policy %q, must be one of %v", c.Allocator.Policy, Policies)
+	}
+	for resource, policy := range c.Allocator.Resources {
+		if !slices.Contains(Policies, policy) {
+			return fmt.Errorf("invalid allocator policy %q of resource %s, must be one of %v", policy, resource, Policies)
+		}
+	}
+	if err := c.Allocator.Weights.Validate(); err != nil {
+		return err
+	}
+	envNames := make(map[string]bool)
+	for i, env := range c.Allocate.Env {
+		if errs := validation.IsEnvVarName(env.Name); len(errs) > 0 {
+			return fmt.Errorf("allocate.env[%d]: invalid name %q: %v", i, env.Name, errs)
+		}
+		if envNames[env.Name] {
+			return fmt.Errorf("allocate.env[%d]: %s is set more than once", i, env.Name)
+		}
+		envNames[env.Name] = true
+		if !slices.Contains([]string{EnvIndex, EnvUniqueID, EnvRenderMinor, EnvPCIBusID, EnvPartitionMode, EnvDeviceID}, env.Value) {
+			return fmt.Errorf("allocate.env[%d]: invalid value %q for %s", i, env.Value, env.Name)
+		}
+	}
+	if !filepath.IsAbs(c.CDI.SpecDir) {
+		return fmt.Errorf("cdi specDir %q must be an absolute path", c.CDI.SpecDir)
+	}
+	if c.CDI.AllocateDevices && !c.CDI.Enabled {
+		return fmt.Errorf("cdi allocateDevices requires cdi to be enabled")
+	}
+	for i, check := range c.Health.Checks {
+		if !slices.Contains([]string{HealthCheckDRM, HealthCheckKFD, HealthCheckPCILink, HealthCheckRAS}, check) {
+			return fmt.Errorf("health.checks[%d]: unknown check %q", i, check)
+		}
+	}
+	ras := c.Health.RAS
+	if ras.UncorrectableErrors < 0 || ras.CorrectableErrors < 0 || ras.CorrectableErrorRate < 0 || ras.BadPages < 0 {
+		return fmt.Errorf("health ras thresholds can not be negative")
+	}
+	if ras.RateWindow <= 0 {
+		return fmt.Errorf("health ras rateWindow must be positive")
+	}
+	if c.Metrics.Address != "" {
+		if _, _, err := net.SplitHostPort(c.Metrics.Address); err != nil {
+			return fmt.Errorf("invalid metrics address %q: %v", c.Metrics.Address, err)
+		}
+	}
+	if c.PreStart.Timeout <= 0 {
+		return fmt.Errorf("preStart timeout must be positive")
+	}
+	if len(c.PreStart.Command) > 0 && !c.PreStart.Enabled {
+		return fmt.Errorf("preStart command requires preStart to be enabled")
+	}
+	return c.Sharing.TimeSlicing.validate(c.ResourceNamespace)
+}
+
+func (t *TimeSlicingConfig) validate(namespace string) error {
+	names := make(map[string]bool)
+	for i, r := range t.Resources {
+		if r.Name == "" {
+			return fmt.Errorf("sharing.timeSlicing.resources[%d]: name must be set", i)
+		}
+		if r.Replicas < 1 {
+			return fmt.Errorf("sharing.timeSlicing.resources[%d]: replicas must be at least 1", i)
+		}
+		if r.Rename != "" {
+			if errs := validation.IsQualifiedName(namespace + "/" + r.Rename); len(errs) > 0 {
+				return fmt.Errorf("sharing.timeSlicing.resources[%d]: invalid rename %q: %v", i, r.Rename, errs)
+			}
+		}
+		used := []string{r.Name}
+		if r.Rename != "" && r.Rename != r.Name {
+			used = append(used, r.Rename)
+		}
+		for _, name := range used {
+			if names[name] {
+				return fmt.Errorf("sharing.timeSlicing.resources[%d]: resource %s is configured more than once", i, name)
+			}
+			names[name] = true
+		}
+	}
+	return nil
+}
+
+// AdvertisedName returns the name the shared resource is advertised under
+func (r *SharedResource) AdvertisedName() string {
+	if r.Rename != "" {
+		return r.Rename
+	}
+	return r.Name
+}
+
+// AdvertisedName returns the name a discovered resource is advertised under
+func (c *Config) AdvertisedName(resource string) string {
+	for _, r := range c.Sharing.TimeSlicing.Resources {
+		if r.Name == resource {
+			return r.AdvertisedName()
+		}
+	}
+	return resource
+}
+
+// SharedResource returns the time-slicing settings of a resource given the
+// name it is advertised under, nil if its devices are not shared
+func (c *Config) SharedResource(advertisedName string) *SharedResource {
+	for i, r := range c.Sharing.TimeSlicing.Resources {
+		if r.AdvertisedName() == advertisedName {
+			return &c.Sharing.TimeSlicing.Resources[i]
+		}
+	}
+	return nil
+}
+
+// Matches returns true if the override applies to the given node
+func (o *NodeOverride) Matches(nodeName string, nodeLabels map[string]string) bool {
+	if slices.Contains(o.NodeNames, nodeName) {
+		return true
+	}
+	if len(o.NodeLabels) == 0 {
+		return false
+	}
+	for k, v := range o.NodeLabels {
+		if val, ok := nodeLabels[k]; !ok || val != v {
+			return false
+		}
+	}
+	return true
+}
+
+// NeedsNodeLabels returns true if any override selects nodes by label
+func (c *Config) NeedsNodeLabels() bool {
+	for _, o := range c.NodeOverrides {
+		if len(o.NodeLabels) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// clone returns a deep copy of the configuration without its overrides
+func (c *Config) clone() *Config {
+	// the configuration only holds JSON friendly types, this can't fail
+	data, _ := json.Marshal(c)
+	res := &Config{}
+	json.Unmarshal(data, res)
+	res.NodeOverrides = nil
+	return res
+}
+
+// apply returns a copy of the configuration with an override applied
+func (c *Config) apply(o NodeOverride) (*Config, error) {
+	res := c.clone()
+	dec := json.NewDecoder(bytes.NewReader(o.Config))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(res); err != nil {
+		return nil, fmt.Errorf("invalid override config: %v", err)
+	}
+	if len(res.NodeOverrides) > 0 {
+		return nil, fmt.Errorf("nodeOverrides can not be nested")
+	}
+	if res.Version != c.Version {
+		return nil, fmt.Errorf("version can not be overridden")
+	}
+	if err := res.Validate(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ForNode returns the configuration with all the overrides matching the
+// node applied in order
+func (c *Config) ForNode(nodeName string, nodeLabels map[string]string) (*Config, error) {
+	res := c.clone()
+	for i, o := range c.NodeOverrides {
+		if !o.Matches(nodeName, nodeLabels) {
+			continue
+		}
+		var err error
+		if res, err = res.apply(o); err != nil {
+			return nil, fmt.Errorf("nodeOverrides[%d]: %v", i, err)
+		}
+	}
+	return res, nil
+}
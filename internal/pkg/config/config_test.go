@@ -0,0 +1,213 @@
+/**
+# Copyright 2025 Advanced Micro Devices, Inc. All rights reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the \"License\");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an \"AS IS\" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+**/
+
+package config
+
+import (
+	"testing"
+)
+
+const testConfig = `
+version: v1
+resourceNamespace: example.com
+pulse: 10
+exporter:
+  socketPath: /run/exporter.socket
+nodeOverrides:
+  - nodeNames: ["node-1"]
+    config:
+      resourceNamingStrategy: mixed
+  - nodeLabels:
+      gpu.type: mi300x
+    config:
+      allocate:
+        injectKFD: false
+      pulse: 0
+`
+
+func TestParse(t *testing.T) {
+	cfg, err := Parse([]byte(testConfig))
+	if err != nil {
+		t.Fatalf("expected config to parse. But failed with error %v", err)
+	}
+	if cfg.ResourceNamespace != "example.com" || cfg.Pulse != 10 || cfg.Exporter.SocketPath != "/run/exporter.socket" {
+		t.Errorf("config values not as expected: %+v", cfg)
+	}
+	// fields absent from the file keep their defaults
+	if cfg.ResourceNamingStrategy != StrategySingle || !cfg.Allocate.InjectKFD || cfg.WatchInterval != 30 {
+		t.Errorf("config defaults not as expected: %+v", cfg)
+	}
+
+	testcases := []struct {
+		description string
+		nodeName    string
+		nodeLabels  map[string]string
+		strategy    string
+		injectKFD   bool
+		pulse       int
+	}{
+		{"no override", "node-0", nil, StrategySingle, true, 10},
+		{"override by name", "node-1", nil, StrategyMixed, true, 10},
+		{"override by label", "node-2", map[string]string{"gpu.type": "mi300x"}, StrategySingle, false, 0},
+		{"override by name and label", "node-1", map[string]string{"gpu.type": "mi300x"}, StrategyMixed, false, 0},
+		{"label mismatch", "node-2", map[string]string{"gpu.type": "mi210"}, StrategySingle, true, 10},
+	}
+	for _, tc := range testcases {
+		nodeCfg, err := cfg.ForNode(tc.nodeName, tc.nodeLabels)
+		if err != nil {
+			t.Errorf("%s: expected ForNode to pass. But failed with error %v", tc.description, err)
+			continue
+		}
+		if nodeCfg.ResourceNamingStrategy != tc.strategy || nodeCfg.Allocate.InjectKFD != tc.injectKFD || nodeCfg.Pulse != tc.pulse {
+			t.Errorf("%s: config not as expected: %+v", tc.description, nodeCfg)
+		}
+		if nodeCfg.ResourceNamespace != "example.com" || len(nodeCfg.NodeOverrides) != 0 {
+			t.Errorf("%s: base config not preserved: %+v", tc.description, nodeCfg)
+		}
+	}
+	if cfg.ResourceNamingStrategy != StrategySingle {
+		t.Errorf("ForNode modified the base config")
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	testcases := []struct {
+		description string
+		config      string
+	}{
+		{"unknown version", "version: v2"},
+		{"unknown field", "version: v1\nfoo: bar"},
+		{"invalid strategy", "version: v1\nresourceNamingStrategy: dual"},
+		{"invalid namespace", "version: v1\nresourceNamespace: Not_Valid"},
+		{"negative pulse", "version: v1\npulse: -1"},
+		{"relative socket", "version: v1\nexporter:\n  socketPath: exporter.socket"},
+		{"negative poll interval", "version: v1\nexporter:\n  pollInterval: -1"},
+		{"stale before polled", "version: v1\nexporter:\n  pollInterval: 10\n  staleAfter: 5"},
+		{"invalid policy", "version: v1\nallocator:\n  policy: random"},
+		{"negative weight", "version: v1\nallocator:\n  weights:\n    differentNUMANode: -1"},
+		{"negative link weight", "version: v1\nallocator:\n  weights:\n    links:\n      11: -10"},
+		{"invalid resource policy", "version: v1\nallocator:\n  resources:\n    cpx_nps4: random"},
+		{"invalid env name", "version: v1\nallocate:\n  env:\n    - name: 1GPU\n      value: index"},
+		{"invalid env value", "version: v1\nallocate:\n  env:\n    - name: GPUS\n      value: serial"},
+		{"duplicate env", "version: v1\nallocate:\n  env:\n    - name: GPUS\n      value: index\n    - name: GPUS\n      value: uniqueId"},
+		{"relative cdi dir", "version: v1\ncdi:\n  enabled: true\n  specDir: cdi"},
+		{"cdi allocation without spec", "version: v1\ncdi:\n  allocateDevices: true"},
+		{"prestart command without prestart", "version: v1\npreStart:\n  command: [/bin/true]"},
+		{"prestart timeout", "version: v1\npreStart:\n  enabled: true\n  timeout: 0"},
+		{"unknown health check", "version: v1\nhealth:\n  checks: [drm, temperature]"},
+		{"negative ras threshold", "version: v1\nhealth:\n  ras:\n    correctableErrors: -1"},
+		{"no ras window", "version: v1\nhealth:\n  ras:\n    rateWindow: 0"},
+		{"invalid metrics address", "version: v1\nmetrics:\n  address: localhost"},
+		{"override without selector", "version: v1\nnodeOverrides:\n  - config:\n      pulse: 1"},
+		{"invalid override", "version: v1\nnodeOverrides:\n  - nodeNames: [a]\n    config:\n      pulse: -1"},
+		{"shared resource without name", "version: v1\nsharing:\n  timeSlicing:\n    resources:\n      - replicas: 2"},
+		{"no replicas", "version: v1\nsharing:\n  timeSlicing:\n    resources:\n      - name: gpu"},
+		{"invalid rename", "version: v1\nsharing:\n  timeSlicing:\n    resources:\n      - name: gpu\n        replicas: 2\n        rename: gpu/shared"},
+		{"duplicate shared resource", "version: v1\nsharing:\n  timeSlicing:\n    resources:\n      - name: gpu\n        replicas: 2\n      - name: cpx_nps1\n        replicas: 2\n        rename: gpu"},
+		{"unknown override field", "version: v1\nnodeOverrides:\n  - nodeNames: [a]\n    config:\n      foo: 1"},
+	}
+	for _, tc := range testcases {
+		if _, err := Parse([]byte(tc.config)); err == nil {
+			t.Errorf("%s: expected Parse to fail but got no error", tc.description)
+		}
+	}
+}
+
+func TestSharedResource(t *testing.T) {
+	cfg, err := Parse([]byte(`
+version: v1
+sharing:
+  timeSlicing:
+    resources:
+      - name: gpu
+        replicas: 4
+        rename: gpu.shared
+      - name: cpx_nps4
+        replicas: 2
+`))
+	if err != nil {
+		t.Fatalf("expected config to parse. But failed with error %v", err)
+	}
+	testcases := []struct {
+		resource   string
+		advertised string
+		replicas   int
+	}{
+		{"gpu", "gpu.shared", 4},
+		{"cpx_nps4", "cpx_nps4", 2},
+		{"spx_nps1", "spx_nps1", 0},
+	}
+	for _, tc := range testcases {
+		if name := cfg.AdvertisedName(tc.resource); name != tc.advertised {
+			t.Errorf("Advertised name of %s was incorrect, got: %s, want: %s", tc.resource, name, tc.advertised)
+		}
+		r := cfg.SharedResource(tc.advertised)
+		if tc.replicas == 0 {
+			if r != nil {
+				t.Errorf("Resource %s should not be shared, got: %+v", tc.advertised, r)
+			}
+			continue
+		}
+		if r == nil || r.Name != tc.resource || r.Replicas != tc.replicas {
+			t.Errorf("Shared resource %s was incorrect, got: %+v", tc.advertised, r)
+		}
+	}
+	if cfg.SharedResource("gpu") != nil {
+		t.Errorf("Renamed resource gpu should not be advertised under its own name")
+	}
+}
+
+func TestAllocatorPolicy(t *testing.T) {
+	cfg, err := Parse([]byte(`
+version: v1
+allocator:
+  policy: spread
+  resources:
+    cpx_nps4: packed
+    gpu: none
+`))
+	if err != nil {
+		t.Fatalf("expected config to parse. But failed with error %v", err)
+	}
+	for resource, policy := range map[string]string{"cpx_nps4": PolicyPacked, "gpu": PolicyNone, "cpx_nps1": PolicySpread} {
+		if got := cfg.AllocatorPolicy(resource); got != policy {
+			t.Errorf("Allocator policy of %s was incorrect, got: %s, want: %s", resource, got, policy)
+		}
+	}
+}
+
+func TestAllocatorWeights(t *testing.T) {
+	cfg, err := Parse([]byte(`
+version: v1
+allocator:
+  weights:
+    differentNUMANode: 100
+    links:
+      11: 0
+      15: 30
+`))
+	if err != nil {
+		t.Fatalf("expected config to parse. But failed with error %v", err)
+	}
+	w := cfg.Allocator.Weights
+	// weights absent from the file keep their defaults
+	if w.DifferentNUMANode != 100 || w.SameNUMANode != 10 || w.OtherLink != 50 {
+		t.Errorf("Allocator weights were incorrect, got: %+v", w)
+	}
+	if len(w.Links) != 3 || w.Links[LinkTypeXGMI] != 0 || w.Links[LinkTypePCIe] != 40 || w.Links[15] != 30 {
+		t.Errorf("Allocator link weights were incorrect, got: %v", w.Links)
+	}
+}
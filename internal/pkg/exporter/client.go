@@ -0,0 +1,328 @@
+/**
+# Copyright (c) Advanced Micro Devices, Inc. All rights reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the \"License\");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an \"AS IS\" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+**/
+
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/amdgpu"
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/exporter/metricssvc"
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/metrics"
+	"github.com/golang/glog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+// inventoryRefresh is the number of polls after which the GPUs known to
+// the exporter are listed again
+const inventoryRefresh = 12
+
+// DeviceState is the state the exporter reports for a device of the plugin
+type DeviceState struct {
+	Health string
+	// AssociatedWorkload lists the workloads the exporter sees using the
+	// device
+	AssociatedWorkload []string
+}
+
+func (s DeviceState) equal(o DeviceState) bool {
+	return s.Health == o.Health && slices.Equal(s.AssociatedWorkload, o.AssociatedWorkload)
+}
+
+// Client keeps a connection to the amd-metrics-exporter health service
+// open and caches the health it reports. The exporter can come and go
+// independently of the plugin, the connection is re-established
+// transparently when it comes back. The health service has no streaming
+// RPC, the client queries it over the connection, either every interval or
+// on every Refresh.
+type Client struct {
+	socket     string
+	interval   time.Duration
+	staleAfter time.Duration
+
+	conn   *grpc.ClientConn
+	client metricssvc.MetricsServiceClient
+
+	mu sync.RWMutex
+	// devices holds the devices advertised by every resource
+	devices map[string]map[string]*amdgpu.GPU
+	// inventory holds the GPUs known to the exporter as last listed, polls
+	// counts the polls since
+	inventory []*metricssvc.GPUState
+	polls     int
+	// listOnly is set if the exporter does not implement GetGPUState
+	listOnly bool
+	// states maps the device IDs to their state as last reported
+	states map[string]DeviceState
+	// updated is when the exporter last answered
+	updated     time.Time
+	stale       bool
+	subscribers []chan struct{}
+}
+
+// NewClient returns a client of the health service listening on socket,
+// querying it every interval once running, or only when refreshed if
+// interval is 0. The health is stale, and ignored, if the exporter has not
+// answered for staleAfter.
+func NewClient(socket string, interval, staleAfter time.Duration) (*Client, error) {
+	conn, err := grpc.NewClient(fmt.Sprintf("unix://%v", socket),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		socket:     socket,
+		interval:   interval,
+		staleAfter: staleAfter,
+		conn:       conn,
+		client:     metricssvc.NewMetricsServiceClient(conn),
+		stale:      true,
+	}, nil
+}
+
+// Subscribe returns a channel receiving a notification every time the
+// health reported by the exporter changes, or becomes stale. Notifications
+// are coalesced.
+func (c *Client) Subscribe() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan struct{}, 1)
+	c.subscribers = append(c.subscribers, ch)
+	return ch
+}
+
+// Unsubscribe stops notifications on a channel returned by Subscribe
+func (c *Client) Unsubscribe(ch <-chan struct{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, sub := range c.subscribers {
+		if sub == ch {
+			c.subscribers = append(c.subscribers[:i], c.subscribers[i+1:]...)
+			return
+		}
+	}
+}
+
+// notify must be called with mu held
+func (c *Client) notify() {
+	for _, sub := range c.subscribers {
+		select {
+		case sub <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// SetDevices sets the devices advertised for a resource, keyed by their
+// IDs. Only the state of the GPUs backing them is queried.
+func (c *Client) SetDevices(resource string, gpus map[string]*amdgpu.GPU) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.devices == nil {
+		c.devices = make(map[string]map[string]*amdgpu.GPU)
+	}
+	c.devices[resource] = gpus
+	// the GPUs may have changed along with the devices
+	c.inventory = nil
+}
+
+// Health returns the state of the devices as last reported by the
+// exporter, and false if it is stale or there is no client
+func (c *Client) Health() (map[string]DeviceState, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.stale {
+		return nil, false
+	}
+	return c.states, true
+}
+
+// State returns the state of a device as last reported by the exporter
+func (c *Client) State(id string) (DeviceState, bool) {
+	states, ok := c.Health()
+	if !ok {
+		return DeviceState{}, false
+	}
+	state, ok := states[id]
+	return state, ok
+}
+
+// matches returns true if the exporter state describes the GPU, or the GPU
+// a partition belongs to. The exporter identifies GPUs by the PCI address
+// in Device, and by a UUID derived from their unique_id.
+func matches(state *metricssvc.GPUState, gpu *amdgpu.GPU) bool {
+	if state.Device != "" && (state.Device == gpu.PciAddress || state.Device == gpu.Id) {
+		return true
+	}
+	return uuidMatches(state.UUID, gpu.UniqueId)
+}
+
+// query returns the state of the GPUs backing the advertised devices
+func (c *Client) query(ctx context.Context) ([]*metricssvc.GPUState, error) {
+	c.mu.Lock()
+	refresh := c.inventory == nil || c.listOnly || c.polls >= inventoryRefresh
+	var ids []string
+	if !refresh {
+		c.polls++
+		for _, state := range c.inventory {
+			if c.advertised(state) {
+				ids = append(ids, state.ID)
+			}
+		}
+	}
+	c.mu.Unlock()
+
+	if !refresh {
+		if len(ids) == 0 {
+			return nil, nil
+		}
+		resp, err := c.client.GetGPUState(ctx, &metricssvc.GPUGetRequest{ID: ids})
+		if status.Code(err) == codes.Unimplemented {
+			glog.Infof("amd-metrics-exporter does not implement GetGPUState, listing all GPUs instead")
+			c.mu.Lock()
+			c.listOnly = true
+			c.mu.Unlock()
+		} else if err != nil {
+			return nil, err
+		} else {
+			return resp.GPUState, nil
+		}
+	}
+
+	resp, err := c.client.List(ctx, &emptypb.Empty{})
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.inventory = resp.GPUState
+	c.polls = 0
+	c.mu.Unlock()
+	return resp.GPUState, nil
+}
+
+// advertised returns true if the exporter state describes the GPU of an
+// advertised device. It must be called with mu held.
+func (c *Client) advertised(state *metricssvc.GPUState) bool {
+	for _, gpus := range c.devices {
+		for _, gpu := range gpus {
+			if matches(state, gpu) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// deviceStates maps the exporter states to the advertised devices. A device
+// is unhealthy if the state of its GPU or of any of its partitions is. It
+// must be called with mu held.
+func (c *Client) deviceStates(gpuStates []*metricssvc.GPUState) map[string]DeviceState {
+	states := make(map[string]DeviceState)
+	for _, gpus := range c.devices {
+		for id, gpu := range gpus {
+			for _, gpuState := range gpuStates {
+				if !matches(gpuState, gpu) {
+					continue
+				}
+				state, ok := states[id]
+				if !ok || state.Health != pluginapi.Unhealthy {
+					state.Health = health(gpuState)
+				}
+				for _, workload := range gpuState.AssociatedWorkload {
+					if !slices.Contains(state.AssociatedWorkload, workload) {
+						state.AssociatedWorkload = append(state.AssociatedWorkload, workload)
+					}
+				}
+				states[id] = state
+			}
+		}
+	}
+	for id, state := range states {
+		slices.Sort(state.AssociatedWorkload)
+		states[id] = state
+	}
+	return states
+}
+
+// poll queries the exporter once and updates the cached states
+func (c *Client) poll() {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+	gpuStates, err := c.query(ctx)
+	metrics.SetExporterReachable(err == nil)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		glog.V(3).Infof("Error getting health info from %s: %v", c.socket, err)
+		// the exporter may come back with other GPU IDs
+		c.inventory = nil
+		if !c.stale && time.Since(c.updated) > c.staleAfter {
+			glog.Warningf("amd-metrics-exporter has not answered since %v, ignoring its health", c.updated.Format(time.RFC3339))
+			c.stale = true
+			c.states = nil
+			c.notify()
+		}
+		return
+	}
+	c.updated = time.Now()
+	states := c.deviceStates(gpuStates)
+	if c.stale || !maps.EqualFunc(states, c.states, DeviceState.equal) {
+		if c.stale {
+			glog.Infof("Receiving health from amd-metrics-exporter at %s", c.socket)
+		}
+		c.stale = false
+		c.states = states
+		c.notify()
+	}
+}
+
+// Refresh queries the exporter if the client doesn't query it on its own
+func (c *Client) Refresh() {
+	if c == nil || c.interval > 0 {
+		return
+	}
+	c.poll()
+}
+
+// Run queries the exporter every interval until stop is closed
+func (c *Client) Run(stop <-chan struct{}) {
+	defer c.conn.Close()
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		c.poll()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,231 @@
+/**
+# Copyright (c) Advanced Micro Devices, Inc. All rights reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the \"License\");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an \"AS IS\" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+**/
+
+package exporter
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/amdgpu"
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/exporter/metricssvc"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+type fakeMetricsService struct {
+	metricssvc.UnimplementedMetricsServiceServer
+	mu    sync.Mutex
+	state []*metricssvc.GPUState
+	// queried holds the IDs of the last GetGPUState call
+	queried []string
+}
+
+func (s *fakeMetricsService) setHealth(device, health string, workloads ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = []*metricssvc.GPUState{
+		{ID: "0", Device: device, Health: health, AssociatedWorkload: workloads},
+		// not advertised by the plugin
+		{ID: "1", Device: "0000:c1:00.0", Health: "unhealthy"},
+	}
+}
+
+func (s *fakeMetricsService) List(context.Context, *emptypb.Empty) (*metricssvc.GPUStateResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &metricssvc.GPUStateResponse{GPUState: s.state}, nil
+}
+
+func (s *fakeMetricsService) GetGPUState(_ context.Context, req *metricssvc.GPUGetRequest) (*metricssvc.GPUStateResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queried = req.ID
+	resp := &metricssvc.GPUStateResponse{}
+	for _, state := range s.state {
+		if slices.Contains(req.ID, state.ID) {
+			resp.GPUState = append(resp.GPUState, state)
+		}
+	}
+	return resp, nil
+}
+
+// serve starts the fake service on socket and returns a function stopping it
+func serve(t *testing.T, socket string, svc *fakeMetricsService) func() {
+	t.Helper()
+	lis, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	metricssvc.RegisterMetricsServiceServer(server, svc)
+	go server.Serve(lis)
+	return server.Stop
+}
+
+func TestClient(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "exporter.socket")
+	c, err := NewClient(socket, 10*time.Millisecond, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updates := c.Subscribe()
+	c.SetDevices("gpu", map[string]*amdgpu.GPU{
+		"0000:0a:00.0": {Id: "0000:0a:00.0", PciAddress: "0000:0a:00.0"},
+		"0000:80:00.0": {Id: "0000:80:00.0", PciAddress: "0000:80:00.0"},
+	})
+
+	// the exporter is not running yet
+	c.poll()
+	if _, ok := c.Health(); ok {
+		t.Errorf("Health was not stale without the exporter")
+	}
+
+	svc := &fakeMetricsService{}
+	svc.setHealth("0000:0a:00.0", "unhealthy")
+	stopServer := serve(t, socket, svc)
+	stop := make(chan struct{})
+	defer close(stop)
+	go c.Run(stop)
+
+	expectUpdate := func(description string) {
+		t.Helper()
+		select {
+		case <-updates:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("No notification %s", description)
+		}
+	}
+	expectUpdate("after the exporter started")
+	devs := []*pluginapi.Device{{ID: "0000:0a:00.0::1"}, {ID: "0000:80:00.0"}}
+	c.PopulatePerGPUDHealth(devs, pluginapi.Healthy)
+	if devs[0].Health != pluginapi.Unhealthy || devs[1].Health != pluginapi.Healthy {
+		t.Errorf("Health was incorrect, got: %s %s", devs[0].Health, devs[1].Health)
+	}
+
+	svc.setHealth("0000:0a:00.0", "healthy")
+	expectUpdate("after the health changed")
+	c.PopulatePerGPUDHealth(devs, pluginapi.Healthy)
+	if devs[0].Health != pluginapi.Healthy {
+		t.Errorf("Health was not updated, got: %s", devs[0].Health)
+	}
+
+	// the health becomes stale once the exporter is gone
+	stopServer()
+	expectUpdate("after the exporter stopped")
+	if _, ok := c.Health(); ok {
+		t.Errorf("Health was not stale after the exporter stopped")
+	}
+
+	// and is picked up again when it comes back on the same socket
+	svc.setHealth("0000:0a:00.0", "unhealthy")
+	defer serve(t, socket, svc)()
+	expectUpdate("after the exporter restarted")
+	if state, ok := c.State("0000:0a:00.0"); !ok || state.Health != pluginapi.Unhealthy {
+		t.Errorf("Health was incorrect after the exporter restarted, got: %v %v", state, ok)
+	}
+
+	// once listed, only the advertised GPUs are queried
+	c.poll()
+	svc.mu.Lock()
+	queried := svc.queried
+	svc.mu.Unlock()
+	if !slices.Equal(queried, []string{"0"}) {
+		t.Errorf("Queried GPUs were incorrect, got: %v", queried)
+	}
+	if _, ok := c.State("0000:c1:00.0"); ok {
+		t.Errorf("State of a GPU that is not advertised was kept")
+	}
+}
+
+func TestClientRefresh(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "exporter.socket")
+	svc := &fakeMetricsService{}
+	svc.setHealth("0000:0a:00.0", "unhealthy")
+	defer serve(t, socket, svc)()
+
+	// without a poll interval, the exporter is only queried when refreshed
+	c, err := NewClient(socket, 0, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetDevices("gpu", map[string]*amdgpu.GPU{
+		"0000:0a:00.0": {Id: "0000:0a:00.0", PciAddress: "0000:0a:00.0"},
+	})
+	if _, ok := c.Health(); ok {
+		t.Errorf("Health was known before the exporter was queried")
+	}
+	c.Refresh()
+	if state, ok := c.State("0000:0a:00.0"); !ok || state.Health != pluginapi.Unhealthy {
+		t.Errorf("Health was incorrect after a refresh, got: %v %v", state, ok)
+	}
+
+	// a polling client ignores the refreshes
+	polling, err := NewClient(socket, time.Hour, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	polling.SetDevices("gpu", map[string]*amdgpu.GPU{
+		"0000:0a:00.0": {Id: "0000:0a:00.0", PciAddress: "0000:0a:00.0"},
+	})
+	polling.Refresh()
+	if _, ok := polling.Health(); ok {
+		t.Errorf("Polling client was queried on a refresh")
+	}
+	// as does no client
+	var none *Client
+	none.Refresh()
+}
+
+func TestPartitionStates(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "exporter.socket")
+	c, err := NewClient(socket, time.Second, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := &fakeMetricsService{}
+	svc.setHealth("0000:0a:00.0", "unhealthy", "default/train-0")
+	defer serve(t, socket, svc)()
+
+	// partitions are identified by their platform device, and match the
+	// state of their GPU by PCI address or UUID
+	c.SetDevices("cpx_nps1", map[string]*amdgpu.GPU{
+		"amdgpu_xcp_1": {Id: "amdgpu_xcp_1", PciAddress: "0000:0a:00.0", ParentId: "0000:0a:00.0"},
+		"amdgpu_xcp_2": {Id: "amdgpu_xcp_2", PciAddress: "0000:0a:00.0", ParentId: "0000:0a:00.0"},
+		"amdgpu_xcp_9": {Id: "amdgpu_xcp_9", PciAddress: "0000:80:00.0", ParentId: "0000:80:00.0", UniqueId: "0x51271fde4c8f3e2d"},
+	})
+	svc.mu.Lock()
+	svc.state = append(svc.state, &metricssvc.GPUState{ID: "2", UUID: "5fff74a1-0000-1000-801f-de4c8f3e2d00", Health: "healthy"},
+		&metricssvc.GPUState{ID: "3", UUID: "5fff74a1-0000-1000-801f-1fde4c8f3e2d", Health: "unhealthy", AssociatedWorkload: []string{"ml/infer-1"}})
+	svc.mu.Unlock()
+
+	c.poll()
+	for id, exp := range map[string]DeviceState{
+		"amdgpu_xcp_1": {Health: pluginapi.Unhealthy, AssociatedWorkload: []string{"default/train-0"}},
+		"amdgpu_xcp_2": {Health: pluginapi.Unhealthy, AssociatedWorkload: []string{"default/train-0"}},
+		"amdgpu_xcp_9": {Health: pluginapi.Unhealthy, AssociatedWorkload: []string{"ml/infer-1"}},
+	} {
+		if state, ok := c.State(id); !ok || !state.equal(exp) {
+			t.Errorf("State of %s was incorrect, got: %+v, want: %+v", id, state, exp)
+		}
+	}
+}
@@ -14,93 +14,82 @@
 # limitations under the License.
 **/
 
-// Package health is a collection of utility to access health exporter grpc service 
+// Package health is a collection of utility to access health exporter grpc service
 // hosted by amd-metrics-exporter service
 package exporter
 
 import (
-    "context"
-    "fmt"
-    "os"
-    "strings"
-    "time"
-    "github.com/ROCm/k8s-device-plugin/internal/pkg/exporter/metricssvc"
-    "google.golang.org/grpc"
-    "google.golang.org/grpc/credentials/insecure"
-    "google.golang.org/protobuf/types/known/emptypb"
-    "github.com/golang/glog"
-    pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/exporter/metricssvc"
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/replica"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
 )
 
 const (
-    healthSocket = "/var/lib/amd-metrics-exporter/amdgpu_device_metrics_exporter_grpc.socket"
-    queryTimeout    = 5 * time.Second
+	queryTimeout = 5 * time.Second
 )
 
-// getGPUHealth returns device id map with health state if the metrics service
-// is available else returns error
-func getGPUHealth() (hMap map[string]string, err error) {
-    // if the exporter service is not available done proceed
-    healthSvcAddress := fmt.Sprintf("unix://%v", healthSocket)
-    if _, err = os.Stat(healthSocket); err != nil {
-        return
-    }
-
-    hMap = make(map[string]string)
-
-    // the connection is short lived as the exporter can come and go
-    // independently
-    conn, err := grpc.Dial(healthSvcAddress,
-        grpc.WithTransportCredentials(insecure.NewCredentials()),
-    )
-    if err != nil {
-        glog.Errorf("Error opening client metrics svc : %v", err)
-        return
-    }
-
-    // create a new gRPC echo client through the compiled stub
-    client := metricssvc.NewMetricsServiceClient(conn)
-
-    defer conn.Close()
+// health returns the device plugin health of a GPU state
+func health(gpu *metricssvc.GPUState) string {
+	if gpu.Health == strings.ToLower(pluginapi.Unhealthy) {
+		return pluginapi.Unhealthy
+	}
+	return pluginapi.Healthy
+}
 
-    ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
-    defer cancel()
+// uuidMatches returns true if uuid, as reported by the exporter, identifies
+// the GPU with the given sysfs unique_id: either it is the unique_id itself,
+// or its last group holds the low 48 bits of the unique_id in hex.
+func uuidMatches(uuid string, uniqueId string) bool {
+	if uuid == "" || uniqueId == "" {
+		return false
+	}
+	uuid = strings.ToLower(strings.TrimPrefix(uuid, "GPU-"))
+	id := uniqueIdHex(uniqueId)
+	if uuid == id {
+		return true
+	}
+	groups := strings.Split(uuid, "-")
+	last := groups[len(groups)-1]
+	if len(last) != 12 {
+		return false
+	}
+	id = strings.Repeat("0", 16) + id
+	return last == id[len(id)-12:]
+}
 
-    resp, err := client.List(ctx, &emptypb.Empty{})
-    if err != nil {
-        glog.Errorf("Error getting health info svc : %v", err)
-        return
-    }
-    for _, gpu := range resp.GPUState {
-        if gpu.Health == strings.ToLower(pluginapi.Unhealthy) {
-            hMap[gpu.Device] = pluginapi.Unhealthy
-        } else {
-            hMap[gpu.Device] = pluginapi.Healthy
-        }
-    }
-    return
+// uniqueIdHex returns the unique_id, decimal or hex, in hex
+func uniqueIdHex(uniqueId string) string {
+	if v, err := strconv.ParseUint(uniqueId, 0, 64); err == nil {
+		return strconv.FormatUint(v, 16)
+	}
+	return strings.ToLower(strings.TrimPrefix(uniqueId, "0x"))
 }
 
 // PopulatePerGPUDHealth populate the per gpu health status if available,
 // else return simple health status
-func PopulatePerGPUDHealth(devs []*pluginapi.Device, defaultHealth string) {
-    var hasHealthSvc = false
-    hMap, err := getGPUHealth()
-    if err == nil {
-        hasHealthSvc = true
-    }
+func (c *Client) PopulatePerGPUDHealth(devs []*pluginapi.Device, defaultHealth string) {
+	var hasHealthSvc = false
+	var states map[string]DeviceState
+	if c != nil {
+		states, hasHealthSvc = c.Health()
+	}
 
-    for i := 0; i < len(devs); i++ {
-        if !hasHealthSvc {
-            devs[i].Health = defaultHealth
-        } else {
-            // only use if we have the device id entry
-            if gpuHealth, ok := hMap[devs[i].ID]; ok {
-                devs[i].Health = gpuHealth
-            } else {
-                // revert to simpleHealthCheck if not found
-                devs[i].Health = defaultHealth
-            }
-        }
-    }
+	for i := 0; i < len(devs); i++ {
+		if !hasHealthSvc {
+			devs[i].Health = defaultHealth
+		} else {
+			// only use if we have the device id entry, replicas of
+			// time-sliced devices share the health of the device
+			if state, ok := states[replica.PhysicalID(devs[i].ID)]; ok {
+				devs[i].Health = state.Health
+			} else {
+				// revert to the default health if not found
+				devs[i].Health = defaultHealth
+			}
+		}
+	}
 }
@@ -0,0 +1,289 @@
+/**
+ * Copyright 2025 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+// Package health checks the health of individual AMD GPUs and compute
+// partitions through sysfs and the device nodes, so that only the affected
+// devices are reported as unhealthy to the kubelet.
+package health
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/amdgpu"
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/config"
+	"github.com/golang/glog"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+// Check verifies one aspect of the health of a device. It returns an error
+// describing the problem if the device is unhealthy.
+type Check interface {
+	Name() string
+	Check(gpu *amdgpu.GPU) error
+}
+
+// gpuCheck is implemented by the checks whose verdict applies to a whole
+// GPU. They are run once per GPU and their result is shared by all the
+// partitions on the same devID.
+type gpuCheck interface {
+	perGPU() bool
+}
+
+func isPerGPU(c Check) bool {
+	g, ok := c.(gpuCheck)
+	return ok && g.perGPU()
+}
+
+// gpuKey identifies the GPU a device belongs to
+func gpuKey(gpu *amdgpu.GPU) string {
+	if gpu.DevId != "" {
+		return gpu.DevId
+	}
+	return gpu.PciAddress
+}
+
+type checkFunc struct {
+	name  string
+	fn    func(gpu *amdgpu.GPU) error
+	byGPU bool
+}
+
+func (c *checkFunc) Name() string                { return c.name }
+func (c *checkFunc) Check(gpu *amdgpu.GPU) error { return c.fn(gpu) }
+func (c *checkFunc) perGPU() bool                { return c.byGPU }
+
+// NewCheck returns a check run on every device from a function
+func NewCheck(name string, fn func(gpu *amdgpu.GPU) error) Check {
+	return &checkFunc{name: name, fn: fn}
+}
+
+// NewGPUCheck returns a check run once per GPU from a function, its result
+// applies to all the partitions of the GPU
+func NewGPUCheck(name string, fn func(gpu *amdgpu.GPU) error) Check {
+	return &checkFunc{name: name, fn: fn, byGPU: true}
+}
+
+// Status is the health of a device and, if unhealthy, why
+type Status struct {
+	Health string
+	Reason string
+	// Source lists the checks that failed, separated by commas
+	Source string
+}
+
+// Healthy returns true if the device is healthy
+func (s Status) Healthy() bool {
+	return s.Health == pluginapi.Healthy
+}
+
+// Checker runs a set of checks on devices and keeps their last status
+type Checker struct {
+	checks   []Check
+	mu       sync.Mutex
+	statuses map[string]Status
+}
+
+// NewChecker returns a checker running the given checks
+func NewChecker(checks ...Check) *Checker {
+	return &Checker{
+		checks:   checks,
+		statuses: make(map[string]Status),
+	}
+}
+
+// New returns a checker running the checks enabled in the configuration
+func New(cfg config.HealthConfig) *Checker {
+	var checks []Check
+	for _, name := range cfg.Checks {
+		switch name {
+		case config.HealthCheckDRM:
+			checks = append(checks, NewCheck(name, CheckDRMNode))
+		case config.HealthCheckKFD:
+			checks = append(checks, NewCheck(name, CheckKFDNode))
+		case config.HealthCheckPCILink:
+			checks = append(checks, NewGPUCheck(name, CheckPCILinkUp))
+		case config.HealthCheckRAS:
+			checks = append(checks, NewRASCheck(cfg.RAS))
+		default:
+			glog.Errorf("Ignoring unknown health check %q", name)
+		}
+	}
+	return NewChecker(checks...)
+}
+
+// CheckDevices runs the checks on every device and returns their status
+// keyed by device ID. Changes of status are logged.
+func (c *Checker) CheckDevices(gpus map[string]*amdgpu.GPU) map[string]Status {
+	statuses := make(map[string]Status, len(gpus))
+	// results of the per GPU checks keyed by check and GPU
+	gpuResults := make(map[string]error)
+	for id, gpu := range gpus {
+		status := Status{Health: pluginapi.Healthy}
+		var reasons, failed []string
+		for _, check := range c.checks {
+			var err error
+			if isPerGPU(check) {
+				key := check.Name() + "/" + gpuKey(gpu)
+				var done bool
+				if err, done = gpuResults[key]; !done {
+					err = check.Check(gpu)
+					gpuResults[key] = err
+				}
+			} else {
+				err = check.Check(gpu)
+			}
+			if err != nil {
+				reasons = append(reasons, fmt.Sprintf("%s: %v", check.Name(), err))
+				failed = append(failed, check.Name())
+			}
+		}
+		if len(reasons) > 0 {
+			status = Status{
+				Health: pluginapi.Unhealthy,
+				Reason: strings.Join(reasons, "; "),
+				Source: strings.Join(failed, ","),
+			}
+		}
+		statuses[id] = status
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ids := make([]string, 0, len(statuses))
+	for id := range statuses {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		status := statuses[id]
+		last, known := c.statuses[id]
+		if (known && last == status) || (!known && status.Healthy()) {
+			continue
+		}
+		if status.Healthy() {
+			glog.Infof("Device %s is healthy again", id)
+		} else {
+			glog.Warningf("Device %s is unhealthy: %s", id, status.Reason)
+		}
+	}
+	c.statuses = statuses
+	return statuses
+}
+
+// Statuses returns the last status of every device checked
+func (c *Checker) Statuses() map[string]Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res := make(map[string]Status, len(c.statuses))
+	for id, status := range c.statuses {
+		res[id] = status
+	}
+	return res
+}
+
+// CheckDRMNode verifies that the render node of a device can be opened
+func CheckDRMNode(gpu *amdgpu.GPU) error {
+	path := amdgpu.DevfsPath("dri", fmt.Sprintf("renderD%d", gpu.RenderD))
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("unable to open %s: %v", path, err)
+	}
+	f.Close()
+	return nil
+}
+
+var topoDrmRenderMinorRe = regexp.MustCompile(`drm_render_minor\s(\d+)`)
+
+// CheckKFDNode verifies that the KFD topology node of a device is present
+// and still backed by its render node
+func CheckKFDNode(gpu *amdgpu.GPU) error {
+	props := amdgpu.SysfsPath("class/kfd/kfd/topology/nodes", strconv.Itoa(gpu.NodeId), "properties")
+	minor, err := amdgpu.ParseTopologyProperties(props, topoDrmRenderMinorRe)
+	if err != nil {
+		return fmt.Errorf("KFD node %d not found: %v", gpu.NodeId, err)
+	}
+	if int(minor) != gpu.RenderD {
+		return fmt.Errorf("KFD node %d belongs to renderD%d instead of renderD%d", gpu.NodeId, minor, gpu.RenderD)
+	}
+	return nil
+}
+
+// CheckPCILinkUp verifies that the GPU of a device is still on the PCI bus
+// with its link up
+func CheckPCILinkUp(gpu *amdgpu.GPU) error {
+	path := amdgpu.PciDevicePath(gpu.PciAddress)
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("GPU %s is no longer bound to amdgpu: %v", gpu.PciAddress, err)
+	}
+	data, err := os.ReadFile(filepath.Join(path, "current_link_width"))
+	if err != nil {
+		// not reported by every platform, ex: integrated GPUs
+		return nil
+	}
+	if width, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil && width == 0 {
+		return fmt.Errorf("PCI link of GPU %s is down", gpu.PciAddress)
+	}
+	return nil
+}
+
+// ErrorCount is the number of RAS errors reported by a block of a GPU
+type ErrorCount struct {
+	Uncorrectable int64
+	Correctable   int64
+}
+
+// ReadRASErrorCounts reads the RAS error counts of a GPU keyed by block,
+// ex: umc, gfx or sdma, from ras/<block>_err_count
+func ReadRASErrorCounts(pciAddress string) (map[string]ErrorCount, error) {
+	files, _ := filepath.Glob(filepath.Join(amdgpu.PciDevicePath(pciAddress), "ras", "*_err_count"))
+	if len(files) == 0 {
+		return nil, fmt.Errorf("RAS error counts are not available for GPU %s", pciAddress)
+	}
+	counts := make(map[string]ErrorCount, len(files))
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			glog.Warningf("Unable to read %s: %v", file, err)
+			continue
+		}
+		var count ErrorCount
+		for _, line := range strings.Split(string(data), "\n") {
+			name, value, ok := strings.Cut(line, ":")
+			if !ok {
+				continue
+			}
+			v, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+			if err != nil {
+				continue
+			}
+			switch strings.TrimSpace(name) {
+			case "ue":
+				count.Uncorrectable = v
+			case "ce":
+				count.Correctable = v
+			}
+		}
+		counts[strings.TrimSuffix(filepath.Base(file), "_err_count")] = count
+	}
+	return counts, nil
+}
@@ -0,0 +1,220 @@
+/**
+ * Copyright 2025 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package health
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/amdgpu"
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/config"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newFakeNode creates a sysfs and devfs with two GPUs, the second one split
+// in two partitions, and returns their devices
+func newFakeNode(t *testing.T) map[string]*amdgpu.GPU {
+	t.Helper()
+	amdgpu.SysfsRoot = t.TempDir()
+	amdgpu.DevfsRoot = t.TempDir()
+
+	gpus := map[string]*amdgpu.GPU{
+		"0000:0a:00.0": {Id: "0000:0a:00.0", PciAddress: "0000:0a:00.0", DevId: "0000:0a:00:0", RenderD: 128, NodeId: 2},
+		"0000:80:00.0": {Id: "0000:80:00.0", PciAddress: "0000:80:00.0", DevId: "0000:80:00:0", RenderD: 129, NodeId: 3},
+		"amdgpu_xcp_1": {Id: "amdgpu_xcp_1", ParentId: "0000:80:00.0", PciAddress: "0000:80:00.0", DevId: "0000:80:00:0", RenderD: 130, NodeId: 4},
+	}
+	for _, gpu := range gpus {
+		writeFile(t, amdgpu.DevfsPath("dri", "renderD"+strconv.Itoa(gpu.RenderD)), "")
+		writeFile(t, amdgpu.SysfsPath("class/kfd/kfd/topology/nodes", strconv.Itoa(gpu.NodeId), "properties"),
+			"gpu_id 1234\ndrm_render_minor "+strconv.Itoa(gpu.RenderD)+"\n")
+		if !gpu.IsPartition() {
+			path := amdgpu.PciDevicePath(gpu.PciAddress)
+			writeFile(t, filepath.Join(path, "current_link_width"), "16\n")
+			writeFile(t, filepath.Join(path, "ras", "umc_err_count"), "ue: 0\nce: 3\n")
+			writeFile(t, filepath.Join(path, "ras", "gfx_err_count"), "ue: 0\nce: 0\n")
+		}
+	}
+	return gpus
+}
+
+func TestCheckDevices(t *testing.T) {
+	defer func(sysfs, devfs string) { amdgpu.SysfsRoot, amdgpu.DevfsRoot = sysfs, devfs }(amdgpu.SysfsRoot, amdgpu.DevfsRoot)
+
+	testcases := []struct {
+		description  string
+		breakNode    func(t *testing.T)
+		expUnhealthy map[string]string
+	}{
+		{"all healthy", func(t *testing.T) {}, map[string]string{}},
+		{"render node missing", func(t *testing.T) {
+			os.Remove(amdgpu.DevfsPath("dri", "renderD128"))
+		}, map[string]string{"0000:0a:00.0": "drm: unable to open"}},
+		{"kfd node of a partition missing", func(t *testing.T) {
+			os.RemoveAll(amdgpu.SysfsPath("class/kfd/kfd/topology/nodes/4"))
+		}, map[string]string{"amdgpu_xcp_1": "kfd: KFD node 4 not found"}},
+		{"kfd node renumbered", func(t *testing.T) {
+			writeFile(t, amdgpu.SysfsPath("class/kfd/kfd/topology/nodes/2/properties"), "drm_render_minor 131\n")
+		}, map[string]string{"0000:0a:00.0": "kfd: KFD node 2 belongs to renderD131"}},
+		{"pci link down", func(t *testing.T) {
+			writeFile(t, filepath.Join(amdgpu.PciDevicePath("0000:80:00.0"), "current_link_width"), "0\n")
+		}, map[string]string{"0000:80:00.0": "pciLink: PCI link", "amdgpu_xcp_1": "pciLink: PCI link"}},
+		{"gpu unbound", func(t *testing.T) {
+			os.RemoveAll(amdgpu.PciDevicePath("0000:0a:00.0"))
+		}, map[string]string{"0000:0a:00.0": "pciLink: GPU 0000:0a:00.0 is no longer bound"}},
+		{"uncorrectable errors before the plugin started", func(t *testing.T) {
+			writeFile(t, filepath.Join(amdgpu.PciDevicePath("0000:80:00.0"), "ras", "umc_err_count"), "ue: 2\nce: 3\n")
+		}, map[string]string{}},
+	}
+	for _, tc := range testcases {
+		gpus := newFakeNode(t)
+		tc.breakNode(t)
+		cfg := config.Default().Health
+		cfg.Checks = append(cfg.Checks, config.HealthCheckDRM)
+		checker := New(cfg)
+		statuses := checker.CheckDevices(gpus)
+		if len(statuses) != len(gpus) {
+			t.Errorf("%s: expected a status per device, got: %v", tc.description, statuses)
+		}
+		for id, status := range statuses {
+			reason, unhealthy := tc.expUnhealthy[id]
+			if status.Healthy() == unhealthy {
+				t.Errorf("%s: health of %s was incorrect, got: %+v", tc.description, id, status)
+				continue
+			}
+			if unhealthy && !strings.HasPrefix(status.Reason, reason) {
+				t.Errorf("%s: reason of %s was incorrect, got: %s, want: %s", tc.description, id, status.Reason, reason)
+			}
+			if source, _, _ := strings.Cut(reason, ":"); status.Source != source {
+				t.Errorf("%s: source of %s was incorrect, got: %s, want: %s", tc.description, id, status.Source, source)
+			}
+		}
+		if last := checker.Statuses(); len(last) != len(statuses) {
+			t.Errorf("%s: last statuses were not kept, got: %v", tc.description, last)
+		}
+	}
+}
+
+func TestDefaultChecksWithoutDevfs(t *testing.T) {
+	defer func(sysfs, devfs string) { amdgpu.SysfsRoot, amdgpu.DevfsRoot = sysfs, devfs }(amdgpu.SysfsRoot, amdgpu.DevfsRoot)
+	gpus := newFakeNode(t)
+	// /dev isn't mounted in the container by default
+	amdgpu.DevfsRoot = t.TempDir()
+	for id, status := range New(config.Default().Health).CheckDevices(gpus) {
+		if !status.Healthy() {
+			t.Errorf("expected %s to be healthy without /dev/dri, got: %+v", id, status)
+		}
+	}
+}
+
+func TestRASThresholds(t *testing.T) {
+	defer func(sysfs, devfs string) { amdgpu.SysfsRoot, amdgpu.DevfsRoot = sysfs, devfs }(amdgpu.SysfsRoot, amdgpu.DevfsRoot)
+	gpus := newFakeNode(t)
+	umc := filepath.Join(amdgpu.PciDevicePath("0000:80:00.0"), "ras", "umc_err_count")
+
+	now := time.Unix(0, 0)
+	check := NewRASCheck(config.RASConfig{
+		UncorrectableErrors:  1,
+		CorrectableErrors:    1000,
+		CorrectableErrorRate: 10,
+		RateWindow:           3600,
+		BadPages:             4,
+	}).(*rasCheck)
+	check.now = func() time.Time { return now }
+	checker := NewChecker(check)
+
+	unhealthy := func() []string {
+		var ids []string
+		for id, status := range checker.CheckDevices(gpus) {
+			if !status.Healthy() {
+				ids = append(ids, id+": "+status.Reason)
+			}
+		}
+		sort.Strings(ids)
+		return ids
+	}
+	steps := []struct {
+		description string
+		elapsed     time.Duration
+		umc         string
+		badPages    int
+		expReasons  []string
+	}{
+		{"baseline", 0, "ue: 2\nce: 3\n", 0, nil},
+		{"slow correctable errors", 30 * time.Minute, "ue: 2\nce: 8\n", 0, nil},
+		{"correctable error burst", 30 * time.Minute, "ue: 2\nce: 14\n", 0, []string{
+			"0000:80:00.0: ras: 11 correctable errors in the last 1h0m0s",
+			"amdgpu_xcp_1: ras: 11 correctable errors in the last 1h0m0s",
+		}},
+		{"rate back under the threshold", 2 * time.Hour, "ue: 2\nce: 15\n", 0, nil},
+		{"pages retired", time.Minute, "ue: 2\nce: 15\n", 4, []string{
+			"0000:80:00.0: ras: 4 retired VRAM pages",
+			"amdgpu_xcp_1: ras: 4 retired VRAM pages",
+		}},
+		{"new uncorrectable error", time.Minute, "ue: 3\nce: 15\n", 0, []string{
+			"0000:80:00.0: ras: 1 uncorrectable errors since the plugin started (umc: 1)",
+			"amdgpu_xcp_1: ras: 1 uncorrectable errors since the plugin started (umc: 1)",
+		}},
+		{"counters reset", time.Minute, "ue: 0\nce: 0\n", 0, nil},
+		{"uncorrectable error after the reset", time.Minute, "ue: 1\nce: 1000\n", 0, []string{
+			"0000:80:00.0: ras: 1 uncorrectable errors since the plugin started (umc: 1), 1000 correctable errors (umc: 1000), 1000 correctable errors in the last 1h0m0s",
+			"amdgpu_xcp_1: ras: 1 uncorrectable errors since the plugin started (umc: 1), 1000 correctable errors (umc: 1000), 1000 correctable errors in the last 1h0m0s",
+		}},
+	}
+	for _, step := range steps {
+		now = now.Add(step.elapsed)
+		writeFile(t, umc, step.umc)
+		writeFile(t, filepath.Join(amdgpu.PciDevicePath("0000:80:00.0"), "gpu_vram_bad_pages"),
+			strings.Repeat("0x00000001 : 0x00001000 : R\n", step.badPages))
+		reasons := unhealthy()
+		if strings.Join(reasons, "|") != strings.Join(step.expReasons, "|") {
+			t.Errorf("%s: unhealthy devices were incorrect, got: %v, want: %v", step.description, reasons, step.expReasons)
+		}
+	}
+}
+
+func TestReadRASErrorCounts(t *testing.T) {
+	defer func(sysfs string) { amdgpu.SysfsRoot = sysfs }(amdgpu.SysfsRoot)
+	amdgpu.SysfsRoot = t.TempDir()
+	if _, err := ReadRASErrorCounts("0000:0a:00.0"); err == nil {
+		t.Errorf("Expected an error without RAS support")
+	}
+
+	path := amdgpu.PciDevicePath("0000:0a:00.0")
+	writeFile(t, filepath.Join(path, "ras", "umc_err_count"), "ue: 1\nce: 42\n")
+	writeFile(t, filepath.Join(path, "ras", "sdma_err_count"), "ue: 0\nce: 7\n")
+	counts, err := ReadRASErrorCounts("0000:0a:00.0")
+	if err != nil {
+		t.Fatalf("expected ReadRASErrorCounts to pass. But failed with error %v", err)
+	}
+	if counts["umc"] != (ErrorCount{1, 42}) || counts["sdma"] != (ErrorCount{0, 7}) || len(counts) != 2 {
+		t.Errorf("RAS error counts were incorrect, got: %v", counts)
+	}
+}
@@ -0,0 +1,178 @@
+/**
+ * Copyright 2025 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package health
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/amdgpu"
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/config"
+)
+
+type rasSample struct {
+	time        time.Time
+	correctable int64
+}
+
+// rasCheck marks a GPU unhealthy based on its RAS error counts and retired
+// VRAM pages. It keeps a history of the correctable error count of every GPU
+// to compute their rate, and the uncorrectable error counts of every GPU when
+// it was first checked so that errors older than the plugin are ignored.
+type rasCheck struct {
+	cfg       config.RASConfig
+	now       func() time.Time
+	mu        sync.Mutex
+	samples   map[string][]rasSample
+	baselines map[string]map[string]int64
+}
+
+// NewRASCheck returns a check applying the RAS thresholds of the
+// configuration. It is run once per GPU and applies to its partitions.
+func NewRASCheck(cfg config.RASConfig) Check {
+	return &rasCheck{
+		cfg:       cfg,
+		now:       time.Now,
+		samples:   make(map[string][]rasSample),
+		baselines: make(map[string]map[string]int64),
+	}
+}
+
+func (c *rasCheck) Name() string { return config.HealthCheckRAS }
+func (c *rasCheck) perGPU() bool { return true }
+
+func (c *rasCheck) Check(gpu *amdgpu.GPU) error {
+	var problems []string
+	if counts, err := ReadRASErrorCounts(gpu.PciAddress); err == nil {
+		var ue, ce int64
+		newCounts := c.newUncorrectable(gpuKey(gpu), counts)
+		for block, count := range counts {
+			ue += newCounts[block].Uncorrectable
+			ce += count.Correctable
+		}
+		if c.cfg.UncorrectableErrors > 0 && ue >= c.cfg.UncorrectableErrors {
+			problems = append(problems, fmt.Sprintf("%d uncorrectable errors since the plugin started (%s)", ue, formatCounts(newCounts, true)))
+		}
+		if c.cfg.CorrectableErrors > 0 && ce >= c.cfg.CorrectableErrors {
+			problems = append(problems, fmt.Sprintf("%d correctable errors (%s)", ce, formatCounts(counts, false)))
+		}
+		if rate := c.correctableRate(gpuKey(gpu), ce); c.cfg.CorrectableErrorRate > 0 && rate >= c.cfg.CorrectableErrorRate {
+			problems = append(problems, fmt.Sprintf("%d correctable errors in the last %v", rate, time.Duration(c.cfg.RateWindow)*time.Second))
+		}
+	}
+	// RAS is only supported on datacenter GPUs, the files are missing otherwise
+	if c.cfg.BadPages > 0 {
+		if pages, err := countBadPages(gpu.PciAddress); err == nil && pages >= c.cfg.BadPages {
+			problems = append(problems, fmt.Sprintf("%d retired VRAM pages", pages))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// correctableRate records the correctable error count of a GPU and returns
+// the number of errors reported within the rate window
+func (c *rasCheck) correctableRate(key string, count int64) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	samples := c.samples[key]
+	// the counters are reset when the driver is reloaded
+	if len(samples) > 0 && count < samples[len(samples)-1].correctable {
+		samples = nil
+	}
+	samples = append(samples, rasSample{time: now, correctable: count})
+	// keep the newest sample out of the window as the baseline
+	window := time.Duration(c.cfg.RateWindow) * time.Second
+	for len(samples) > 1 && now.Sub(samples[1].time) >= window {
+		samples = samples[1:]
+	}
+	c.samples[key] = samples
+	return count - samples[0].correctable
+}
+
+// newUncorrectable records the uncorrectable error counts of a GPU the first
+// time it is checked and returns the errors reported since, per block
+func (c *rasCheck) newUncorrectable(key string, counts map[string]ErrorCount) map[string]ErrorCount {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	baseline, ok := c.baselines[key]
+	if !ok {
+		baseline = make(map[string]int64, len(counts))
+		for block, count := range counts {
+			baseline[block] = count.Uncorrectable
+		}
+		c.baselines[key] = baseline
+	}
+	// the counters are reset when the driver is reloaded, every error is new
+	for block, count := range counts {
+		if count.Uncorrectable < baseline[block] {
+			clear(baseline)
+			break
+		}
+	}
+	res := make(map[string]ErrorCount, len(counts))
+	for block, count := range counts {
+		res[block] = ErrorCount{Uncorrectable: count.Uncorrectable - baseline[block]}
+	}
+	return res
+}
+
+// countBadPages returns the number of VRAM pages retired or pending
+// retirement on a GPU, as listed in gpu_vram_bad_pages
+func countBadPages(pciAddress string) (int, error) {
+	data, err := os.ReadFile(filepath.Join(amdgpu.PciDevicePath(pciAddress), "gpu_vram_bad_pages"))
+	if err != nil {
+		return 0, err
+	}
+	pages := 0
+	for _, line := range strings.Split(string(data), "\n") {
+		// ex: 0x00000001 : 0x00001000 : R
+		if strings.Count(line, ":") == 2 {
+			pages++
+		}
+	}
+	return pages, nil
+}
+
+// formatCounts lists the blocks with uncorrectable or correctable errors,
+// ex: umc: 2, gfx: 1
+func formatCounts(counts map[string]ErrorCount, uncorrectable bool) string {
+	blocks := make([]string, 0, len(counts))
+	for block := range counts {
+		blocks = append(blocks, block)
+	}
+	sort.Strings(blocks)
+	var res []string
+	for _, block := range blocks {
+		v := counts[block].Correctable
+		if uncorrectable {
+			v = counts[block].Uncorrectable
+		}
+		if v > 0 {
+			res = append(res, fmt.Sprintf("%s: %d", block, v))
+		}
+	}
+	return strings.Join(res, ", ")
+}
@@ -88,8 +88,10 @@ func (h *Hwloc) GetNUMANodes(busid string) ([]uint64, error) {
 	var results []uint64
 	nn := ancestor.memory_first_child
 
+	// the OS index is the NUMA node ID of the kernel and the kubelet, the
+	// logical index can differ from it
 	for nn != nil {
-		results = append(results, uint64(nn.logical_index))
+		results = append(results, uint64(nn.os_index))
 		nn = nn.next_sibling
 	}
 
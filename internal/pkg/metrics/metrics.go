@@ -0,0 +1,178 @@
+/**
+ * Copyright 2025 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+// Package metrics holds the Prometheus metrics of the device plugin
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+const namespace = "amdgpu_device_plugin"
+
+var (
+	// Registry holds the device plugin metrics along with the Go runtime
+	// and process ones
+	Registry = prometheus.NewRegistry()
+
+	devices = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "devices",
+		Help:      "Number of devices advertised to the kubelet per resource and health.",
+	}, []string{"resource", "health"})
+
+	deviceHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "device_healthy",
+		Help:      "1 if the device is advertised as healthy, 0 otherwise, with the reason it is unhealthy.",
+	}, []string{"resource", "device", "reason"})
+
+	requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "requests_total",
+		Help:      "Number of device plugin API calls from the kubelet.",
+	}, []string{"resource", "method"})
+
+	requestErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "request_errors_total",
+		Help:      "Number of device plugin API calls from the kubelet that failed.",
+	}, []string{"resource", "method"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "request_duration_seconds",
+		Help:      "Latency of the device plugin API calls from the kubelet.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 10),
+	}, []string{"resource", "method"})
+
+	allocatorInitError = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "allocator_init_error",
+		Help:      "1 if the allocator failed to initialize and the kubelet default allocation is used, 0 otherwise.",
+	}, []string{"resource"})
+
+	exporterReachable = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "exporter_reachable",
+		Help:      "1 if the amd-metrics-exporter health service answered the last query, 0 otherwise.",
+	})
+
+	listAndWatchReconnects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "list_and_watch_reconnects_total",
+		Help:      "Number of times the kubelet opened a new ListAndWatch stream after the first one.",
+	}, []string{"resource"})
+
+	reregistrations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reregistrations_total",
+		Help:      "Number of times the plugin re-registered with the kubelet because it did not reopen ListAndWatch.",
+	}, []string{"resource"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		devices,
+		deviceHealthy,
+		requests,
+		requestErrors,
+		requestDuration,
+		allocatorInitError,
+		exporterReachable,
+		listAndWatchReconnects,
+		reregistrations,
+	)
+}
+
+// SetDevices records the devices advertised for a resource, reasons holds
+// why the unhealthy ones are
+func SetDevices(resource string, devs []*pluginapi.Device, reasons map[string]string) {
+	devices.DeletePartialMatch(prometheus.Labels{"resource": resource})
+	deviceHealthy.DeletePartialMatch(prometheus.Labels{"resource": resource})
+	counts := map[string]int{
+		pluginapi.Healthy:   0,
+		pluginapi.Unhealthy: 0,
+	}
+	for _, dev := range devs {
+		counts[dev.Health]++
+		healthy := 0.0
+		if dev.Health == pluginapi.Healthy {
+			healthy = 1
+		}
+		deviceHealthy.WithLabelValues(resource, dev.ID, reasons[dev.ID]).Set(healthy)
+	}
+	for health, count := range counts {
+		devices.WithLabelValues(resource, health).Set(float64(count))
+	}
+}
+
+// ObserveRequest records a device plugin API call that started at start
+func ObserveRequest(resource, method string, start time.Time, err error) {
+	requests.WithLabelValues(resource, method).Inc()
+	requestDuration.WithLabelValues(resource, method).Observe(time.Since(start).Seconds())
+	if err != nil {
+		requestErrors.WithLabelValues(resource, method).Inc()
+	}
+}
+
+// SetAllocatorInitError records whether the allocator of a resource failed
+// to initialize
+func SetAllocatorInitError(resource string, failed bool) {
+	v := 0.0
+	if failed {
+		v = 1
+	}
+	allocatorInitError.WithLabelValues(resource).Set(v)
+}
+
+// SetExporterReachable records whether the amd-metrics-exporter answered
+func SetExporterReachable(reachable bool) {
+	v := 0.0
+	if reachable {
+		v = 1
+	}
+	exporterReachable.Set(v)
+}
+
+// IncListAndWatchReconnects records a new ListAndWatch stream of a resource
+func IncListAndWatchReconnects(resource string) {
+	listAndWatchReconnects.WithLabelValues(resource).Inc()
+}
+
+// IncReregistrations records a re-registration of a resource with the
+// kubelet
+func IncReregistrations(resource string) {
+	reregistrations.WithLabelValues(resource).Inc()
+}
+
+// Serve exposes the metrics on /metrics at the given address. It only
+// returns on error.
+func Serve(address string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(Registry, promhttp.HandlerOpts{}))
+	glog.Infof("Serving metrics on %s/metrics", address)
+	return http.ListenAndServe(address, mux)
+}
@@ -0,0 +1,102 @@
+/**
+ * Copyright 2025 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+// gather returns the samples of a metric as "label=value,... value" strings
+func gather(t *testing.T, name string) []string {
+	t.Helper()
+	families, err := Registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var samples []string
+	for _, family := range families {
+		if family.GetName() != namespace+"_"+name {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			var labels []string
+			for _, l := range m.GetLabel() {
+				labels = append(labels, l.GetName()+"="+l.GetValue())
+			}
+			var v float64
+			switch {
+			case m.GetGauge() != nil:
+				v = m.GetGauge().GetValue()
+			case m.GetCounter() != nil:
+				v = m.GetCounter().GetValue()
+			case m.GetHistogram() != nil:
+				v = float64(m.GetHistogram().GetSampleCount())
+			}
+			samples = append(samples, fmt.Sprintf("%s %v", strings.Join(labels, ","), v))
+		}
+	}
+	sort.Strings(samples)
+	return samples
+}
+
+func expectSamples(t *testing.T, name string, exp []string) {
+	t.Helper()
+	if samples := gather(t, name); strings.Join(samples, "|") != strings.Join(exp, "|") {
+		t.Errorf("Samples of %s were incorrect, got: %v, want: %v", name, samples, exp)
+	}
+}
+
+func TestSetDevices(t *testing.T) {
+	SetDevices("gpu", []*pluginapi.Device{
+		{ID: "0000:0a:00.0", Health: pluginapi.Healthy},
+		{ID: "0000:80:00.0", Health: pluginapi.Unhealthy},
+	}, map[string]string{"0000:80:00.0": "ras: 1 uncorrectable errors"})
+	expectSamples(t, "devices", []string{"health=Healthy,resource=gpu 1", "health=Unhealthy,resource=gpu 1"})
+	expectSamples(t, "device_healthy", []string{
+		"device=0000:0a:00.0,reason=,resource=gpu 1",
+		"device=0000:80:00.0,reason=ras: 1 uncorrectable errors,resource=gpu 0",
+	})
+
+	// devices that are gone or healthy again lose their old series
+	SetDevices("gpu", []*pluginapi.Device{
+		{ID: "0000:80:00.0", Health: pluginapi.Healthy},
+	}, nil)
+	expectSamples(t, "devices", []string{"health=Healthy,resource=gpu 1", "health=Unhealthy,resource=gpu 0"})
+	expectSamples(t, "device_healthy", []string{"device=0000:80:00.0,reason=,resource=gpu 1"})
+}
+
+func TestObserveRequest(t *testing.T) {
+	start := time.Now()
+	ObserveRequest("cpx_nps4", "Allocate", start, nil)
+	ObserveRequest("cpx_nps4", "Allocate", start, fmt.Errorf("failed"))
+	ObserveRequest("cpx_nps4", "GetPreferredAllocation", start, nil)
+	expectSamples(t, "requests_total", []string{
+		"method=Allocate,resource=cpx_nps4 2",
+		"method=GetPreferredAllocation,resource=cpx_nps4 1",
+	})
+	expectSamples(t, "request_errors_total", []string{"method=Allocate,resource=cpx_nps4 1"})
+	expectSamples(t, "request_duration_seconds", []string{
+		"method=Allocate,resource=cpx_nps4 2",
+		"method=GetPreferredAllocation,resource=cpx_nps4 1",
+	})
+}
@@ -0,0 +1,239 @@
+/**
+ * Copyright 2025 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+// Package nodehealth reports the health of the devices on the Node object,
+// as Events and as a node condition, so that it is visible to the cluster
+package nodehealth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/config"
+	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+const (
+	// ConditionType is the node condition maintained by the reporter. It
+	// is True while every device advertised on the node is healthy.
+	ConditionType corev1.NodeConditionType = "AMDGPUHealthy"
+
+	// ReasonUnhealthy and ReasonHealthy are the reasons of the Events and
+	// of the node condition
+	ReasonUnhealthy = "AMDGPUUnhealthy"
+	ReasonHealthy   = "AMDGPUHealthy"
+
+	// SourceExporter is the source of the health reported by the
+	// amd-metrics-exporter, the native checks are their own source
+	SourceExporter = "exporter"
+
+	component  = "amdgpu-device-plugin"
+	apiTimeout = 10 * time.Second
+	// maxUnhealthyListed bounds the devices listed in the condition message
+	maxUnhealthyListed = 10
+)
+
+// nodeStatusPatcher patches the status of a node, as the typed node client
+// does
+type nodeStatusPatcher interface {
+	PatchStatus(ctx context.Context, nodeName string, data []byte) (*corev1.Node, error)
+}
+
+// Reporter emits Events on the Node for device health transitions and keeps
+// its AMDGPUHealthy condition up to date
+type Reporter struct {
+	node     *corev1.ObjectReference
+	recorder record.EventRecorder
+	nodes    nodeStatusPatcher
+
+	mu sync.Mutex
+	// unhealthy holds the reasons of the unhealthy devices per resource
+	unhealthy map[string]map[string]string
+	// condition is the condition last computed, synced is set once it was
+	// written to the node
+	condition *corev1.NodeCondition
+	synced    bool
+	// patching is set while a call writes the condition to the node
+	patching bool
+}
+
+// NewReporter returns a reporter for the node, emitting Events and
+// maintaining the node condition as enabled in cfg
+func NewReporter(client kubernetes.Interface, nodeName string, cfg config.EventsConfig) *Reporter {
+	var recorder record.EventRecorder
+	if cfg.Enabled {
+		broadcaster := record.NewBroadcaster()
+		broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+		recorder = broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: component, Host: nodeName})
+	}
+	var nodes nodeStatusPatcher
+	if cfg.NodeCondition {
+		nodes = client.CoreV1().Nodes()
+	}
+	return newReporter(nodeName, recorder, nodes)
+}
+
+func newReporter(nodeName string, recorder record.EventRecorder, nodes nodeStatusPatcher) *Reporter {
+	return &Reporter{
+		// the kubelet uses the node name as UID in the events of its node
+		node: &corev1.ObjectReference{
+			Kind: "Node",
+			Name: nodeName,
+			UID:  types.UID(nodeName),
+		},
+		recorder:  recorder,
+		nodes:     nodes,
+		unhealthy: make(map[string]map[string]string),
+	}
+}
+
+// DeviceHealthChanged emits an Event for a device of a resource that turned
+// healthy or unhealthy. source names the checks, or the exporter, that
+// found it unhealthy, and workloads the workloads using it if known.
+func (r *Reporter) DeviceHealthChanged(resource, id, health, reason, source string, workloads []string) {
+	if r == nil || r.recorder == nil {
+		return
+	}
+	if health == pluginapi.Healthy {
+		r.recorder.Eventf(r.node, corev1.EventTypeNormal, ReasonHealthy,
+			"Device %s of resource %s is healthy again", id, resource)
+		return
+	}
+	message := fmt.Sprintf("Device %s of resource %s is unhealthy, source: %s, reason: %s", id, resource, source, reason)
+	if len(workloads) > 0 {
+		message += ", used by: " + strings.Join(workloads, ", ")
+	}
+	r.recorder.Event(r.node, corev1.EventTypeWarning, ReasonUnhealthy, message)
+}
+
+// SetUnhealthy sets the unhealthy devices of a resource, with their
+// reasons, and updates the node condition if it changed. Failed updates are
+// retried on the next call. The reporter is shared by the resources, so the
+// node is patched without holding mu: a call made while another one patches
+// the node only records the change, which the patching call writes next.
+func (r *Reporter) SetUnhealthy(resource string, unhealthy map[string]string) {
+	if r == nil || r.nodes == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.unhealthy[resource] = unhealthy
+	r.updateCondition()
+	if r.synced || r.patching {
+		return
+	}
+	r.patching = true
+	for !r.synced {
+		condition := *r.condition
+		r.mu.Unlock()
+		err := r.patchCondition(condition)
+		r.mu.Lock()
+		if err != nil {
+			break
+		}
+		// the condition may have changed while the node was patched
+		r.synced = r.condition.Status == condition.Status && r.condition.Message == condition.Message
+	}
+	r.patching = false
+}
+
+// updateCondition computes the condition matching the unhealthy devices and
+// marks it to be written to the node if it changed. It must be called with
+// mu held.
+func (r *Reporter) updateCondition() {
+	condition := r.nodeCondition()
+	if r.condition != nil && r.condition.Status == condition.Status && r.condition.Message == condition.Message {
+		if !r.synced {
+			r.condition.LastHeartbeatTime = condition.LastHeartbeatTime
+		}
+		return
+	}
+	if r.condition != nil && r.condition.Status == condition.Status {
+		condition.LastTransitionTime = r.condition.LastTransitionTime
+	}
+	r.condition = &condition
+	r.synced = false
+}
+
+// patchCondition writes the condition to the node
+func (r *Reporter) patchCondition(condition corev1.NodeCondition) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{
+			"conditions": []corev1.NodeCondition{condition},
+		},
+	})
+	if err != nil {
+		glog.Errorf("Unable to encode %s node condition: %v", ConditionType, err)
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+	defer cancel()
+	if _, err := r.nodes.PatchStatus(ctx, r.node.Name, patch); err != nil {
+		glog.Errorf("Unable to set %s condition of node %s: %v", ConditionType, r.node.Name, err)
+		return err
+	}
+	glog.Infof("Set %s condition of node %s to %s: %s", ConditionType, r.node.Name, condition.Status, condition.Message)
+	return nil
+}
+
+// nodeCondition returns the condition matching the unhealthy devices. It
+// must be called with mu held.
+func (r *Reporter) nodeCondition() corev1.NodeCondition {
+	now := metav1.Now()
+	condition := corev1.NodeCondition{
+		Type:               ConditionType,
+		Status:             corev1.ConditionTrue,
+		Reason:             ReasonHealthy,
+		Message:            "All AMD GPU devices are healthy",
+		LastHeartbeatTime:  now,
+		LastTransitionTime: now,
+	}
+	var unhealthy []string
+	for resource, devices := range r.unhealthy {
+		for id := range devices {
+			unhealthy = append(unhealthy, resource+"/"+id)
+		}
+	}
+	if len(unhealthy) == 0 {
+		return condition
+	}
+	sort.Strings(unhealthy)
+	listed := unhealthy
+	if len(listed) > maxUnhealthyListed {
+		listed = listed[:maxUnhealthyListed]
+	}
+	message := fmt.Sprintf("%d AMD GPU devices are unhealthy: %s", len(unhealthy), strings.Join(listed, ", "))
+	if len(unhealthy) > len(listed) {
+		message += ", ..."
+	}
+	condition.Status = corev1.ConditionFalse
+	condition.Reason = ReasonUnhealthy
+	condition.Message = message
+	return condition
+}
@@ -0,0 +1,161 @@
+/**
+ * Copyright 2025 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package nodehealth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+type fakeNodes struct {
+	patches []corev1.NodeCondition
+	err     error
+	// started and release, if set, hold the patches until released
+	started chan struct{}
+	release chan struct{}
+}
+
+func (f *fakeNodes) PatchStatus(_ context.Context, nodeName string, data []byte) (*corev1.Node, error) {
+	if f.release != nil {
+		f.started <- struct{}{}
+		<-f.release
+	}
+	if f.err != nil {
+		return nil, f.err
+	}
+	var patch struct {
+		Status struct {
+			Conditions []corev1.NodeCondition `json:"conditions"`
+		} `json:"status"`
+	}
+	if err := json.Unmarshal(data, &patch); err != nil {
+		return nil, err
+	}
+	f.patches = append(f.patches, patch.Status.Conditions...)
+	return &corev1.Node{}, nil
+}
+
+func TestDeviceHealthChanged(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	r := newReporter("node-1", recorder, nil)
+	r.DeviceHealthChanged("gpu", "0000:0a:00.0", pluginapi.Unhealthy, "2 uncorrectable errors (umc: 2)", "ras", []string{"default/train-0"})
+	r.DeviceHealthChanged("gpu", "0000:0a:00.0", pluginapi.Healthy, "", "", nil)
+
+	for _, exp := range []string{
+		"Warning AMDGPUUnhealthy Device 0000:0a:00.0 of resource gpu is unhealthy, source: ras, reason: 2 uncorrectable errors (umc: 2), used by: default/train-0",
+		"Normal AMDGPUHealthy Device 0000:0a:00.0 of resource gpu is healthy again",
+	} {
+		if event := <-recorder.Events; event != exp {
+			t.Errorf("Event was incorrect, got: %s, want: %s", event, exp)
+		}
+	}
+	// without a node condition to maintain, nothing is patched
+	r.SetUnhealthy("gpu", map[string]string{"0000:0a:00.0": "ras"})
+}
+
+func TestSetUnhealthy(t *testing.T) {
+	nodes := &fakeNodes{}
+	r := newReporter("node-1", nil, nodes)
+
+	steps := []struct {
+		description string
+		resource    string
+		unhealthy   map[string]string
+		err         error
+		expPatches  int
+		expStatus   corev1.ConditionStatus
+		expMessage  string
+	}{
+		{"all healthy", "gpu", nil, nil, 1, corev1.ConditionTrue, "All AMD GPU devices are healthy"},
+		{"unchanged", "gpu", map[string]string{}, nil, 1, corev1.ConditionTrue, ""},
+		{"device unhealthy", "gpu", map[string]string{"0000:0a:00.0": "ras"}, nil, 2, corev1.ConditionFalse,
+			"1 AMD GPU devices are unhealthy: gpu/0000:0a:00.0"},
+		{"another resource unhealthy", "cpx_nps1", map[string]string{"amdgpu_xcp_1": "kfd"}, nil, 3, corev1.ConditionFalse,
+			"2 AMD GPU devices are unhealthy: cpx_nps1/amdgpu_xcp_1, gpu/0000:0a:00.0"},
+		{"patch failed", "gpu", map[string]string{}, fmt.Errorf("forbidden"), 3, corev1.ConditionFalse, ""},
+		{"patch retried", "gpu", map[string]string{}, nil, 4, corev1.ConditionFalse,
+			"1 AMD GPU devices are unhealthy: cpx_nps1/amdgpu_xcp_1"},
+		{"all healthy again", "cpx_nps1", nil, nil, 5, corev1.ConditionTrue, "All AMD GPU devices are healthy"},
+	}
+	for _, step := range steps {
+		nodes.err = step.err
+		r.SetUnhealthy(step.resource, step.unhealthy)
+		if len(nodes.patches) != step.expPatches {
+			t.Fatalf("%s: number of patches was incorrect, got: %d, want: %d", step.description, len(nodes.patches), step.expPatches)
+		}
+		last := nodes.patches[len(nodes.patches)-1]
+		if last.Type != ConditionType || last.Status != step.expStatus {
+			t.Errorf("%s: condition was incorrect, got: %+v", step.description, last)
+		}
+		if step.expMessage != "" && last.Message != step.expMessage {
+			t.Errorf("%s: message was incorrect, got: %s, want: %s", step.description, last.Message, step.expMessage)
+		}
+	}
+	// the transition time only changes with the status
+	if !nodes.patches[3].LastTransitionTime.Equal(&nodes.patches[1].LastTransitionTime) {
+		t.Errorf("Transition time changed without a change of status")
+	}
+}
+
+func TestSetUnhealthyWhilePatching(t *testing.T) {
+	nodes := &fakeNodes{started: make(chan struct{}), release: make(chan struct{}), err: fmt.Errorf("timeout")}
+	r := newReporter("node-1", nil, nodes)
+
+	done := make(chan struct{})
+	go func() {
+		r.SetUnhealthy("gpu", map[string]string{"0000:0a:00.0": "ras"})
+		close(done)
+	}()
+	<-nodes.started
+	// another resource isn't blocked by the pending patch
+	r.SetUnhealthy("cpx_nps1", map[string]string{"amdgpu_xcp_1": "kfd"})
+	close(nodes.release)
+	<-done
+	if len(nodes.patches) != 0 {
+		t.Fatalf("expected the failed patch not to be recorded, got: %v", nodes.patches)
+	}
+
+	// the failed patch is retried with both resources
+	nodes.release, nodes.err = nil, nil
+	r.SetUnhealthy("gpu", map[string]string{"0000:0a:00.0": "ras"})
+	exp := "2 AMD GPU devices are unhealthy: cpx_nps1/amdgpu_xcp_1, gpu/0000:0a:00.0"
+	if len(nodes.patches) != 1 || nodes.patches[0].Message != exp {
+		t.Errorf("condition was incorrect, got: %+v, want: %s", nodes.patches, exp)
+	}
+
+	// a change made while the node is patched is written right after
+	nodes.started, nodes.release = make(chan struct{}), make(chan struct{})
+	done = make(chan struct{})
+	go func() {
+		r.SetUnhealthy("gpu", nil)
+		close(done)
+	}()
+	<-nodes.started
+	r.SetUnhealthy("cpx_nps1", nil)
+	close(nodes.release)
+	<-nodes.started
+	<-done
+	if len(nodes.patches) != 3 || nodes.patches[2].Status != corev1.ConditionTrue {
+		t.Errorf("condition was incorrect, got: %+v", nodes.patches)
+	}
+}
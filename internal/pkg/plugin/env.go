@@ -0,0 +1,81 @@
+/**
+ * Copyright 2025 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package plugin
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/amdgpu"
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/config"
+)
+
+// envValue returns the value describing a device for an environment
+// variable, index being the position of the device among the allocated ones
+func envValue(value string, index int, gpu *amdgpu.GPU) string {
+	switch value {
+	case config.EnvIndex:
+		return strconv.Itoa(index)
+	case config.EnvUniqueID:
+		if gpu.UniqueId == "" {
+			return ""
+		}
+		// the partitions share the unique id of their GPU
+		if gpu.IsPartition() {
+			return fmt.Sprintf("GPU-%s-%d", gpu.UniqueId, gpu.PartitionIndex)
+		}
+		return "GPU-" + gpu.UniqueId
+	case config.EnvRenderMinor:
+		return strconv.Itoa(gpu.RenderD)
+	case config.EnvPCIBusID:
+		return gpu.PciAddress
+	case config.EnvPartitionMode:
+		if gpu.PartitionType() == "" {
+			return "none"
+		}
+		return gpu.PartitionType()
+	case config.EnvDeviceID:
+		return gpu.Id
+	}
+	return ""
+}
+
+// allocateEnvs returns the environment variables describing the allocated
+// devices. The devices are ordered by render minor, which is the order the
+// ROCm runtime enumerates the GPUs visible in the container, so the n-th
+// entry of every variable refers to the same device.
+func allocateEnvs(vars []config.EnvVar, gpus []*amdgpu.GPU) map[string]string {
+	if len(vars) == 0 || len(gpus) == 0 {
+		return nil
+	}
+	sorted := append([]*amdgpu.GPU{}, gpus...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].RenderD < sorted[j].RenderD
+	})
+
+	envs := make(map[string]string, len(vars))
+	for _, v := range vars {
+		values := make([]string, len(sorted))
+		for i, gpu := range sorted {
+			values[i] = envValue(v.Value, i, gpu)
+		}
+		envs[v.Name] = strings.Join(values, ",")
+	}
+	return envs
+}
@@ -24,13 +24,22 @@ import (
 	"os/signal"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/ROCm/k8s-device-plugin/internal/pkg/allocator"
 	"github.com/ROCm/k8s-device-plugin/internal/pkg/amdgpu"
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/cdi"
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/config"
 	"github.com/ROCm/k8s-device-plugin/internal/pkg/exporter"
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/health"
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/metrics"
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/nodehealth"
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/replica"
 	"github.com/golang/glog"
 	"github.com/kubevirt/device-plugin-manager/pkg/dpm"
 	"golang.org/x/net/context"
@@ -39,18 +48,64 @@ import (
 
 // Plugin is identical to DevicePluginServer interface of device plugin API.
 type AMDGPUPlugin struct {
-	AMDGPUs            map[string]map[string]interface{}
+	AMDGPUs            map[string]*amdgpu.GPU
 	Heartbeat          chan bool
 	signal             chan os.Signal
 	Resource           string
 	devAllocator       allocator.Policy
 	allocatorInitError bool
+	watcher            *amdgpu.Watcher
+	deviceUpdates      <-chan struct{}
+	injectKFD          bool
+	// replicas is the number of times each device is advertised, more than
+	// one when its devices are time-sliced
+	replicas int
+	// cdiVendor, if set, makes Allocate return CDI devices of this vendor
+	// instead of device nodes
+	cdiVendor string
+	// env lists the environment variables describing the allocated devices
+	env []config.EnvVar
+	// preStart makes the kubelet call PreStartContainer, which checks the
+	// allocated devices and runs preStartCommand on them
+	preStart        bool
+	preStartCommand []string
+	preStartTimeout time.Duration
+	// healthChecker runs the native per-device health checks every
+	// heartbeat
+	healthChecker *health.Checker
+	// exporter, if set, provides the health reported by the
+	// amd-metrics-exporter, changes of which are advertised right away
+	exporter      *exporter.Client
+	healthUpdates <-chan struct{}
+	// reporter, if set, reports health transitions on the node, lastHealth
+	// holds the health last reported per physical device
+	reporter   *nodehealth.Reporter
+	lastHealth map[string]string
+	// streams counts the ListAndWatch streams opened by the kubelet
+	streams int
+	// reregister registers the plugin with the kubelet again, reconnecting
+	// is set while waiting for the kubelet to open a stream after the
+	// awaitedStreams-th one, the next attempt being made after backoff
+	reregister     func() bool
+	reconnecting   bool
+	awaitedStreams int
+	backoff        time.Duration
+	// stop is closed when the plugin is stopped, it is nil until the plugin
+	// is started again
+	stop chan struct{}
+	// mu guards AMDGPUs and the allocator state, which are refreshed by
+	// ListAndWatch while Allocate requests are being served
+	mu sync.RWMutex
 }
 
 type AMDGPUPluginOption func(*AMDGPUPlugin)
 
 func NewAMDGPUPlugin(options ...AMDGPUPluginOption) *AMDGPUPlugin {
-	amdGpuPlugin := &AMDGPUPlugin{}
+	amdGpuPlugin := &AMDGPUPlugin{
+		injectKFD:     true,
+		replicas:      1,
+		healthChecker: health.New(config.Default().Health),
+	}
 	for _, option := range options {
 		option(amdGpuPlugin)
 	}
@@ -74,36 +129,148 @@ func WithResource(res string) AMDGPUPluginOption {
 	}
 }
 
+// WithKFDInjection controls whether /dev/kfd is handed to the containers
+func WithKFDInjection(inject bool) AMDGPUPluginOption {
+	return func(p *AMDGPUPlugin) {
+		p.injectKFD = inject
+	}
+}
+
+// WithReplicas advertises every device n times so that it can be allocated
+// to up to n containers at once
+func WithReplicas(n int) AMDGPUPluginOption {
+	return func(p *AMDGPUPlugin) {
+		if n > 0 {
+			p.replicas = n
+		}
+	}
+}
+
+// WithEnv sets the environment variables describing the allocated devices
+// in the containers
+func WithEnv(env []config.EnvVar) AMDGPUPluginOption {
+	return func(p *AMDGPUPlugin) {
+		p.env = env
+	}
+}
+
+// WithPreStart enables the checks of the allocated devices, and optionally
+// a command run on them, before their containers start
+func WithPreStart(cfg config.PreStartConfig) AMDGPUPluginOption {
+	return func(p *AMDGPUPlugin) {
+		p.preStart = cfg.Enabled
+		p.preStartCommand = cfg.Command
+		p.preStartTimeout = time.Duration(cfg.Timeout) * time.Second
+	}
+}
+
+// WithHealthChecker sets the checker run on the devices every heartbeat
+func WithHealthChecker(c *health.Checker) AMDGPUPluginOption {
+	return func(p *AMDGPUPlugin) {
+		p.healthChecker = c
+	}
+}
+
+// WithCDIDevices makes Allocate return the CDI names of the devices under
+// the vendor's spec instead of device nodes. The spec must be generated
+// separately.
+func WithCDIDevices(vendor string) AMDGPUPluginOption {
+	return func(p *AMDGPUPlugin) {
+		p.cdiVendor = vendor
+	}
+}
+
+// WithExporter sets the client of the amd-metrics-exporter health service
+func WithExporter(c *exporter.Client) AMDGPUPluginOption {
+	return func(p *AMDGPUPlugin) {
+		p.exporter = c
+	}
+}
+
+// WithReporter reports the health transitions of the devices on the node
+func WithReporter(r *nodehealth.Reporter) AMDGPUPluginOption {
+	return func(p *AMDGPUPlugin) {
+		p.reporter = r
+	}
+}
+
+// WithWatcher makes the plugin re-discover and re-advertise its devices
+// whenever the watcher reports a change in the GPU configuration
+func WithWatcher(w *amdgpu.Watcher) AMDGPUPluginOption {
+	return func(p *AMDGPUPlugin) {
+		p.watcher = w
+	}
+}
+
 // Start is an optional interface that could be implemented by plugin.
 // If case Start is implemented, it will be executed by Manager after
 // plugin instantiation and before its registration to kubelet. This
 // method could be used to prepare resources before they are offered
 // to Kubernetes.
 func (p *AMDGPUPlugin) Start() error {
-	p.signal = make(chan os.Signal, 1)
-	signal.Notify(p.signal, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM)
-	err := p.devAllocator.Init(getDevices(), "")
+	// the plugin is started again every time it is re-registered
+	if p.signal == nil {
+		p.signal = make(chan os.Signal, 1)
+		signal.Notify(p.signal, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM)
+	}
+	if p.watcher != nil {
+		p.deviceUpdates = p.watcher.Subscribe()
+	}
+	if p.exporter != nil {
+		p.healthUpdates = p.exporter.Subscribe()
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stop = make(chan struct{})
+	// a wait for the kubelet to reconnect that was stopped with the plugin,
+	// ex: to re-register it, goes on
+	if p.awaitedStreams > 0 && !p.reconnecting {
+		p.startReconnect()
+	}
+	p.initAllocator()
+	return nil
+}
+
+// initAllocator (re)initializes the allocator with the devices currently
+// present. It must be called with mu held.
+func (p *AMDGPUPlugin) initAllocator() {
+	if p.devAllocator == nil {
+		// the kubelet allocates devices on its own
+		p.allocatorInitError = false
+		metrics.SetAllocatorInitError(p.Resource, false)
+		return
+	}
+	err := p.devAllocator.Init(getDevices(), amdgpu.SysfsPath("class/kfd/kfd/topology/nodes"))
 	if err != nil {
 		glog.Errorf("allocator init failed. Falling back to kubelet default allocation. Error %v", err)
 		p.allocatorInitError = true
+		metrics.SetAllocatorInitError(p.Resource, true)
+		return
 	}
-	return nil
+	p.allocatorInitError = false
+	metrics.SetAllocatorInitError(p.Resource, false)
 }
 
 func getDevices() []*allocator.Device {
-	devices := amdgpu.GetAMDGPUs()
+	return AllocatorDevices(amdgpu.GetGPUs())
+}
+
+// AllocatorDevices returns the devices the allocator policies are initialized
+// with for the GPUs and partitions discovered
+func AllocatorDevices(devices map[string]*amdgpu.GPU) []*allocator.Device {
 	var deviceList []*allocator.Device
 
-	for id, deviceData := range devices {
+	for id, gpu := range devices {
 		device := &allocator.Device{
 			Id:                   id,
-			Card:                 deviceData["card"].(int),
-			RenderD:              deviceData["renderD"].(int),
-			DevId:                deviceData["devID"].(string),
-			ComputePartitionType: deviceData["computePartitionType"].(string),
-			MemoryPartitionType:  deviceData["memoryPartitionType"].(string),
-			NodeId:               deviceData["nodeId"].(int),
-			NumaNode:             deviceData["numaNode"].(int),
+			Card:                 gpu.Card,
+			RenderD:              gpu.RenderD,
+			DevId:                gpu.DevId,
+			ComputePartitionType: gpu.ComputePartitionType,
+			MemoryPartitionType:  gpu.MemoryPartitionType,
+			NodeId:               gpu.NodeId,
+			NumaNode:             gpu.NumaNode,
+			MemoryDomain:         gpu.MemoryDomain,
 		}
 		deviceList = append(deviceList, device)
 	}
@@ -115,13 +282,25 @@ func getDevices() []*allocator.Device {
 // plugin is unregistered from kubelet. This method could be used to tear
 // down resources.
 func (p *AMDGPUPlugin) Stop() error {
+	if p.watcher != nil && p.deviceUpdates != nil {
+		p.watcher.Unsubscribe(p.deviceUpdates)
+	}
+	if p.exporter != nil && p.healthUpdates != nil {
+		p.exporter.Unsubscribe(p.healthUpdates)
+	}
+	p.mu.Lock()
+	if p.stop != nil {
+		close(p.stop)
+		p.stop = nil
+	}
+	p.mu.Unlock()
 	return nil
 }
 
 var topoSIMDre = regexp.MustCompile(`simd_count\s(\d+)`)
 
 func countGPUDevFromTopology(topoRootParam ...string) int {
-	topoRoot := "/sys/class/kfd/kfd"
+	topoRoot := amdgpu.SysfsPath("class/kfd/kfd")
 	if len(topoRootParam) == 1 {
 		topoRoot = topoRootParam[0]
 	}
@@ -158,69 +337,203 @@ func countGPUDevFromTopology(topoRootParam ...string) int {
 	return count
 }
 
-func simpleHealthCheck() bool {
-	entries, err := filepath.Glob("/sys/class/kfd/kfd/topology/nodes/*/properties")
-	if err != nil {
-		glog.Errorf("Error finding properties files: %v", err)
-		return false
+// GetDevicePluginOptions returns options to be communicated with Device
+// Manager
+func (p *AMDGPUPlugin) GetDevicePluginOptions(ctx context.Context, e *pluginapi.Empty) (*pluginapi.DevicePluginOptions, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.devAllocator == nil || p.allocatorInitError {
+		return &pluginapi.DevicePluginOptions{
+			PreStartRequired: p.preStart,
+		}, nil
 	}
+	return &pluginapi.DevicePluginOptions{
+		PreStartRequired:                p.preStart,
+		GetPreferredAllocationAvailable: true,
+	}, nil
+}
 
-	for _, propFile := range entries {
-		f, err := os.Open(propFile)
-		if err != nil {
-			glog.Errorf("Error opening %s: %v", propFile, err)
-			continue
+// PreStartContainer is expected to be called before each container start if indicated by plugin during registration phase.
+// PreStartContainer allows kubelet to pass reinitialized devices to containers.
+// PreStartContainer allows Device Plugin to run device specific operations on the Devices requested
+func (p *AMDGPUPlugin) PreStartContainer(ctx context.Context, r *pluginapi.PreStartContainerRequest) (*pluginapi.PreStartContainerResponse, error) {
+	if !p.preStart {
+		return &pluginapi.PreStartContainerResponse{}, nil
+	}
+
+	p.mu.RLock()
+	gpus := p.lookupGPUs(r.DevicesIDs)
+	p.mu.RUnlock()
+	if len(gpus) != len(uniquePhysicalIDs(r.DevicesIDs)) {
+		return nil, fmt.Errorf("unknown device in %v, the GPU configuration may have changed", r.DevicesIDs)
+	}
+
+	for _, gpu := range gpus {
+		glog.Infof("Checking device %s before container start", gpu.Id)
+		if err := checkDevice(gpu); err != nil {
+			glog.Errorf("Pre-start check failed: %v", err)
+			return nil, err
+		}
+		if len(p.preStartCommand) > 0 {
+			if err := runPreStartCommand(ctx, p.preStartCommand, p.preStartTimeout, gpu); err != nil {
+				glog.Errorf("%v", err)
+				return nil, err
+			}
 		}
-		defer f.Close()
+	}
+	return &pluginapi.PreStartContainerResponse{}, nil
+}
 
-		var cpuCores, gfxVersion int
-		scanner := bufio.NewScanner(f)
-		for scanner.Scan() {
-			line := scanner.Text()
-			if strings.HasPrefix(line, "cpu_cores_count") {
-				parts := strings.Fields(line)
-				if len(parts) == 2 {
-					cpuCores, _ = strconv.Atoi(parts[1])
-				}
-			} else if strings.HasPrefix(line, "gfx_target_version") {
-				parts := strings.Fields(line)
-				if len(parts) == 2 {
-					gfxVersion, _ = strconv.Atoi(parts[1])
+// getDeviceList returns the devices advertised under the plugin's resource.
+// On a homogeneous node all devices are advertised, otherwise only the ones
+// whose partition type matches the resource. Time-sliced devices are
+// advertised once per replica. It must be called with mu held.
+func (p *AMDGPUPlugin) getDeviceList() []*pluginapi.Device {
+	isHomogeneous := len(amdgpu.UniquePartitionConfigCount(p.AMDGPUs)) <= 1
+	devs := make([]*pluginapi.Device, 0, len(p.AMDGPUs))
+
+	for id, device := range p.AMDGPUs {
+		// Only report the devices belonging to this plugin's partition type
+		partitionType := device.ComputePartitionType + "_" + device.MemoryPartitionType
+		if !isHomogeneous && partitionType != p.Resource {
+			continue
+		}
+		numas := device.NumaNodes
+		glog.Infof("Watching GPU with bus ID: %s NUMA Node: %+v", id, numas)
+
+		// without a known NUMA node the device has no topology, leaving the
+		// kubelet Topology Manager free to align it with any NUMA node
+		var topology *pluginapi.TopologyInfo
+		if len(numas) > 0 {
+			numaNodes := make([]*pluginapi.NUMANode, len(numas))
+			for j, v := range numas {
+				numaNodes[j] = &pluginapi.NUMANode{
+					ID: int64(v),
 				}
 			}
+			topology = &pluginapi.TopologyInfo{
+				Nodes: numaNodes,
+			}
 		}
-
-		if err := scanner.Err(); err != nil {
-			glog.Warningf("Error scanning %s: %v", propFile, err)
+		if p.replicas == 1 {
+			devs = append(devs, &pluginapi.Device{
+				ID:       id,
+				Health:   pluginapi.Healthy,
+				Topology: topology,
+			})
 			continue
 		}
+		for i := 0; i < p.replicas; i++ {
+			devs = append(devs, &pluginapi.Device{
+				ID:       replica.ID(id, i),
+				Health:   pluginapi.Healthy,
+				Topology: topology,
+			})
+		}
+	}
+	return devs
+}
 
-		if cpuCores == 0 && gfxVersion > 0 {
-			// Found a GPU
-			return true
+// discoverDevices re-discovers the GPUs on the node and returns the updated
+// list of devices for the plugin's resource. It must be called with mu held.
+func (p *AMDGPUPlugin) discoverDevices() []*pluginapi.Device {
+	p.AMDGPUs = amdgpu.GetGPUs()
+	glog.Infof("Found %d AMDGPUs", len(p.AMDGPUs))
+	devs := p.getDeviceList()
+	if p.exporter != nil {
+		// only the GPUs backing the advertised devices are queried
+		gpus := make(map[string]*amdgpu.GPU)
+		for _, dev := range devs {
+			id := replica.PhysicalID(dev.ID)
+			gpus[id] = p.AMDGPUs[id]
 		}
+		p.exporter.SetDevices(p.Resource, gpus)
 	}
+	return devs
+}
 
-	glog.Warning("No GPU nodes found via properties")
-	return false
+// updateHealth sets the health of the devices from the native per-device
+// checks and the amd-metrics-exporter, if available. A device is unhealthy
+// if either reports it so. It returns why the unhealthy devices are.
+func (p *AMDGPUPlugin) updateHealth(devs []*pluginapi.Device) map[string]string {
+	p.mu.RLock()
+	statuses := p.healthChecker.CheckDevices(p.AMDGPUs)
+	p.mu.RUnlock()
+
+	// update with per device GPU health status
+	p.exporter.PopulatePerGPUDHealth(devs, pluginapi.Healthy)
+	reasons := make(map[string]string)
+	sources := make(map[string]string)
+	for _, dev := range devs {
+		if dev.Health == pluginapi.Unhealthy {
+			reasons[dev.ID] = "reported by amd-metrics-exporter"
+			sources[dev.ID] = nodehealth.SourceExporter
+		}
+		if status, ok := statuses[replica.PhysicalID(dev.ID)]; ok && !status.Healthy() {
+			if dev.Health == pluginapi.Unhealthy {
+				sources[dev.ID] += ","
+			}
+			dev.Health = pluginapi.Unhealthy
+			reasons[dev.ID] = status.Reason
+			sources[dev.ID] += status.Source
+		}
+	}
+	p.reportHealth(devs, reasons, sources)
+	return reasons
 }
 
-// GetDevicePluginOptions returns options to be communicated with Device
-// Manager
-func (p *AMDGPUPlugin) GetDevicePluginOptions(ctx context.Context, e *pluginapi.Empty) (*pluginapi.DevicePluginOptions, error) {
-	if p.allocatorInitError {
-		return &pluginapi.DevicePluginOptions{}, nil
+// reportHealth logs the devices whose health changed since the last update,
+// along with the workloads the amd-metrics-exporter sees using them, and
+// reports them on the node. Replicas of a device are reported once.
+func (p *AMDGPUPlugin) reportHealth(devs []*pluginapi.Device, reasons, sources map[string]string) {
+	type transition struct {
+		id, health, reason, source string
+		workloads                  []string
 	}
-	return &pluginapi.DevicePluginOptions{
-		GetPreferredAllocationAvailable: true,
-	}, nil
+	var transitions []transition
+	unhealthy := make(map[string]string)
+	p.mu.Lock()
+	current := make(map[string]string, len(devs))
+	for _, dev := range devs {
+		id := replica.PhysicalID(dev.ID)
+		if _, seen := current[id]; seen {
+			continue
+		}
+		current[id] = dev.Health
+		if dev.Health == pluginapi.Unhealthy {
+			unhealthy[id] = reasons[dev.ID]
+		}
+		last, known := p.lastHealth[id]
+		if (known && last == dev.Health) || (!known && dev.Health == pluginapi.Healthy) {
+			continue
+		}
+		t := transition{id: id, health: dev.Health, reason: reasons[dev.ID], source: sources[dev.ID]}
+		if state, ok := p.exporter.State(id); ok {
+			t.workloads = state.AssociatedWorkload
+		}
+		transitions = append(transitions, t)
+	}
+	p.lastHealth = current
+	p.mu.Unlock()
+
+	for _, t := range transitions {
+		switch {
+		case t.health == pluginapi.Healthy:
+			glog.Infof("Device %s of resource %s is healthy again", t.id, p.Resource)
+		case len(t.workloads) == 0:
+			glog.Warningf("Device %s of resource %s turned unhealthy: %s", t.id, p.Resource, t.reason)
+		default:
+			glog.Warningf("Device %s of resource %s used by %s turned unhealthy: %s", t.id, p.Resource, strings.Join(t.workloads, ", "), t.reason)
+		}
+		p.reporter.DeviceHealthChanged(p.Resource, t.id, t.health, t.reason, t.source, t.workloads)
+	}
+	p.reporter.SetUnhealthy(p.Resource, unhealthy)
 }
 
-// PreStartContainer is expected to be called before each container start if indicated by plugin during registration phase.
-// PreStartContainer allows kubelet to pass reinitialized devices to containers.
-// PreStartContainer allows Device Plugin to run device specific operations on the Devices requested
-func (p *AMDGPUPlugin) PreStartContainer(ctx context.Context, r *pluginapi.PreStartContainerRequest) (*pluginapi.PreStartContainerResponse, error) {
-	return &pluginapi.PreStartContainerResponse{}, nil
+// HealthStatuses returns the last health status of the plugin's devices,
+// with the reason of the unhealthy ones
+func (p *AMDGPUPlugin) HealthStatuses() map[string]health.Status {
+	return p.healthChecker.Statuses()
 }
 
 // ListAndWatch returns a stream of List of Devices
@@ -228,100 +541,47 @@ func (p *AMDGPUPlugin) PreStartContainer(ctx context.Context, r *pluginapi.PreSt
 // returns the new list
 func (p *AMDGPUPlugin) ListAndWatch(e *pluginapi.Empty, s pluginapi.DevicePlugin_ListAndWatchServer) error {
 
-	p.AMDGPUs = amdgpu.GetAMDGPUs()
-
-	glog.Infof("Found %d AMDGPUs", len(p.AMDGPUs))
-
-	devs := make([]*pluginapi.Device, len(p.AMDGPUs))
-	var isHomogeneous bool
-	isHomogeneous = amdgpu.IsHomogeneous()
-	// Initialize a map to store partitionType based device list
-	resourceTypeDevs := make(map[string][]*pluginapi.Device)
-
-	if isHomogeneous {
-		// limit scope for hwloc
-		func() {
-			i := 0
-			for id, device := range p.AMDGPUs {
-				dev := &pluginapi.Device{
-					ID:     id,
-					Health: pluginapi.Healthy,
-				}
-				devs[i] = dev
-				i++
-
-				numas := []int64{int64(device["numaNode"].(int))}
-				glog.Infof("Watching GPU with bus ID: %s NUMA Node: %+v", id, numas)
-
-				numaNodes := make([]*pluginapi.NUMANode, len(numas))
-				for j, v := range numas {
-					numaNodes[j] = &pluginapi.NUMANode{
-						ID: int64(v),
-					}
-				}
-
-				dev.Topology = &pluginapi.TopologyInfo{
-					Nodes: numaNodes,
-				}
-			}
-		}()
+	p.mu.Lock()
+	if p.streams > 0 {
+		metrics.IncListAndWatchReconnects(p.Resource)
+	}
+	p.streams++
+	streams := p.streams
+	devs := p.discoverDevices()
+	p.mu.Unlock()
+	var reasons map[string]string
+	send := func() {
 		s.Send(&pluginapi.ListAndWatchResponse{Devices: devs})
-	} else {
-		func() {
-			for id, device := range p.AMDGPUs {
-				dev := &pluginapi.Device{
-					ID:     id,
-					Health: pluginapi.Healthy,
-				}
-				// Append a device belonging to a certain partition type to its respective list
-				partitionType := device["computePartitionType"].(string) + "_" + device["memoryPartitionType"].(string)
-				resourceTypeDevs[partitionType] = append(resourceTypeDevs[partitionType], dev)
-
-				numas := []int64{int64(device["numaNode"].(int))}
-				glog.Infof("Watching GPU with bus ID: %s NUMA Node: %+v", id, numas)
-
-				numaNodes := make([]*pluginapi.NUMANode, len(numas))
-				for j, v := range numas {
-					numaNodes[j] = &pluginapi.NUMANode{
-						ID: int64(v),
-					}
-				}
-
-				dev.Topology = &pluginapi.TopologyInfo{
-					Nodes: numaNodes,
-				}
-			}
-		}()
-		// Send the appropriate list of devices based on the partitionType
-		if devList, exists := resourceTypeDevs[p.Resource]; exists {
-			s.Send(&pluginapi.ListAndWatchResponse{Devices: devList})
-		}
+		metrics.SetDevices(p.Resource, devs, reasons)
 	}
+	send()
 
 loop:
 	for {
 		select {
 		case <-p.Heartbeat:
-			var health = pluginapi.Unhealthy
-
-			if simpleHealthCheck() {
-				health = pluginapi.Healthy
-			}
-
-			// update with per device GPU health status
-			if isHomogeneous {
-				exporter.PopulatePerGPUDHealth(devs, health)
-				s.Send(&pluginapi.ListAndWatchResponse{Devices: devs})
-			} else {
-				if devList, exists := resourceTypeDevs[p.Resource]; exists {
-					exporter.PopulatePerGPUDHealth(devList, health)
-					s.Send(&pluginapi.ListAndWatchResponse{Devices: devList})
-				}
-			}
+			p.exporter.Refresh()
+			reasons = p.updateHealth(devs)
+			send()
+
+		case <-p.healthUpdates:
+			glog.V(3).Infof("amd-metrics-exporter health changed, refreshing health for resource %s", p.Resource)
+			reasons = p.updateHealth(devs)
+			send()
+
+		case <-p.deviceUpdates:
+			glog.Infof("GPU configuration changed, refreshing devices for resource %s", p.Resource)
+			p.mu.Lock()
+			devs = p.discoverDevices()
+			p.initAllocator()
+			p.mu.Unlock()
+			reasons = nil
+			send()
 
 		case <-s.Context().Done():
-			glog.Errorf("ListAndWatch stream disconnected: %v, exiting to trigger re-registration", s.Context().Err())
-			os.Exit(1)
+			glog.Warningf("ListAndWatch stream of %s closed: %v, waiting for the kubelet to reconnect", p.Resource, s.Context().Err())
+			p.awaitReconnect(streams)
+			break loop
 
 		case <-p.signal:
 			glog.Infof("Received signal, exiting")
@@ -338,10 +598,23 @@ loop:
 // guaranteed to be the allocation ultimately performed by the
 // devicemanager. It is only designed to help the devicemanager make a more
 // informed allocation decision when possible.
-func (p *AMDGPUPlugin) GetPreferredAllocation(ctx context.Context, req *pluginapi.PreferredAllocationRequest) (*pluginapi.PreferredAllocationResponse, error) {
+func (p *AMDGPUPlugin) GetPreferredAllocation(ctx context.Context, req *pluginapi.PreferredAllocationRequest) (_ *pluginapi.PreferredAllocationResponse, err error) {
+	defer func(start time.Time) {
+		metrics.ObserveRequest(p.Resource, "GetPreferredAllocation", start, err)
+	}(time.Now())
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
 	response := &pluginapi.PreferredAllocationResponse{}
 	for _, req := range req.ContainerRequests {
-		allocated_ids, err := p.devAllocator.Allocate(req.AvailableDeviceIDs, req.MustIncludeDeviceIDs, int(req.AllocationSize))
+		var allocated_ids []string
+		if p.devAllocator == nil {
+			return nil, fmt.Errorf("preferred allocation is disabled for %s", p.Resource)
+		} else if p.replicas > 1 {
+			allocated_ids, err = spreadReplicas(req.AvailableDeviceIDs, req.MustIncludeDeviceIDs, int(req.AllocationSize))
+		} else {
+			allocated_ids, err = p.devAllocator.Allocate(req.AvailableDeviceIDs, req.MustIncludeDeviceIDs, int(req.AllocationSize))
+		}
 		if err != nil {
 			glog.Errorf("unable to get preferred allocation list. Error:%v", err)
 			return nil, fmt.Errorf("unable to get preferred allocation list. Error:%v", err)
@@ -357,31 +630,46 @@ func (p *AMDGPUPlugin) GetPreferredAllocation(ctx context.Context, req *pluginap
 // Allocate is called during container creation so that the Device
 // Plugin can run device specific operations and instruct Kubelet
 // of the steps to make the Device available in the container
-func (p *AMDGPUPlugin) Allocate(ctx context.Context, r *pluginapi.AllocateRequest) (*pluginapi.AllocateResponse, error) {
+func (p *AMDGPUPlugin) Allocate(ctx context.Context, r *pluginapi.AllocateRequest) (_ *pluginapi.AllocateResponse, err error) {
+	defer func(start time.Time) {
+		metrics.ObserveRequest(p.Resource, "Allocate", start, err)
+	}(time.Now())
+
 	var response pluginapi.AllocateResponse
 	var car pluginapi.ContainerAllocateResponse
 	var dev *pluginapi.DeviceSpec
 
+	p.mu.RLock()
+	defer p.mu.RUnlock()
 	for _, req := range r.ContainerRequests {
 		car = pluginapi.ContainerAllocateResponse{}
+		for _, id := range req.DevicesIDs {
+			glog.Infof("Allocating device ID: %s", id)
+		}
+		gpus := p.lookupGPUs(req.DevicesIDs)
+		car.Envs = allocateEnvs(p.env, gpus)
+
+		if p.cdiVendor != "" {
+			car.CDIDevices = p.allocateCDIDevices(gpus)
+			response.ContainerResponses = append(response.ContainerResponses, &car)
+			continue
+		}
 
 		// Currently, there are only 1 /dev/kfd per nodes regardless of the # of GPU available
 		// for compute/rocm/HSA use cases
-		dev = new(pluginapi.DeviceSpec)
-		dev.HostPath = "/dev/kfd"
-		dev.ContainerPath = "/dev/kfd"
-		dev.Permissions = "rw"
-		car.Devices = append(car.Devices, dev)
-
-		for _, id := range req.DevicesIDs {
-			glog.Infof("Allocating device ID: %s", id)
+		if p.injectKFD {
+			dev = new(pluginapi.DeviceSpec)
+			dev.HostPath = "/dev/kfd"
+			dev.ContainerPath = "/dev/kfd"
+			dev.Permissions = "rw"
+			car.Devices = append(car.Devices, dev)
+		}
 
-			for k, v := range p.AMDGPUs[id] {
-				// Map struct previously only had 'card' and 'renderD' and only those are paths to be appended as before
-				if k != "card" && k != "renderD" {
-					continue
-				}
-				devpath := fmt.Sprintf("/dev/dri/%s%d", k, v)
+		for _, gpu := range gpus {
+			for _, devpath := range []string{
+				fmt.Sprintf("/dev/dri/card%d", gpu.Card),
+				fmt.Sprintf("/dev/dri/renderD%d", gpu.RenderD),
+			} {
 				dev = new(pluginapi.DeviceSpec)
 				dev.HostPath = devpath
 				dev.ContainerPath = devpath
@@ -396,6 +684,40 @@ func (p *AMDGPUPlugin) Allocate(ctx context.Context, r *pluginapi.AllocateReques
 	return &response, nil
 }
 
+// lookupGPUs returns the GPUs or partitions behind the given device IDs, in
+// order. Replicas of a time-sliced device are returned once since they share
+// the same device. It must be called with mu held.
+func (p *AMDGPUPlugin) lookupGPUs(ids []string) []*amdgpu.GPU {
+	var gpus []*amdgpu.GPU
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		physical := replica.PhysicalID(id)
+		gpu, ok := p.AMDGPUs[physical]
+		if !ok {
+			glog.Errorf("Unknown device ID: %s", id)
+			continue
+		}
+		if seen[physical] {
+			continue
+		}
+		seen[physical] = true
+		gpus = append(gpus, gpu)
+	}
+	return gpus
+}
+
+// allocateCDIDevices returns the CDI devices of the allocated GPUs. /dev/kfd
+// is part of the spec's container edits.
+func (p *AMDGPUPlugin) allocateCDIDevices(gpus []*amdgpu.GPU) []*pluginapi.CDIDevice {
+	devices := make([]*pluginapi.CDIDevice, 0, len(gpus))
+	for _, gpu := range gpus {
+		devices = append(devices, &pluginapi.CDIDevice{
+			Name: cdi.QualifiedName(p.cdiVendor, gpu.Id),
+		})
+	}
+	return devices
+}
+
 // Lister serves as an interface between imlementation and Manager machinery. User passes
 // implementation of this interface to NewManager function. Manager will use it to obtain resource
 // namespace, monitor available resources and instantate a new plugin for them.
@@ -403,12 +725,62 @@ type AMDGPULister struct {
 	ResUpdateChan chan dpm.PluginNameList
 	Heartbeat     chan bool
 	Signal        chan os.Signal
+	// Watcher, if set, is used by the plugins to follow GPU hot-plug and
+	// repartitioning
+	Watcher *amdgpu.Watcher
+	// Exporter, if set, is used by the plugins to follow the health
+	// reported by the amd-metrics-exporter
+	Exporter *exporter.Client
+	// Reporter, if set, is used by the plugins to report the health of
+	// their devices on the node
+	Reporter *nodehealth.Reporter
+	// Config is the configuration resolved for this node. The defaults are
+	// used if it is not set.
+	Config *config.Config
+
+	once sync.Once
+	// reregister receives the resources to register with the kubelet again
+	reregister chan string
+	mu         sync.Mutex
+	// advertised holds the resources last passed to the manager
+	advertised dpm.PluginNameList
+	// plugins holds the plugins of the advertised resources so that they
+	// keep their state when they are re-registered
+	plugins map[string]*AMDGPUPlugin
+}
+
+func (l *AMDGPULister) init() {
+	l.once.Do(func() {
+		l.reregister = make(chan string)
+		l.plugins = map[string]*AMDGPUPlugin{}
+	})
+}
+
+// Reregister registers the plugin of a resource with the kubelet again by
+// removing it from the resources passed to the manager and adding it back.
+// It returns false if the resource is no longer advertised.
+func (l *AMDGPULister) Reregister(resourceLastName string) bool {
+	l.init()
+	l.mu.Lock()
+	advertised := slices.Contains(l.advertised, resourceLastName)
+	l.mu.Unlock()
+	if advertised {
+		l.reregister <- resourceLastName
+	}
+	return advertised
+}
+
+func (l *AMDGPULister) config() *config.Config {
+	if l.Config == nil {
+		return config.Default()
+	}
+	return l.Config
 }
 
 // GetResourceNamespace must return namespace (vendor ID) of implemented Lister. e.g. for
 // resources in format "color.example.com/<color>" that would be "color.example.com".
 func (l *AMDGPULister) GetResourceNamespace() string {
-	return "amd.com"
+	return l.config().ResourceNamespace
 }
 
 // Discover notifies manager with a list of currently available resources in its namespace.
@@ -418,10 +790,35 @@ func (l *AMDGPULister) GetResourceNamespace() string {
 // dynamic, it could block and pass a new list each times resources changed. If blocking is
 // used, it should check whether the channel is closed, i.e. Discover should stop.
 func (l *AMDGPULister) Discover(pluginListCh chan dpm.PluginNameList) {
+	l.init()
 	for {
 		select {
 		case newResourcesList := <-l.ResUpdateChan: // New resources found
-			pluginListCh <- newResourcesList
+			// time-sliced resources may be advertised under another name
+			cfg := l.config()
+			advertised := make(dpm.PluginNameList, 0, len(newResourcesList))
+			for _, name := range newResourcesList {
+				advertised = append(advertised, cfg.AdvertisedName(name))
+			}
+			l.mu.Lock()
+			l.advertised = advertised
+			// resources advertised again later get new plugins
+			for name := range l.plugins {
+				if !slices.Contains(advertised, name) {
+					delete(l.plugins, name)
+				}
+			}
+			l.mu.Unlock()
+			pluginListCh <- advertised
+		case name := <-l.reregister:
+			// the manager stops the plugins of the resources missing from
+			// the list, and starts and registers the new ones
+			l.mu.Lock()
+			advertised := slices.Clone(l.advertised)
+			l.mu.Unlock()
+			glog.Infof("Re-registering %s", name)
+			pluginListCh <- slices.DeleteFunc(slices.Clone(advertised), func(n string) bool { return n == name })
+			pluginListCh <- advertised
 		case <-pluginListCh: // Stop message received
 			// Stop resourceUpdateCh
 			return
@@ -433,10 +830,52 @@ func (l *AMDGPULister) Discover(pluginListCh chan dpm.PluginNameList) {
 // e.g. for resource name "color.example.com/red" that would be "red". It must return valid
 // implementation of a PluginInterface.
 func (l *AMDGPULister) NewPlugin(resourceLastName string) dpm.PluginInterface {
+	l.init()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if p, ok := l.plugins[resourceLastName]; ok {
+		return p
+	}
+	cfg := l.config()
+	resource := resourceLastName
+	replicas := 1
+	if shared := cfg.SharedResource(resourceLastName); shared != nil {
+		resource = shared.Name
+		replicas = shared.Replicas
+		glog.Infof("Advertising %d replicas of each %s device as %s", replicas, resource, resourceLastName)
+	}
+	policy := cfg.AllocatorPolicy(resourceLastName)
+	devAllocator, err := allocator.NewPolicy(policy, cfg.Allocator.Weights)
+	if err != nil {
+		glog.Errorf("%v, using the %s policy for %s", err, config.PolicyBestEffort, resourceLastName)
+		devAllocator = allocator.NewBestEffortPolicy()
+	} else {
+		glog.Infof("Using the %s allocator policy for %s", policy, resourceLastName)
+	}
 	options := []AMDGPUPluginOption{
 		WithHeartbeat(l.Heartbeat),
-		WithResource(resourceLastName),
-		WithAllocator(allocator.NewBestEffortPolicy()),
+		WithResource(resource),
+		WithReplicas(replicas),
+		WithAllocator(devAllocator),
+		WithKFDInjection(cfg.Allocate.InjectKFD),
+		WithEnv(cfg.Allocate.Env),
+		WithPreStart(cfg.PreStart),
+		WithHealthChecker(health.New(cfg.Health)),
+		WithReregister(func() bool { return l.Reregister(resourceLastName) }),
+	}
+	if cfg.CDI.AllocateDevices {
+		options = append(options, WithCDIDevices(cfg.ResourceNamespace))
+	}
+	if l.Watcher != nil {
+		options = append(options, WithWatcher(l.Watcher))
+	}
+	if l.Exporter != nil {
+		options = append(options, WithExporter(l.Exporter))
+	}
+	if l.Reporter != nil {
+		options = append(options, WithReporter(l.Reporter))
 	}
-	return NewAMDGPUPlugin(options...)
+	p := NewAMDGPUPlugin(options...)
+	l.plugins[resourceLastName] = p
+	return p
 }
@@ -17,7 +17,20 @@
 package plugin
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/amdgpu"
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/config"
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/replica"
+	"github.com/kubevirt/device-plugin-manager/pkg/dpm"
+	"golang.org/x/net/context"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
 )
 
 func TestCountGPUDevFromTopology(t *testing.T) {
@@ -28,3 +41,417 @@ func TestCountGPUDevFromTopology(t *testing.T) {
 		t.Errorf("Count was incorrect, got: %d, want: %d.", count, expCount)
 	}
 }
+
+func TestGetDeviceList(t *testing.T) {
+	gpus := map[string]*amdgpu.GPU{
+		"0000:0a:00.0": {Id: "0000:0a:00.0", ComputePartitionType: "spx", MemoryPartitionType: "nps1"},
+		"0000:80:00.0": {Id: "0000:80:00.0", ComputePartitionType: "cpx", MemoryPartitionType: "nps1"},
+		"amdgpu_xcp_1": {Id: "amdgpu_xcp_1", ParentId: "0000:80:00.0", ComputePartitionType: "cpx", MemoryPartitionType: "nps1"},
+	}
+	testcases := []struct {
+		resource string
+		gpus     map[string]*amdgpu.GPU
+		expIds   []string
+	}{
+		{"spx_nps1", gpus, []string{"0000:0a:00.0"}},
+		{"cpx_nps1", gpus, []string{"0000:80:00.0", "amdgpu_xcp_1"}},
+		{"gpu", map[string]*amdgpu.GPU{"0000:0a:00.0": gpus["0000:0a:00.0"]}, []string{"0000:0a:00.0"}},
+	}
+	for _, tc := range testcases {
+		p := NewAMDGPUPlugin(WithResource(tc.resource))
+		p.AMDGPUs = tc.gpus
+		var ids []string
+		for _, dev := range p.getDeviceList() {
+			ids = append(ids, dev.ID)
+		}
+		sort.Strings(ids)
+		if len(ids) != len(tc.expIds) {
+			t.Errorf("Device list for %s was incorrect, got: %v, want: %v", tc.resource, ids, tc.expIds)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != tc.expIds[i] {
+				t.Errorf("Device list for %s was incorrect, got: %v, want: %v", tc.resource, ids, tc.expIds)
+				break
+			}
+		}
+	}
+}
+
+func TestGetDeviceListTopology(t *testing.T) {
+	p := NewAMDGPUPlugin(WithResource("gpu"))
+	p.AMDGPUs = map[string]*amdgpu.GPU{
+		"0000:0a:00.0": {Id: "0000:0a:00.0", NumaNode: 0, NumaNodes: []int{0}},
+		"0000:80:00.0": {Id: "0000:80:00.0", NumaNode: 0, NumaNodes: []int{0, 1}},
+		"0000:c0:00.0": {Id: "0000:c0:00.0", NumaNode: -1},
+	}
+	expNodes := map[string][]int64{
+		"0000:0a:00.0": {0},
+		"0000:80:00.0": {0, 1},
+		"0000:c0:00.0": nil,
+	}
+	for _, dev := range p.getDeviceList() {
+		var nodes []int64
+		if dev.Topology != nil {
+			for _, n := range dev.Topology.Nodes {
+				nodes = append(nodes, n.ID)
+			}
+		}
+		if fmt.Sprint(nodes) != fmt.Sprint(expNodes[dev.ID]) || (dev.Topology == nil) != (expNodes[dev.ID] == nil) {
+			t.Errorf("Topology of %s was incorrect, got: %v, want: %v", dev.ID, nodes, expNodes[dev.ID])
+		}
+	}
+}
+
+func TestGetDeviceListReplicas(t *testing.T) {
+	p := NewAMDGPUPlugin(WithResource("gpu"), WithReplicas(3))
+	p.AMDGPUs = map[string]*amdgpu.GPU{
+		"0000:0a:00.0": {Id: "0000:0a:00.0", Card: 1, RenderD: 128},
+		"0000:80:00.0": {Id: "0000:80:00.0", Card: 2, RenderD: 129},
+	}
+	devs := p.getDeviceList()
+	if len(devs) != 6 {
+		t.Fatalf("Expected 6 replicas, got: %d", len(devs))
+	}
+	for _, dev := range devs {
+		if _, ok := p.AMDGPUs[replica.PhysicalID(dev.ID)]; !ok || !strings.Contains(dev.ID, replica.Separator) {
+			t.Errorf("Unexpected replica ID: %s", dev.ID)
+		}
+	}
+
+	resp, err := p.Allocate(context.Background(), &pluginapi.AllocateRequest{
+		ContainerRequests: []*pluginapi.ContainerAllocateRequest{
+			{DevicesIDs: []string{"0000:0a:00.0::0", "0000:0a:00.0::2"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("expected Allocate to pass. But failed with error %v", err)
+	}
+	var paths []string
+	for _, dev := range resp.ContainerResponses[0].Devices {
+		paths = append(paths, dev.HostPath)
+	}
+	expPaths := []string{"/dev/kfd", "/dev/dri/card1", "/dev/dri/renderD128"}
+	if strings.Join(paths, ",") != strings.Join(expPaths, ",") {
+		t.Errorf("Allocated device nodes were incorrect, got: %v, want: %v", paths, expPaths)
+	}
+}
+
+func TestSpreadReplicas(t *testing.T) {
+	available := []string{
+		"a::0", "a::1", "a::2",
+		"b::0", "b::1",
+		"c::0",
+	}
+	testcases := []struct {
+		description string
+		mustInclude []string
+		size        int
+		expGPUs     []string
+	}{
+		{"single replica from the least used gpu", nil, 1, []string{"a"}},
+		{"distinct gpus", nil, 3, []string{"a", "b", "c"}},
+		{"distinct gpus first", nil, 4, []string{"a", "a", "b", "c"}},
+		{"must include", []string{"c::0"}, 2, []string{"a", "c"}},
+		{"must include replicas of one gpu", []string{"a::0", "a::1"}, 3, []string{"a", "a", "b"}},
+		{"all", nil, 6, []string{"a", "a", "a", "b", "b", "c"}},
+	}
+	for _, tc := range testcases {
+		ids, err := spreadReplicas(available, tc.mustInclude, tc.size)
+		if err != nil {
+			t.Errorf("%s: expected spreadReplicas to pass. But failed with error %v", tc.description, err)
+			continue
+		}
+		var gpus []string
+		for _, id := range ids {
+			gpus = append(gpus, replica.PhysicalID(id))
+		}
+		sort.Strings(gpus)
+		if strings.Join(gpus, ",") != strings.Join(tc.expGPUs, ",") {
+			t.Errorf("%s: allocation was incorrect, got: %v, want GPUs: %v", tc.description, ids, tc.expGPUs)
+		}
+	}
+	if _, err := spreadReplicas(available, nil, 7); err == nil {
+		t.Errorf("Expected spreadReplicas to fail when more devices are requested than available")
+	}
+}
+
+func TestAllocateCDIDevices(t *testing.T) {
+	p := NewAMDGPUPlugin(WithResource("gpu"), WithReplicas(2), WithCDIDevices("amd.com"))
+	p.AMDGPUs = map[string]*amdgpu.GPU{
+		"0000:0a:00.0": {Id: "0000:0a:00.0", Card: 1, RenderD: 128},
+		"0000:80:00.0": {Id: "0000:80:00.0", Card: 2, RenderD: 129},
+	}
+	resp, err := p.Allocate(context.Background(), &pluginapi.AllocateRequest{
+		ContainerRequests: []*pluginapi.ContainerAllocateRequest{
+			{DevicesIDs: []string{"0000:0a:00.0::0", "0000:0a:00.0::1", "0000:80:00.0::1"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("expected Allocate to pass. But failed with error %v", err)
+	}
+	car := resp.ContainerResponses[0]
+	if len(car.Devices) != 0 {
+		t.Errorf("Expected no device nodes with CDI, got: %v", car.Devices)
+	}
+	var names []string
+	for _, dev := range car.CDIDevices {
+		names = append(names, dev.Name)
+	}
+	expNames := []string{"amd.com/gpu=0000:0a:00.0", "amd.com/gpu=0000:80:00.0"}
+	if strings.Join(names, ",") != strings.Join(expNames, ",") {
+		t.Errorf("CDI devices were incorrect, got: %v, want: %v", names, expNames)
+	}
+}
+
+func TestAllocateEnvs(t *testing.T) {
+	env := []config.EnvVar{
+		{Name: "ROCR_VISIBLE_DEVICES", Value: config.EnvIndex},
+		{Name: "AMD_GPU_IDS", Value: config.EnvUniqueID},
+		{Name: "AMD_GPU_RENDER_MINORS", Value: config.EnvRenderMinor},
+		{Name: "AMD_GPU_PCI_BUS_IDS", Value: config.EnvPCIBusID},
+		{Name: "AMD_GPU_PARTITION_MODES", Value: config.EnvPartitionMode},
+	}
+	p := NewAMDGPUPlugin(WithResource("cpx_nps1"), WithEnv(env))
+	p.AMDGPUs = map[string]*amdgpu.GPU{
+		"0000:80:00.0": {Id: "0000:80:00.0", PciAddress: "0000:80:00.0", UniqueId: "9a5ff1b27bd1b5e3", RenderD: 136, ComputePartitionType: "cpx", MemoryPartitionType: "nps1"},
+		"amdgpu_xcp_1": {Id: "amdgpu_xcp_1", ParentId: "0000:80:00.0", PciAddress: "0000:80:00.0", UniqueId: "9a5ff1b27bd1b5e3", PartitionIndex: 1, RenderD: 137, ComputePartitionType: "cpx", MemoryPartitionType: "nps1"},
+		"amdgpu_xcp_2": {Id: "amdgpu_xcp_2", ParentId: "0000:80:00.0", PciAddress: "0000:80:00.0", UniqueId: "9a5ff1b27bd1b5e3", PartitionIndex: 2, RenderD: 138, ComputePartitionType: "cpx", MemoryPartitionType: "nps1"},
+		"0000:0a:00.0": {Id: "0000:0a:00.0", PciAddress: "0000:0a:00.0", RenderD: 128},
+	}
+	resp, err := p.Allocate(context.Background(), &pluginapi.AllocateRequest{
+		ContainerRequests: []*pluginapi.ContainerAllocateRequest{
+			{DevicesIDs: []string{"amdgpu_xcp_2", "amdgpu_xcp_1", "0000:0a:00.0", "0000:80:00.0"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("expected Allocate to pass. But failed with error %v", err)
+	}
+	// the partitions of a GPU have distinct ids
+	expEnvs := map[string]string{
+		"ROCR_VISIBLE_DEVICES":    "0,1,2,3",
+		"AMD_GPU_IDS":             ",GPU-9a5ff1b27bd1b5e3,GPU-9a5ff1b27bd1b5e3-1,GPU-9a5ff1b27bd1b5e3-2",
+		"AMD_GPU_RENDER_MINORS":   "128,136,137,138",
+		"AMD_GPU_PCI_BUS_IDS":     "0000:0a:00.0,0000:80:00.0,0000:80:00.0,0000:80:00.0",
+		"AMD_GPU_PARTITION_MODES": "none,cpx_nps1,cpx_nps1,cpx_nps1",
+	}
+	envs := resp.ContainerResponses[0].Envs
+	if len(envs) != len(expEnvs) {
+		t.Errorf("Envs were incorrect, got: %v, want: %v", envs, expEnvs)
+	}
+	for k, v := range expEnvs {
+		if envs[k] != v {
+			t.Errorf("Env %s was incorrect, got: %s, want: %s", k, envs[k], v)
+		}
+	}
+
+	// the variables are opt-in
+	p = NewAMDGPUPlugin(WithResource("gpu"), WithEnv(config.Default().Allocate.Env))
+	p.AMDGPUs = map[string]*amdgpu.GPU{"0000:0a:00.0": {Id: "0000:0a:00.0", RenderD: 128}}
+	resp, _ = p.Allocate(context.Background(), &pluginapi.AllocateRequest{
+		ContainerRequests: []*pluginapi.ContainerAllocateRequest{{DevicesIDs: []string{"0000:0a:00.0"}}},
+	})
+	if len(resp.ContainerResponses[0].Envs) != 0 {
+		t.Errorf("Expected no envs, got: %v", resp.ContainerResponses[0].Envs)
+	}
+}
+
+func TestPreStartContainer(t *testing.T) {
+	defer func(root string) { amdgpu.SysfsRoot = root }(amdgpu.SysfsRoot)
+	amdgpu.SysfsRoot = t.TempDir()
+	for node, minor := range map[string]string{"2": "128", "3": "129"} {
+		dir := amdgpu.SysfsPath("class/kfd/kfd/topology/nodes", node)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "properties"), []byte("gpu_id 1234\ndrm_render_minor "+minor+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func(f func(string) bool) { devFunctional = f }(devFunctional)
+	devFunctional = func(card string) bool { return card != "card3" }
+
+	out := filepath.Join(t.TempDir(), "out")
+	p := NewAMDGPUPlugin(WithResource("gpu"), WithPreStart(config.PreStartConfig{
+		Enabled: true,
+		Command: []string{"sh", "-c", "echo $AMD_GPU_DEVICE_ID $AMD_GPU_RENDER_MINOR >> " + out},
+		Timeout: 5,
+	}))
+	p.AMDGPUs = map[string]*amdgpu.GPU{
+		"0000:0a:00.0": {Id: "0000:0a:00.0", Card: 1, RenderD: 128, NodeId: 2},
+		"0000:80:00.0": {Id: "0000:80:00.0", Card: 2, RenderD: 129, NodeId: 2},
+		"0000:c1:00.0": {Id: "0000:c1:00.0", Card: 3, RenderD: 130, NodeId: 4},
+	}
+
+	opts, _ := p.GetDevicePluginOptions(context.Background(), &pluginapi.Empty{})
+	if !opts.PreStartRequired {
+		t.Errorf("Expected PreStartRequired to be advertised")
+	}
+
+	testcases := []struct {
+		description string
+		ids         []string
+		expErr      bool
+	}{
+		{"healthy device", []string{"0000:0a:00.0"}, false},
+		{"kfd node of another device", []string{"0000:80:00.0"}, true},
+		{"device can not be opened", []string{"0000:c1:00.0"}, true},
+		{"unknown device", []string{"0000:0a:00.0", "0000:ff:00.0"}, true},
+	}
+	for _, tc := range testcases {
+		_, err := p.PreStartContainer(context.Background(), &pluginapi.PreStartContainerRequest{DevicesIDs: tc.ids})
+		if (err != nil) != tc.expErr {
+			t.Errorf("%s: unexpected result, got error: %v", tc.description, err)
+		}
+	}
+	if data, _ := os.ReadFile(out); string(data) != "0000:0a:00.0 128\n" {
+		t.Errorf("Pre-start command output was incorrect, got: %q", string(data))
+	}
+
+	p.preStartCommand = []string{"false"}
+	if _, err := p.PreStartContainer(context.Background(), &pluginapi.PreStartContainerRequest{DevicesIDs: []string{"0000:0a:00.0"}}); err == nil {
+		t.Errorf("Expected a failing pre-start command to fail the container start")
+	}
+}
+
+func TestAwaitReconnect(t *testing.T) {
+	defer func(socket string, backoff time.Duration) {
+		kubeletSocket, reconnectBackoff = socket, backoff
+	}(kubeletSocket, reconnectBackoff)
+	kubeletSocket = filepath.Join(t.TempDir(), "kubelet.sock")
+	reconnectBackoff = 10 * time.Millisecond
+
+	calls := make(chan struct{}, 10)
+	p := NewAMDGPUPlugin(WithResource("gpu"), WithReregister(func() bool {
+		calls <- struct{}{}
+		return true
+	}))
+	p.streams = 1
+	p.awaitReconnect(1)
+	// a second closed stream does not start another wait
+	p.awaitReconnect(1)
+
+	// nothing is re-registered until the kubelet socket exists
+	time.Sleep(50 * time.Millisecond)
+	if len(calls) != 0 {
+		t.Fatalf("Plugin was re-registered without a kubelet socket")
+	}
+	if err := os.WriteFile(kubeletSocket, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-calls:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Plugin was not re-registered")
+	}
+
+	// the wait ends once the kubelet reconnects
+	p.mu.Lock()
+	p.streams++
+	p.mu.Unlock()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		p.mu.RLock()
+		reconnecting := p.reconnecting
+		p.mu.RUnlock()
+		if !reconnecting {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Plugin kept waiting after the kubelet reconnected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAwaitReconnectStopped(t *testing.T) {
+	defer func(socket string, backoff time.Duration) {
+		kubeletSocket, reconnectBackoff = socket, backoff
+	}(kubeletSocket, reconnectBackoff)
+	kubeletSocket = filepath.Join(t.TempDir(), "kubelet.sock")
+	reconnectBackoff = 10 * time.Millisecond
+
+	calls := make(chan struct{}, 10)
+	p := NewAMDGPUPlugin(WithResource("gpu"), WithReregister(func() bool {
+		calls <- struct{}{}
+		return true
+	}))
+	reconnecting := func() bool {
+		p.mu.RLock()
+		defer p.mu.RUnlock()
+		return p.reconnecting
+	}
+	waitFor := func(expected bool, msg string) {
+		deadline := time.Now().Add(2 * time.Second)
+		for reconnecting() != expected {
+			if time.Now().After(deadline) {
+				t.Fatal(msg)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	p.streams = 1
+	p.awaitReconnect(1)
+
+	// the wait ends with the plugin while the kubelet socket is missing
+	if err := p.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(false, "Plugin kept waiting after it was stopped")
+
+	// and goes on once it is started again, ex: when it is re-registered
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(true, "Plugin did not wait for the kubelet once started again")
+	if err := os.WriteFile(kubeletSocket, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-calls:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Plugin was not re-registered")
+	}
+	if err := p.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(false, "Plugin kept waiting after it was stopped")
+}
+
+func TestListerReregister(t *testing.T) {
+	l := &AMDGPULister{ResUpdateChan: make(chan dpm.PluginNameList)}
+	pluginListCh := make(chan dpm.PluginNameList)
+	go l.Discover(pluginListCh)
+
+	l.ResUpdateChan <- dpm.PluginNameList{"cpx_nps1", "spx_nps1"}
+	if got := <-pluginListCh; strings.Join(got, ",") != "cpx_nps1,spx_nps1" {
+		t.Fatalf("Advertised resources were incorrect, got: %v", got)
+	}
+	p := l.NewPlugin("cpx_nps1")
+
+	if l.Reregister("gpu") {
+		t.Errorf("Resource that is not advertised was re-registered")
+	}
+	go l.Reregister("cpx_nps1")
+	if got := <-pluginListCh; strings.Join(got, ",") != "spx_nps1" {
+		t.Errorf("Resources without the re-registered one were incorrect, got: %v", got)
+	}
+	if got := <-pluginListCh; strings.Join(got, ",") != "cpx_nps1,spx_nps1" {
+		t.Errorf("Resources after re-registration were incorrect, got: %v", got)
+	}
+	if l.NewPlugin("cpx_nps1") != p {
+		t.Errorf("Re-registered resource did not keep its plugin")
+	}
+
+	// resources that are advertised again after being removed get new plugins
+	l.ResUpdateChan <- dpm.PluginNameList{"spx_nps1"}
+	<-pluginListCh
+	l.ResUpdateChan <- dpm.PluginNameList{"cpx_nps1", "spx_nps1"}
+	<-pluginListCh
+	if l.NewPlugin("cpx_nps1") == p {
+		t.Errorf("Removed resource kept its plugin")
+	}
+}
@@ -0,0 +1,68 @@
+/**
+ * Copyright 2025 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package plugin
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/amdgpu"
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/health"
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+)
+
+// devFunctional checks that a card can be opened through libdrm, tests
+// replace it to run without GPUs
+var devFunctional = amdgpu.DevFunctional
+
+// checkDevice verifies that a GPU or partition can be handed to a container:
+// its card opens through libdrm and its KFD node is present and still backed
+// by its render node
+func checkDevice(gpu *amdgpu.GPU) error {
+	card := fmt.Sprintf("card%d", gpu.Card)
+	if !devFunctional(card) {
+		return fmt.Errorf("device %s is not functional: unable to open %s", gpu.Id, card)
+	}
+	if err := health.CheckKFDNode(gpu); err != nil {
+		return fmt.Errorf("device %s has no KFD node: %v", gpu.Id, err)
+	}
+	return nil
+}
+
+// runPreStartCommand runs the configured pre-start command for a device
+func runPreStartCommand(ctx context.Context, command []string, timeout time.Duration, gpu *amdgpu.GPU) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Env = append(os.Environ(),
+		"AMD_GPU_DEVICE_ID="+gpu.Id,
+		"AMD_GPU_PCI_BUS_ID="+gpu.PciAddress,
+		fmt.Sprintf("AMD_GPU_CARD=%d", gpu.Card),
+		fmt.Sprintf("AMD_GPU_RENDER_MINOR=%d", gpu.RenderD),
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("pre-start command %v failed for device %s: %v: %s", command, gpu.Id, err, strings.TrimSpace(string(out)))
+	}
+	glog.Infof("Pre-start command %v succeeded for device %s", command, gpu.Id)
+	return nil
+}
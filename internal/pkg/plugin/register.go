@@ -0,0 +1,121 @@
+/**
+ * Copyright 2025 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package plugin
+
+import (
+	"os"
+	"time"
+
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/metrics"
+	"github.com/golang/glog"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+var (
+	// kubeletSocket is the registration socket of the kubelet, the plugin
+	// is only re-registered once it exists
+	kubeletSocket = pluginapi.KubeletSocket
+	// reconnectBackoff is how long the plugin waits for the kubelet to
+	// open a new ListAndWatch stream after the previous one closed before
+	// asking to be re-registered. It doubles after every attempt up to
+	// maxReconnectBackoff.
+	reconnectBackoff    = 10 * time.Second
+	maxReconnectBackoff = 5 * time.Minute
+)
+
+// WithReregister sets the function called to register the plugin with the
+// kubelet again when the kubelet does not reconnect on its own. It returns
+// false if the plugin is no longer advertised.
+func WithReregister(reregister func() bool) AMDGPUPluginOption {
+	return func(p *AMDGPUPlugin) {
+		p.reregister = reregister
+	}
+}
+
+// awaitReconnect is called when the ListAndWatch stream opened as the
+// streams-th one closed. The device plugin manager starts the plugin server
+// again and registers it when the kubelet socket is re-created, after which
+// the kubelet opens a new stream. If that does not happen, for example
+// because the registration failed while the kubelet was still starting,
+// the plugin is re-registered with an exponential backoff until a new
+// stream is opened.
+func (p *AMDGPUPlugin) awaitReconnect(streams int) {
+	if p.reregister == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.reconnecting {
+		return
+	}
+	p.awaitedStreams = streams
+	p.backoff = reconnectBackoff
+	p.startReconnect()
+}
+
+// startReconnect waits for the kubelet to open a stream after the
+// awaitedStreams-th one until the plugin is stopped. Re-registering the
+// plugin stops and starts it again, the wait then goes on with the new
+// start, and ends with the plugin if it is no longer advertised. It must be
+// called with mu held.
+func (p *AMDGPUPlugin) startReconnect() {
+	p.reconnecting = true
+	stop, streams, backoff := p.stop, p.awaitedStreams, p.backoff
+	go func() {
+		done := false
+		defer func() {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			p.reconnecting = false
+			p.backoff = backoff
+			if done {
+				p.awaitedStreams = 0
+			} else if p.stop != nil {
+				// the plugin was started again since it was stopped
+				p.startReconnect()
+			}
+		}()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > maxReconnectBackoff {
+				backoff = maxReconnectBackoff
+			}
+			p.mu.RLock()
+			reconnected := p.streams > streams
+			p.mu.RUnlock()
+			if reconnected {
+				done = true
+				return
+			}
+			if _, err := os.Stat(kubeletSocket); err != nil {
+				glog.Warningf("Waiting for the kubelet socket to re-register %s: %v", p.Resource, err)
+				continue
+			}
+			glog.Warningf("kubelet did not reconnect to %s, re-registering", p.Resource)
+			metrics.IncReregistrations(p.Resource)
+			if !p.reregister() {
+				glog.Infof("%s is no longer advertised, not re-registering", p.Resource)
+				done = true
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,90 @@
+/**
+ * Copyright 2025 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package plugin
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/ROCm/k8s-device-plugin/internal/pkg/replica"
+)
+
+// uniquePhysicalIDs returns the distinct devices behind a list of IDs
+func uniquePhysicalIDs(ids []string) map[string]bool {
+	res := make(map[string]bool)
+	for _, id := range ids {
+		res[replica.PhysicalID(id)] = true
+	}
+	return res
+}
+
+// spreadReplicas picks size replica IDs out of the available ones, starting
+// with the ones that must be included. Replicas of the GPUs least used by the
+// selection are picked first so that a container gets distinct GPUs when
+// possible, and among those the GPUs with the most free replicas so that
+// load is spread across the node.
+func spreadReplicas(available, mustInclude []string, size int) ([]string, error) {
+	if size <= 0 || size > len(available) {
+		return nil, fmt.Errorf("allocation size %d is invalid for %d available devices", size, len(available))
+	}
+	if len(mustInclude) > size {
+		return nil, fmt.Errorf("%d devices must be included in an allocation of size %d", len(mustInclude), size)
+	}
+
+	selected := make(map[string]bool)
+	used := make(map[string]int)
+	free := make(map[string]int)
+	for _, id := range available {
+		free[replica.PhysicalID(id)]++
+	}
+	res := make([]string, 0, size)
+	pick := func(id string) {
+		selected[id] = true
+		used[replica.PhysicalID(id)]++
+		free[replica.PhysicalID(id)]--
+		res = append(res, id)
+	}
+	for _, id := range mustInclude {
+		if !selected[id] {
+			pick(id)
+		}
+	}
+
+	candidates := append([]string{}, available...)
+	sort.Strings(candidates)
+	for len(res) < size {
+		best := ""
+		for _, id := range candidates {
+			if selected[id] {
+				continue
+			}
+			if best == "" {
+				best = id
+				continue
+			}
+			gpu, bestGPU := replica.PhysicalID(id), replica.PhysicalID(best)
+			if used[gpu] < used[bestGPU] || (used[gpu] == used[bestGPU] && free[gpu] > free[bestGPU]) {
+				best = id
+			}
+		}
+		if best == "" {
+			return nil, fmt.Errorf("not enough available devices for an allocation of size %d", size)
+		}
+		pick(best)
+	}
+	return res, nil
+}
@@ -0,0 +1,39 @@
+/**
+ * Copyright 2025 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+// Package replica names the replicas advertised for time-sliced devices
+package replica
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Separator separates the device ID from the replica index in the IDs
+// advertised for time-sliced devices, ex: 0000:03:00.0::1
+const Separator = "::"
+
+// ID returns the ID advertised for a replica of a device
+func ID(id string, replica int) string {
+	return fmt.Sprintf("%s%s%d", id, Separator, replica)
+}
+
+// PhysicalID returns the ID of the device a replica ID refers to. IDs
+// without a replica index are returned unchanged.
+func PhysicalID(id string) string {
+	physical, _, _ := strings.Cut(id, Separator)
+	return physical
+}
@@ -0,0 +1,298 @@
+/**
+ * Copyright 2025 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+// Package snapshot captures the sysfs files GPU discovery and allocation
+// read into a gzipped tarball. Extracted, the tarball is a fake sysfs root
+// the device plugin, the node labeller and the allocation simulator can be
+// pointed at to reproduce the topology of a node.
+package snapshot
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// TopologyDir is the KFD topology, relative to the sysfs root
+	TopologyDir = "class/kfd/kfd/topology"
+	// PciDriverDir holds the GPUs bound to the amdgpu driver, relative to
+	// the sysfs root
+	PciDriverDir = "module/amdgpu/drivers/pci:amdgpu"
+	// PlatformDir holds the compute partitions of the GPUs, relative to the
+	// sysfs root
+	PlatformDir = "devices/platform"
+	// NodeDir holds the NUMA nodes, relative to the sysfs root
+	NodeDir = "devices/system/node"
+)
+
+// pciFiles are the attributes of the GPUs captured, the other ones are
+// either irrelevant to discovery or not plain text
+var pciFiles = []string{
+	"current_compute_partition",
+	"current_memory_partition",
+	"available_compute_partition",
+	"available_memory_partition",
+	"numa_node",
+	"local_cpulist",
+	"unique_id",
+	"vendor",
+	"device",
+	"product_name",
+}
+
+// archive writes the entries of a snapshot, adding the parent directories
+// of the files the first time they are seen
+type archive struct {
+	tw      *tar.Writer
+	root    string
+	dirs    map[string]bool
+	modTime time.Time
+}
+
+func (a *archive) addDir(name string) error {
+	if name == "." || a.dirs[name] {
+		return nil
+	}
+	if err := a.addDir(path.Dir(name)); err != nil {
+		return err
+	}
+	a.dirs[name] = true
+	return a.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     name + "/",
+		Mode:     0755,
+		ModTime:  a.modTime,
+	})
+}
+
+func (a *archive) addFile(name string, data []byte) error {
+	if err := a.addDir(path.Dir(name)); err != nil {
+		return err
+	}
+	if err := a.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(data)),
+		ModTime:  a.modTime,
+	}); err != nil {
+		return err
+	}
+	_, err := a.tw.Write(data)
+	return err
+}
+
+// copyFile adds a sysfs file, the size sysfs reports is not the one of its
+// content so it's read whole. Files that can't be read, some attributes
+// failing on purpose, are skipped.
+func (a *archive) copyFile(name string) error {
+	return a.copyFrom(name, filepath.Join(a.root, name))
+}
+
+func (a *archive) copyFrom(name, src string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			glog.Warningf("Skipping %s: %v", name, err)
+		}
+		return nil
+	}
+	return a.addFile(name, data)
+}
+
+// copyTopology adds the KFD topology, but for the caches of the nodes
+func (a *archive) copyTopology() error {
+	// the topology is usually reached through symlinks, which WalkDir
+	// doesn't follow
+	root, err := filepath.EvalSymlinks(filepath.Join(a.root, TopologyDir))
+	if err != nil {
+		return fmt.Errorf("no KFD topology found: %v", err)
+	}
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = path.Join(TopologyDir, filepath.ToSlash(rel))
+		switch {
+		case d.IsDir() && d.Name() == "caches":
+			return filepath.SkipDir
+		case d.IsDir():
+			return a.addDir(rel)
+		case d.Type().IsRegular():
+			return a.copyFrom(rel, p)
+		}
+		return nil
+	})
+}
+
+// copyDrm adds the drm nodes of a device, only their names and dev files
+// are read
+func (a *archive) copyDrm(dir string) error {
+	entries, _ := os.ReadDir(filepath.Join(a.root, dir, "drm"))
+	for _, e := range entries {
+		name := path.Join(dir, "drm", e.Name())
+		if err := a.addDir(name); err != nil {
+			return err
+		}
+		if err := a.copyFile(path.Join(name, "dev")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyDevices adds the GPUs bound to the amdgpu driver and their compute
+// partitions
+func (a *archive) copyDevices() error {
+	gpus, _ := filepath.Glob(filepath.Join(a.root, PciDriverDir, "[0-9a-fA-F][0-9a-fA-F][0-9a-fA-F][0-9a-fA-F]:*"))
+	for _, gpu := range gpus {
+		dir := path.Join(PciDriverDir, filepath.Base(gpu))
+		if err := a.addDir(dir); err != nil {
+			return err
+		}
+		for _, f := range pciFiles {
+			if err := a.copyFile(path.Join(dir, f)); err != nil {
+				return err
+			}
+		}
+		if err := a.copyDrm(dir); err != nil {
+			return err
+		}
+	}
+	partitions, _ := filepath.Glob(filepath.Join(a.root, PlatformDir, "amdgpu_xcp_*"))
+	for _, partition := range partitions {
+		dir := path.Join(PlatformDir, filepath.Base(partition))
+		if err := a.addDir(dir); err != nil {
+			return err
+		}
+		if err := a.copyDrm(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyNumaNodes adds the cpus and distances of the NUMA nodes, the NUMA
+// nodes of the GPUs are resolved from them when the firmware doesn't
+// report them
+func (a *archive) copyNumaNodes() error {
+	nodes, _ := filepath.Glob(filepath.Join(a.root, NodeDir, "node[0-9]*"))
+	for _, node := range nodes {
+		dir := path.Join(NodeDir, filepath.Base(node))
+		for _, f := range []string{"cpulist", "distance"} {
+			if err := a.copyFile(path.Join(dir, f)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Capture writes a gzipped tarball of the KFD topology, the GPUs bound to
+// the amdgpu driver, their compute partitions and the NUMA nodes found under
+// sysfsRoot.
+// The paths in the tarball are relative to sysfsRoot.
+func Capture(w io.Writer, sysfsRoot string) error {
+	zw := gzip.NewWriter(w)
+	a := &archive{
+		tw:      tar.NewWriter(zw),
+		root:    sysfsRoot,
+		dirs:    make(map[string]bool),
+		modTime: time.Now(),
+	}
+	if err := a.copyTopology(); err != nil {
+		return err
+	}
+	if err := a.copyDevices(); err != nil {
+		return err
+	}
+	if err := a.copyNumaNodes(); err != nil {
+		return err
+	}
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// Extract extracts a tarball written by Capture into dir, which can then be
+// used as the sysfs root. Only directories and regular files are extracted.
+func Extract(r io.Reader, dir string) error {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("invalid snapshot: %v", err)
+	}
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid snapshot: %v", err)
+		}
+		name := filepath.FromSlash(path.Clean(hdr.Name))
+		if !filepath.IsLocal(name) {
+			return fmt.Errorf("invalid snapshot entry %s", hdr.Name)
+		}
+		target := filepath.Join(dir, name)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// ExtractFile extracts the snapshot file into dir
+func ExtractFile(file, dir string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return Extract(f, dir)
+}
@@ -0,0 +1,168 @@
+/**
+ * Copyright 2025 Advanced Micro Devices, Inc.  All rights reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+**/
+
+package snapshot
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testTopology = "../../../testdata/topology-parsing-mi308/topology"
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newSysfsRoot lays out a sysfs tree the way the kernel does, with the KFD
+// topology and the GPU reached through symlinks
+func newSysfsRoot(t *testing.T) string {
+	root := t.TempDir()
+	topology, err := filepath.Abs(testTopology)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kfd := filepath.Join(root, "devices/virtual/kfd/kfd")
+	if err := os.MkdirAll(kfd, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(topology, filepath.Join(kfd, "topology")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "class/kfd"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../../devices/virtual/kfd/kfd", filepath.Join(root, "class/kfd/kfd")); err != nil {
+		t.Fatal(err)
+	}
+
+	gpu := filepath.Join(root, "devices/pci0000:00/0000:05:00.0")
+	writeFile(t, filepath.Join(gpu, "current_compute_partition"), "CPX\n")
+	writeFile(t, filepath.Join(gpu, "numa_node"), "0\n")
+	writeFile(t, filepath.Join(gpu, "config"), "\x02\x10\xa1\x74")
+	writeFile(t, filepath.Join(gpu, "drm/card0/dev"), "226:0\n")
+	writeFile(t, filepath.Join(gpu, "drm/renderD128/dev"), "226:128\n")
+	if err := os.MkdirAll(filepath.Join(root, PciDriverDir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(gpu, filepath.Join(root, PciDriverDir, "0000:05:00.0")); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(root, PlatformDir, "amdgpu_xcp_1/drm/renderD129/dev"), "226:129\n")
+	writeFile(t, filepath.Join(root, PlatformDir, "serial8250/driver_override"), "\n")
+	writeFile(t, filepath.Join(root, NodeDir, "node0/cpulist"), "0-7\n")
+	writeFile(t, filepath.Join(root, NodeDir, "node0/distance"), "10\n")
+	writeFile(t, filepath.Join(root, NodeDir, "node0/meminfo"), "Node 0 MemTotal: 1 kB\n")
+	return root
+}
+
+func TestCaptureExtract(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Capture(&buf, newSysfsRoot(t)); err != nil {
+		t.Fatalf("expected Capture to pass. But failed with error %v", err)
+	}
+	dir := t.TempDir()
+	if err := Extract(&buf, dir); err != nil {
+		t.Fatalf("expected Extract to pass. But failed with error %v", err)
+	}
+
+	// the topology is copied but for the caches
+	err := filepath.WalkDir(testTopology, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(testTopology, path)
+		extracted := filepath.Join(dir, TopologyDir, rel)
+		if d.IsDir() && d.Name() == "caches" {
+			if _, err := os.Stat(extracted); err == nil {
+				t.Errorf("expected %s not to be captured", rel)
+			}
+			return filepath.SkipDir
+		}
+		if d.IsDir() {
+			return nil
+		}
+		want, _ := os.ReadFile(path)
+		got, err := os.ReadFile(extracted)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("expected %s to be captured as is, got %q, %v", rel, got, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, want := range map[string]string{
+		PciDriverDir + "/0000:05:00.0/current_compute_partition": "CPX\n",
+		PciDriverDir + "/0000:05:00.0/numa_node":                 "0\n",
+		PciDriverDir + "/0000:05:00.0/drm/card0/dev":             "226:0\n",
+		PciDriverDir + "/0000:05:00.0/drm/renderD128/dev":        "226:128\n",
+		PlatformDir + "/amdgpu_xcp_1/drm/renderD129/dev":         "226:129\n",
+		NodeDir + "/node0/cpulist":                               "0-7\n",
+		NodeDir + "/node0/distance":                              "10\n",
+	} {
+		got, err := os.ReadFile(filepath.Join(dir, path))
+		if err != nil || string(got) != want {
+			t.Errorf("expected %s to be %q, got %q, %v", path, want, got, err)
+		}
+	}
+	for _, path := range []string{
+		PciDriverDir + "/0000:05:00.0/config",
+		PlatformDir + "/serial8250",
+		NodeDir + "/node0/meminfo",
+	} {
+		if _, err := os.Stat(filepath.Join(dir, path)); err == nil {
+			t.Errorf("expected %s not to be captured", path)
+		}
+	}
+}
+
+func TestCaptureWithoutTopology(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Capture(&buf, t.TempDir()); err == nil {
+		t.Errorf("expected Capture to fail without a KFD topology")
+	}
+}
+
+func TestExtractInvalidEntry(t *testing.T) {
+	for _, name := range []string{"../escape", "/abs/path", "class/../../escape"} {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(zw)
+		tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: 1})
+		tw.Write([]byte("x"))
+		tw.Close()
+		zw.Close()
+		dir := t.TempDir()
+		err := Extract(&buf, filepath.Join(dir, "root"))
+		if err == nil || !strings.Contains(err.Error(), "invalid snapshot entry") {
+			t.Errorf("expected entry %s to be rejected, got %v", name, err)
+		}
+	}
+}